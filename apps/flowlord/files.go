@@ -9,9 +9,11 @@ import (
 	"time"
 
 	"github.com/pcelvng/task"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/pcelvng/task-tools/file/stat"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 	"github.com/pcelvng/task-tools/workflow"
 )
 
@@ -115,6 +117,11 @@ func (tm *taskMaster) matchFile(sts stat.Stats) error {
 		info := tmpl.Parse(f.Template, t)
 		info, _ = tmpl.Meta(info, meta)
 
+		ctx, span := startWorkflow(f.workflowFile, "file")
+		span.SetAttributes(attribute.String("file", sts.Path))
+		tracing.Inject(ctx, meta)
+		span.End()
+
 		tsk := task.New(f.Topic(), info)
 		tsk.Job = f.Job()
 		tsk.Meta, _ = url.QueryUnescape(meta.Encode())
@@ -137,6 +144,8 @@ func (tm *taskMaster) matchFile(sts stat.Stats) error {
 		tm.taskCache.AddFileMessage(sts, taskIDs, taskNames)
 	}
 
+	fileCounter.WithLabelValues(strconv.FormatBool(matches > 0)).Inc()
+	fileTasks.Add(float64(len(taskIDs)))
 	if matches == 0 {
 		return fmt.Errorf("no match found for %q", sts.Path)
 	}
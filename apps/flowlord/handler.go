@@ -27,6 +27,7 @@ import (
 	"github.com/pcelvng/task-tools/apps/flowlord/sqlite"
 	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/slack"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 //go:embed handler/alert.tmpl
@@ -107,6 +108,7 @@ func (tm *taskMaster) StartHandler() {
 
 	router.Get("/", tm.htmlAbout)
 	router.Get("/info", tm.Info)
+	router.Handle("/metrics", tm.metricsHandler())
 	router.Get("/refresh", tm.refreshHandler)
 	router.Post("/backload", tm.Backloader)
 	router.Get("/workflow/*", tm.workflowFiles)
@@ -127,6 +129,7 @@ func (tm *taskMaster) StartHandler() {
 	})
 	router.Get("/task/{id}", tm.taskHandler)
 	router.Get("/recap", tm.recapHandler)
+	router.Get("/alerts", tm.alertsHandler)
 	router.Get("/web/alert", tm.htmlAlert)
 	router.Get("/web/files", tm.htmlFiles)
 	router.Get("/web/task", tm.htmlTask)
@@ -331,6 +334,31 @@ func (tm *taskMaster) recapHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// alertsHandler returns the alerts created after the time (RFC3339) of
+// the after param, default is the last 24 hours.
+func (tm *taskMaster) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	after := time.Now().Add(-24 * time.Hour)
+	if s := r.URL.Query().Get("after"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = t
+	}
+	alerts, err := tm.taskCache.GetAlertsAfterTime(after.UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if alerts == nil {
+		alerts = []sqlite.AlertRecord{}
+	}
+	b, _ := json.Marshal(alerts)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
 func (tm *taskMaster) workflowFiles(w http.ResponseWriter, r *http.Request) {
 	fName := chi.URLParam(r, "*")
 
@@ -844,7 +872,10 @@ func (tm *taskMaster) Backloader(w http.ResponseWriter, r *http.Request) {
 	if req.Execute {
 		resp.Status = "Executed: " + resp.Status
 		errs := appenderr.New()
+		ctx, span := startWorkflow(resp.workflow, "backload")
+		defer span.End()
 		for _, t := range resp.Tasks {
+			t.Meta = tracing.InjectMeta(ctx, t.Meta)
 			tm.taskCache.Add(t)
 			errs.Add(tm.producer.Send(t.Type, t.JSONBytes()))
 		}
@@ -868,7 +899,8 @@ type response struct {
 	Count  int
 	Tasks  []task.Task
 
-	code int
+	code     int
+	workflow string
 }
 
 func (tm *taskMaster) backload(req request) response {
@@ -943,7 +975,7 @@ func (tm *taskMaster) backload(req request) response {
 		return response{Status: err.Error(), code: http.StatusBadRequest}
 	}
 
-	return response{Tasks: tasks, Count: len(tasks), Status: strings.Join(msg, ", ")}
+	return response{Tasks: tasks, Count: len(tasks), Status: strings.Join(msg, ", "), workflow: req.Workflow}
 }
 
 func parseTime(s string) time.Time {
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -588,6 +590,54 @@ func generateSummary(tasks []sqlite.TaskView) sqlite.TaskStats {
 	return sqlite.TaskStats(data)
 }
 
+func TestAlertsHandler(t *testing.T) {
+	taskCache := &sqlite.SQLite{LocalPath: ":memory:"}
+	if err := taskCache.Open(testPath+"/workflow/", nil); err != nil {
+		t.Fatalf("Failed to create test cache: %v", err)
+	}
+	if err := taskCache.AddAlert(task.Task{ID: "id1", Type: "task1", Info: "?day=2024-01-01"}, "failed"); err != nil {
+		t.Fatal(err)
+	}
+	tm := &taskMaster{taskCache: taskCache}
+
+	type output struct {
+		Code   int
+		Alerts []string
+	}
+	fn := func(query string) (output, error) {
+		w := httptest.NewRecorder()
+		tm.alertsHandler(w, httptest.NewRequest(http.MethodGet, "/alerts"+query, nil))
+		out := output{Code: w.Code}
+		if w.Code != http.StatusOK {
+			return out, nil
+		}
+		var alerts []sqlite.AlertRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &alerts); err != nil {
+			return out, err
+		}
+		out.Alerts = make([]string, 0)
+		for _, a := range alerts {
+			out.Alerts = append(out.Alerts, a.TaskID+":"+a.Msg)
+		}
+		return out, nil
+	}
+	cases := trial.Cases[string, output]{
+		"default": {
+			Input:    "",
+			Expected: output{Code: http.StatusOK, Alerts: []string{"id1:failed"}},
+		},
+		"after": {
+			Input:    "?after=" + time.Now().Add(time.Hour).Format(time.RFC3339),
+			Expected: output{Code: http.StatusOK, Alerts: []string{}},
+		},
+		"bad time": {
+			Input:    "?after=yesterday",
+			Expected: output{Code: http.StatusBadRequest},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
 func TestBackloadHTML(t *testing.T) {
 	// Load workflow files 
 	taskCache := &sqlite.SQLite{LocalPath: ":memory:"}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 	"github.com/pcelvng/task-tools/workflow"
 )
 
@@ -30,9 +31,27 @@ type Cronjob struct {
 	// inherited from tm
 	sendFunc func(topic string, tsk *task.Task) error `uri:"-"`
 	alerts   chan task.Task
+
+	schedule cron.Schedule // parsed Schedule
+	next     time.Time     // the next scheduled run
+}
+
+// recordLag sets the delay between when the job was scheduled
+// and when it started running
+func (j *Cronjob) recordLag(now time.Time) {
+	if j.schedule == nil {
+		return
+	}
+	if !j.next.IsZero() && now.After(j.next) {
+		cronLag.WithLabelValues(j.Workflow, j.Topic, j.Name).Set(now.Sub(j.next).Seconds())
+	}
+	j.next = j.schedule.Next(now)
 }
 
 func (j *Cronjob) Run() {
+	j.recordLag(time.Now())
+	ctx, span := startWorkflow(j.Workflow, "cron")
+	defer span.End()
 	tm := time.Now().Add(j.Offset)
 	info := tmpl.Parse(j.Template, tm)
 	tsk := task.New(j.Topic, info)
@@ -42,6 +61,7 @@ func (j *Cronjob) Run() {
 		tsk.Job = j.Name
 		tsk.Meta += "&job=" + j.Name
 	}
+	tsk.Meta = tracing.InjectMeta(ctx, tsk.Meta)
 
 	if err := j.sendFunc(j.Topic, tsk); err != nil {
 		tsk.Result = task.ErrResult
@@ -74,9 +94,11 @@ func (tm *taskMaster) NewJob(ph workflow.Phase, path string) (cron.Job, error) {
 		return nil, err
 	}
 
-	if _, err := cronParser.Parse(bJob.Schedule); err != nil {
+	sched, err := cronParser.Parse(bJob.Schedule)
+	if err != nil {
 		return nil, fmt.Errorf("cron: %w", err)
 	}
+	bJob.schedule, bJob.next = sched, sched.Next(time.Now())
 
 	// return Cronjob if not batch params
 	if bJob.For == 0 && bJob.Metafile == "" && len(bJob.Meta) == 0 {
@@ -101,6 +123,9 @@ type batchJob struct {
 
 // Run a batchJob
 func (b *batchJob) Run() {
+	b.recordLag(time.Now())
+	ctx, span := startWorkflow(b.Workflow, "cron")
+	defer span.End()
 	t := time.Now().Add(b.Offset).Truncate(time.Hour)
 	tasks, err := (&Batch{
 		Template: b.Template,
@@ -122,6 +147,7 @@ func (b *batchJob) Run() {
 		return
 	}
 	for _, t := range tasks {
+		t.Meta = tracing.InjectMeta(ctx, t.Meta)
 		if err := b.sendFunc(t.Type, &t); err != nil {
 			t.Result = task.ErrResult
 			t.Msg = err.Error()
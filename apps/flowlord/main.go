@@ -15,6 +15,7 @@ import (
 	tools "github.com/pcelvng/task-tools"
 	"github.com/pcelvng/task-tools/apps/flowlord/sqlite"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 const (
@@ -33,16 +34,17 @@ Field | Field name   | Allowed values  | Allowed special characters
 )
 
 type options struct {
-	Workflow    string        `toml:"workflow" comment:"path to workflow file or directory"`
-	Refresh     time.Duration `toml:"refresh" comment:"the workflow changes refresh duration value default is 15 min"`
-	DoneTopic   string        `toml:"done_topic" comment:"default is done"`
-	FileTopic   string        `toml:"file_topic" comment:"file topic for file watching"`
-	FailedTopic string        `toml:"failed_topic" comment:"all retry failures published to this topic default is retry-failed, disable with '-'"`
-	Port        int           `toml:"status_port"`
-	Host        string        `toml:"host" comment:"host address of server "`
-	Slack       *Notification `toml:"slack"`
-	Bus         bus.Options   `toml:"bus"`
-	File        *file.Options `toml:"file"`
+	Workflow    string          `toml:"workflow" comment:"path to workflow file or directory"`
+	Refresh     time.Duration   `toml:"refresh" comment:"the workflow changes refresh duration value default is 15 min"`
+	DoneTopic   string          `toml:"done_topic" comment:"default is done"`
+	FileTopic   string          `toml:"file_topic" comment:"file topic for file watching"`
+	FailedTopic string          `toml:"failed_topic" comment:"all retry failures published to this topic default is retry-failed, disable with '-'"`
+	Port        int             `toml:"status_port"`
+	Host        string          `toml:"host" comment:"host address of server "`
+	Slack       *Notification   `toml:"slack"`
+	Bus         bus.Options     `toml:"bus"`
+	File        *file.Options   `toml:"file"`
+	Trace       tracing.Options `toml:"trace"`
 
 	DB *sqlite.SQLite `toml:"sqlite"`
 }
@@ -64,6 +66,10 @@ func main() {
 	}
 
 	config.New(opts).Version(tools.String()).Description(description).LoadOrDie()
+	shutdown, err := tracing.Init(context.Background(), name, opts.Trace)
+	if err != nil {
+		log.Fatal(err)
+	}
 	tm := New(opts)
 	sigChan := make(chan os.Signal)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
@@ -77,6 +83,9 @@ func main() {
 	if err := tm.Run(ctx); err != nil {
 		log.Fatal(err)
 	}
+	if err := shutdown(context.Background()); err != nil {
+		log.Println("trace shutdown:", err)
+	}
 
 }
 
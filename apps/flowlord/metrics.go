@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pcelvng/task"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pcelvng/task-tools/tracing"
+)
+
+const namespace = "flowlord"
+
+// durationBuckets in seconds from 1s to ~6h
+var durationBuckets = prometheus.ExponentialBuckets(1, 3, 10)
+
+var (
+	taskCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_total",
+		Help:      "The number of finished tasks by type, job and result",
+	}, []string{"type", "job", "result"})
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "The time from when a task started to when it ended",
+		Buckets:   durationBuckets,
+	}, []string{"type", "job"})
+	queueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_duration_seconds",
+		Help:      "The time from when a task was created to when it started",
+		Buckets:   durationBuckets,
+	}, []string{"type", "job"})
+	retryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_retries_total",
+		Help:      "The number of failed tasks that were retried (retry) or exceeded the retry limit (failed)",
+	}, []string{"type", "job", "status"})
+	alertCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_sent_total",
+		Help:      "The number of slack notifications sent for critical alerts and alert summaries",
+	}, []string{"kind"})
+	cronLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cron_lag_seconds",
+		Help:      "The delay between the scheduled and actual start of the last cron run",
+	}, []string{"workflow", "type", "job"})
+	fileCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_messages_total",
+		Help:      "The number of file messages received and if they matched a workflow rule",
+	}, []string{"matched"})
+	fileTasks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_tasks_total",
+		Help:      "The number of tasks created from file messages",
+	})
+)
+
+// recordTask adds a finished task to the task metrics. Durations are
+// calculated the same as the tasks view (ended-started and started-created).
+func recordTask(t task.Task) {
+	if t.Result == "" {
+		return
+	}
+	taskCounter.WithLabelValues(t.Type, t.Job, string(t.Result)).Inc()
+	created, _ := time.Parse(time.RFC3339, t.Created)
+	started, _ := time.Parse(time.RFC3339, t.Started)
+	ended, _ := time.Parse(time.RFC3339, t.Ended)
+	if !started.IsZero() && !ended.IsZero() {
+		taskDuration.WithLabelValues(t.Type, t.Job).Observe(ended.Sub(started).Seconds())
+	}
+	if !created.IsZero() && !started.IsZero() {
+		queueDuration.WithLabelValues(t.Type, t.Job).Observe(started.Sub(created).Seconds())
+	}
+}
+
+// startWorkflow starts the root span of a workflow run (cron, file or backload).
+// The span context is added to the meta of the created tasks so the tasks
+// of the run are part of the same trace.
+func startWorkflow(workflow, trigger string) (context.Context, trace.Span) {
+	return tracing.Start(context.Background(), "workflow "+workflow,
+		attribute.String("workflow", workflow),
+		attribute.String("trigger", trigger))
+}
+
+// startProcess starts the span of processing a done task as a child
+// of the span that created the task.
+func startProcess(t *task.Task) (context.Context, trace.Span) {
+	ctx, span := tracing.Start(tracing.ExtractMeta(context.Background(), t.Meta), "process "+t.Type,
+		attribute.String("task.id", t.ID),
+		attribute.String("task.type", t.Type),
+		attribute.String("task.job", t.Job),
+		attribute.String("task.result", string(t.Result)))
+	if t.Result == task.ErrResult || t.Result == task.AlertResult {
+		span.SetStatus(codes.Error, t.Msg)
+	}
+	return ctx, span
+}
+
+// metricsHandler serves the flowlord metrics in the prometheus format
+func (tm *taskMaster) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		taskCounter, taskDuration, queueDuration, retryCounter,
+		alertCounter, cronLag, fileCounter, fileTasks,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "db_size_bytes",
+			Help:      "The size of the sqlite task cache",
+		}, func() float64 {
+			info, err := tm.taskCache.GetDBSize()
+			if err != nil {
+				return 0
+			}
+			return float64(info.PageCount * info.PageSize)
+		}),
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pcelvng/task"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/pcelvng/task-tools/apps/flowlord/sqlite"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	taskCache := &sqlite.SQLite{LocalPath: ":memory:"}
+	if err := taskCache.Open(testPath+"/workflow/", nil); err != nil {
+		t.Fatalf("Failed to create test cache: %v", err)
+	}
+	tm := &taskMaster{taskCache: taskCache}
+
+	recordTask(task.Task{
+		Type:    "metric-worker",
+		Job:     "job1",
+		Result:  task.CompleteResult,
+		Created: "2024-01-01T00:00:00Z",
+		Started: "2024-01-01T00:00:10Z",
+		Ended:   "2024-01-01T00:01:10Z",
+	})
+	// tasks without a result are not counted
+	recordTask(task.Task{Type: "metric-worker", Job: "job2"})
+
+	w := httptest.NewRecorder()
+	tm.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, s := range []string{
+		`flowlord_tasks_total{job="job1",result="complete",type="metric-worker"} 1`,
+		`flowlord_task_duration_seconds_sum{job="job1",type="metric-worker"} 60`,
+		`flowlord_queue_duration_seconds_sum{job="job1",type="metric-worker"} 10`,
+		"flowlord_db_size_bytes",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %q in metrics", s)
+		}
+	}
+	if strings.Contains(body, `job="job2"`) {
+		t.Error("unexpected task without a result in metrics")
+	}
+}
+
+func TestWorkflowTrace(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	var sent task.Task
+	j := &Cronjob{
+		Name:     "j1",
+		Workflow: "f1.toml",
+		Topic:    "task1",
+		Template: "?day={YYYY}-{MM}-{DD}",
+		sendFunc: func(_ string, tsk *task.Task) error {
+			sent = *tsk
+			return nil
+		},
+	}
+	j.Run()
+	if !strings.Contains(sent.Meta, "&traceparent=") {
+		t.Fatalf("expected trace context in meta %q", sent.Meta)
+	}
+
+	sent.Result = task.CompleteResult
+	_, span := startProcess(&sent)
+	span.End()
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+	if spans[0].Name() != "workflow f1.toml" || spans[1].Name() != "process task1" {
+		t.Errorf("unexpected spans %q %q", spans[0].Name(), spans[1].Name())
+	}
+	if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+		t.Error("expected process span to be a child of the workflow span")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/slack"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
@@ -293,6 +294,9 @@ func (tm *taskMaster) schedule() (err error) {
 func (tm *taskMaster) Process(t *task.Task) error {
 	meta, _ := url.ParseQuery(t.Meta)
 	tm.taskCache.Add(*t)
+	recordTask(*t)
+	ctx, span := startProcess(t)
+	defer span.End()
 	// attempt to retry
 	switch t.Result {
 	case task.WarnResult:
@@ -317,10 +321,12 @@ func (tm *taskMaster) Process(t *task.Task) error {
 
 				meta.Set("delayed", gtools.PrintDuration(delay))
 			}
+			retryCounter.WithLabelValues(t.Type, t.Job, "retry").Inc()
 			t = task.NewWithID(t.Type, t.Info, t.ID)
 			t.Job = p.Job()
 			i++
 			meta.Set("retry", strconv.Itoa(i))
+			tracing.Inject(ctx, meta)
 			t.Meta = meta.Encode()
 			go func() {
 				time.Sleep(delay)
@@ -333,6 +339,7 @@ func (tm *taskMaster) Process(t *task.Task) error {
 			return nil
 		}
 		// send to the retry failed topic if retries > p.Retry
+		retryCounter.WithLabelValues(t.Type, t.Job, "failed").Inc()
 		meta.Set("retry", "failed")
 		meta.Set("retried", strconv.Itoa(p.Retry))
 		t.Meta = meta.Encode()
@@ -395,7 +402,7 @@ func (tm *taskMaster) Process(t *task.Task) error {
 				if child.Job != "" {
 					childMeta += "&job=" + child.Job
 				}
-				child.Meta = childMeta
+				child.Meta = tracing.InjectMeta(ctx, childMeta)
 
 				tm.taskCache.Add(child)
 				if err := tm.producer.Send(child.Type, child.JSONBytes()); err != nil {
@@ -525,6 +532,8 @@ func (tm *taskMaster) handleNotifications(taskChan chan task.Task, ctx context.C
 				b, _ := json.MarshalIndent(tsk, "", " ")
 				if err := tm.slack.Slack.Notify(string(b), slack.Critical); err != nil {
 					log.Println(err)
+				} else {
+					alertCounter.WithLabelValues("critical").Inc()
 				}
 			} else { // if the task result is not an alert result add to the tasks list summary
 				if err := tm.taskCache.AddAlert(tsk, tsk.Msg); err != nil {
@@ -568,6 +577,7 @@ func (tm *taskMaster) sendAlertSummary(alerts []sqlite.AlertRecord) error {
 		if err := tm.slack.Notify(message.String(), slack.Critical); err != nil {
 			return fmt.Errorf("failed to send alert summary to Slack: %w", err)
 		}
+		alertCounter.WithLabelValues("summary").Inc()
 	}
 
 	return nil
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pcelvng/task/bus"
+
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// gcsEvent is the object resource sent by GCS bucket notifications
+// (the same payload read by flowlord).
+// see https://cloud.google.com/storage/docs/json_api/v1/objects#resource
+type gcsEvent struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Bucket      string    `json:"bucket"`
+	Size        string    `json:"size"`
+	MD5Hash     string    `json:"md5Hash"`
+	TimeCreated time.Time `json:"timeCreated"`
+}
+
+// s3Event is a S3 or MinIO bucket notification
+// see https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type s3Event struct {
+	Records []struct {
+		EventName string    `json:"eventName"`
+		EventTime time.Time `json:"eventTime"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// parseEvent normalizes a bucket notification message to file stats.
+// GCS object resources, S3/MinIO created events and stat.Stats
+// messages are supported. Other S3 events (deletes) are ignored.
+func parseEvent(b []byte) []stat.Stats {
+	var s3 s3Event
+	if err := json.Unmarshal(b, &s3); err == nil && len(s3.Records) > 0 {
+		files := make([]stat.Stats, 0, len(s3.Records))
+		for _, r := range s3.Records {
+			if !strings.Contains(r.EventName, "ObjectCreated") {
+				continue
+			}
+			// object keys are url encoded
+			key, err := url.QueryUnescape(r.S3.Object.Key)
+			if err != nil {
+				key = r.S3.Object.Key
+			}
+			files = append(files, stat.Stats{
+				Path:     "s3://" + r.S3.Bucket.Name + "/" + key,
+				Size:     r.S3.Object.Size,
+				Checksum: strings.Trim(r.S3.Object.ETag, `"`),
+				Created:  r.EventTime.UTC().Format(time.RFC3339),
+			})
+		}
+		return files
+	}
+
+	var gcs gcsEvent
+	if err := json.Unmarshal(b, &gcs); err == nil && gcs.ID != "" && gcs.Name != "" {
+		size, _ := strconv.ParseInt(gcs.Size, 10, 64)
+		return []stat.Stats{{
+			Path:     "gs://" + gcs.Bucket + "/" + gcs.Name,
+			Size:     size,
+			Checksum: gcs.MD5Hash,
+			Created:  gcs.TimeCreated.UTC().Format(time.RFC3339),
+		}}
+	}
+
+	var sts stat.Stats
+	if err := json.Unmarshal(b, &sts); err == nil && sts.Path != "" && !sts.IsDir {
+		return []stat.Stats{sts}
+	}
+	return nil
+}
+
+// readEvents sends files from bus notifications to the event watchers
+// that match the file path until the consumer is done.
+func readEvents(c bus.Consumer, watchers []*watcher) {
+	for {
+		b, done, err := c.Msg()
+		if err != nil {
+			log.Println("event consumer:", err)
+			return
+		}
+		for _, sts := range parseEvent(b) {
+			for _, w := range watchers {
+				if !w.rule.Events {
+					continue
+				}
+				// each watcher gets its own copy with the path of its rule
+				if pth, ok := w.matchEvent(sts.Path); ok {
+					s := sts
+					s.Path = pth
+					w.notify(s)
+				}
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// objectSchemes are the bucket schemes that can receive notifications
+// S3 notifications also match minio (mc://) paths
+var objectSchemes = map[string]bool{"s3": true, "gs": true, "gcs": true, "mc": true, "minio": true}
+
+// matchEvent checks if the bucket event path matches the rule.
+// The path is returned with the scheme of the rule, so a s3://
+// notification can match a mc:// path_template.
+func (w *watcher) matchEvent(pth string) (string, bool) {
+	if w.match(pth) {
+		return pth, true
+	}
+	scheme, rest, found := strings.Cut(pth, "://")
+	tmplScheme, _, _ := strings.Cut(w.rule.PathTemplate, "://")
+	if !found || !objectSchemes[scheme] || !objectSchemes[tmplScheme] {
+		return "", false
+	}
+	pth = tmplScheme + "://" + rest
+	return pth, w.match(pth)
+}
+
+// isLocal reports if the path is on the local file system
+func isLocal(pth string) bool {
+	return !strings.Contains(pth, "://")
+}
+
+// watchLocal starts a fsnotify watcher that sends new and
+// changed files in the rule directories
+func (w *watcher) watchLocal() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w.fsw = fsw
+	go func() {
+		for {
+			select {
+			case ev, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write) {
+					w.localEvent(ev.Name)
+				}
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("%v fsnotify: %v", w.rule.PathTemplate, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *watcher) localEvent(pth string) {
+	if !w.match(pth) {
+		return
+	}
+	info, err := os.Stat(pth)
+	if err != nil || info.IsDir() {
+		return
+	}
+	// same values as a local file.List
+	w.notify(stat.Stats{
+		Path:    pth,
+		Size:    info.Size(),
+		Created: info.ModTime().Format(time.RFC3339),
+	})
+}
+
+// watchDirs updates the local event watcher to the directories of the
+// current lookback paths. Directories with glob patterns are not watched
+// and are only found by polling.
+func (w *watcher) watchDirs(paths []string) {
+	if w.fsw == nil {
+		return
+	}
+	dirs := make(map[string]bool)
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		if strings.ContainsAny(dir, "*?[") {
+			continue
+		}
+		dirs[dir] = true
+		if err := w.fsw.Add(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("%v fsnotify %s: %v", w.rule.PathTemplate, dir, err)
+		}
+	}
+	for _, dir := range w.fsw.WatchList() {
+		if !dirs[dir] {
+			w.fsw.Remove(dir)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	tools "github.com/pcelvng/task-tools"
 	"github.com/pcelvng/task-tools/bootstrap"
+	"github.com/pcelvng/task-tools/file"
 )
 
 const (
@@ -23,15 +24,28 @@ example rule:
 	frequency = "1h"
 	task_template = "{WATCH_FILE}?&param=other-param&dest=gs://folder/{HOUR_SLUG}/file.json"
 	lookback = 24
-	path_template = "gs://folder/{HOUR_SLUG}/*.json"`
+	path_template = "gs://folder/{HOUR_SLUG}/*.json"
+
+set events = true on a rule to send files as they are written. Local paths are watched
+with fsnotify and bucket paths use the S3, GCS or MinIO notifications read from the event_topic.
+Polling continues at the rule frequency to find any files missed by the events.
+
+set settle = "5m" on a rule to only send files once their size and modified time have not changed
+for the settle period. set marker = "_SUCCESS" to only send files once the marker file exists in
+their directory, so all the files of a directory are sent together.`
 )
 
 type options struct {
-	Bus *bus.Options `toml:"bus"`
+	Bus        *bus.Options `toml:"bus"`
+	StatusPort int          `toml:"status_port" desc:"http port for the status endpoint (disabled when 0)"`
+	StateFile  string       `toml:"state_file" desc:"local or remote file to persist the seen files of each rule between restarts"`
 
 	FilesTopic string `toml:"files_topic" desc:"topic override (default is files) disable with -"`
 	TaskTopic  string `toml:"task_topic" desc:"topic to send new task"`
 
+	EventTopic   string `toml:"event_topic" desc:"topic of S3, GCS or MinIO bucket notifications for event rules"`
+	EventChannel string `toml:"event_channel" desc:"channel (pubsub subscription) of the event_topic default: filewatcher"`
+
 	AccessKey string `toml:"access_key" desc:"secret token for S3/GCS access "`
 	SecretKey string `toml:"secret_key" desc:"secret key for S3/GCS access "`
 
@@ -43,6 +57,9 @@ type Rule struct {
 	PathTemplate string `toml:"path_template" desc:"source file path pattern to match (supports glob style matching)"`
 	Frequency    string `toml:"frequency" desc:"the wait time between checking for new files in the path_template"`
 	TaskTemplate string `toml:"task_template" desc:"the template for the info string to send to the info_topic"`
+	Settle       string `toml:"settle" desc:"the time a new file's size and modified time must be unchanged before it is sent"`
+	Marker       string `toml:"marker" desc:"file name (_SUCCESS) that must exist in a file's directory before it is sent"`
+	Events       bool   `toml:"events" desc:"send files from fsnotify (local paths) or the event_topic (buckets) as they are written, polling at frequency finds missed files"`
 }
 
 func (o options) Validate() error {
@@ -59,10 +76,18 @@ func (o options) Validate() error {
 	return errs.ErrOrNil()
 }
 
+func (o *options) fileOptions() *file.Options {
+	return &file.Options{
+		AccessKey: o.AccessKey,
+		SecretKey: o.SecretKey,
+	}
+}
+
 func main() {
 	opt := &options{
-		Bus:        bus.NewOptions(""),
-		FilesTopic: "files",
+		Bus:          bus.NewOptions(""),
+		FilesTopic:   "files",
+		EventChannel: "filewatcher",
 		Rules: []*Rule{
 			{
 				HourLookback: defaultLookback,
@@ -74,7 +99,7 @@ func main() {
 	}
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	bootstrap.NewUtility(appName, opt).
+	app := bootstrap.NewUtility(appName, opt).
 		Description(description).
 		Version(tools.String()).Initialize()
 
@@ -88,6 +113,23 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	app.AddInfo(func() interface{} {
+		s := make([]watcherStatus, len(watchers))
+		for i, w := range watchers {
+			s[i] = w.status()
+		}
+		return s
+	}, opt.StatusPort)
+
+	var events bus.Consumer
+	if opt.EventTopic != "" {
+		bOpt := *opt.Bus
+		bOpt.InTopic, bOpt.InChannel = opt.EventTopic, opt.EventChannel
+		if events, err = bus.NewConsumer(&bOpt); err != nil {
+			log.Fatal("event consumer: ", err)
+		}
+		go readEvents(events, watchers)
+	}
 
 	for i := range watchers {
 		go func(index int) {
@@ -101,6 +143,9 @@ func main() {
 	select {
 	case <-sigChan:
 		log.Println("closing...")
+		if events != nil {
+			events.Stop()
+		}
 
 		if err = closeWatchers(watchers); err != nil {
 			log.Fatal(err)
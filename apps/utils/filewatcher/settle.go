@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustinevan/chron"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// pendingFile is a new file waiting to settle or for its marker
+type pendingFile struct {
+	sts   *stat.Stats
+	since time.Time // time the size and created were last changed
+}
+
+// ready returns the new files that can be sent. A file is ready once its
+// size and created time are unchanged for the settle period and the
+// marker exists in its directory. Other files are added to pending.
+//
+// Must be called with w.mu locked.
+func (w *watcher) ready(newFiles fileList, markers map[string]bool, now time.Time) fileList {
+	if w.settle == 0 && w.rule.Marker == "" {
+		return newFiles
+	}
+	ready := make(fileList)
+	for p, f := range newFiles {
+		pf, found := w.pending[p]
+		if !found || pf.sts.Size != f.Size || pf.sts.Created != f.Created {
+			pf = &pendingFile{sts: f, since: now}
+			w.pending[p] = pf
+		}
+		if now.Sub(pf.since) < w.settle {
+			continue
+		}
+		if w.rule.Marker != "" && !markers[dir(p)] {
+			continue
+		}
+		delete(w.pending, p)
+		ready[p] = f
+	}
+	return ready
+}
+
+// markers checks if the marker file exists in the directory of each file
+func (w *watcher) markers(files fileList) map[string]bool {
+	if w.rule.Marker == "" {
+		return nil
+	}
+	m := make(map[string]bool)
+	for p := range files {
+		d := dir(p)
+		if _, found := m[d]; found {
+			continue
+		}
+		_, err := file.Stat(d+"/"+w.rule.Marker, w.appOpt.fileOptions())
+		m[d] = err == nil
+	}
+	return m
+}
+
+// isMarker checks if the path is a marker file in a directory of the rule
+func (w *watcher) isMarker(pth string) bool {
+	if w.rule.Marker == "" || filepath.Base(pth) != w.rule.Marker {
+		return false
+	}
+	paths := getPaths(w.rule.PathTemplate, chron.ThisHour(), w.lookback)
+	var dirs []string
+	for _, p := range paths {
+		dirs = append(dirs, dir(p))
+	}
+	return matchAny(paths, pth) || matchAny(dirs, dir(pth))
+}
+
+// wakeup checks the pending files after the settle period
+// instead of waiting for the next poll
+func (w *watcher) wakeup() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sleep waits for the next poll. Pending files are checked every settle
+// period, an event resets the wait to the settle period so files are
+// checked once they stop changing.
+func (w *watcher) sleep(d time.Duration) {
+	w.mu.Lock()
+	pending := len(w.pending)
+	w.mu.Unlock()
+	if pending > 0 && w.settle > 0 && w.settle < d {
+		d = w.settle
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return
+		case <-w.wake:
+			if w.settle < d {
+				timer.Reset(w.settle)
+			}
+		}
+	}
+}
+
+// dir returns the directory of the path without cleaning
+// the path, so the scheme of a remote path is kept (gs://)
+func dir(pth string) string {
+	if i := strings.LastIndex(pth, "/"); i >= 0 {
+		return pth[:i]
+	}
+	return "."
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// state is the seen files of each rule persisted to the state_file
+// so a restart neither resends files nor misses files written
+// while the watcher was down. A nil state is not persisted.
+type state struct {
+	path string
+	opts *file.Options
+
+	mu    sync.Mutex
+	dirty bool                  // files changed since the last successful save
+	Rules map[string]*ruleState `json:"rules"` // key is the rule path_template
+}
+
+type ruleState struct {
+	LastScan time.Time           `json:"last_scan"`
+	Files    map[string]seenFile `json:"files"`
+}
+
+// seenFile is the part of stat.Stats compared to find new files
+type seenFile struct {
+	Size     int64  `json:"size"`
+	Created  string `json:"created,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// loadState reads the state file. A missing file is an empty state.
+func loadState(pth string, opts *file.Options) (*state, error) {
+	if pth == "" {
+		return nil, nil
+	}
+	s := &state{path: pth, opts: opts, Rules: make(map[string]*ruleState)}
+	if _, err := file.Stat(pth, opts); err != nil {
+		log.Printf("state file %s not found, starting with an empty cache", pth)
+		return s, nil
+	}
+	r, err := file.NewReader(pth, opts)
+	if err != nil {
+		return nil, fmt.Errorf("state reader %s: %w", pth, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("state read %s: %w", pth, err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("state decode %s: %w", pth, err)
+	}
+	if s.Rules == nil {
+		s.Rules = make(map[string]*ruleState)
+	}
+	return s, nil
+}
+
+// get returns the cached files and last scan time of the rule
+func (s *state) get(rule string) (fileList, time.Time) {
+	cache := make(fileList)
+	if s == nil {
+		return cache, time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs, found := s.Rules[rule]
+	if !found {
+		return cache, time.Time{}
+	}
+	for pth, f := range rs.Files {
+		cache[pth] = &stat.Stats{Path: pth, Size: f.Size, Created: f.Created, Checksum: f.Checksum}
+	}
+	return cache, rs.LastScan
+}
+
+// update replaces the files of the rule and saves the state file.
+// The file is only written when the files changed, the last scan time
+// of an unchanged rule is saved with the next change.
+func (s *state) update(rule string, files fileList, scan time.Time) error {
+	if s == nil {
+		return nil
+	}
+	rs := &ruleState{LastScan: scan, Files: make(map[string]seenFile, len(files))}
+	for pth, f := range files {
+		rs.Files[pth] = seenFile{Size: f.Size, Created: f.Created, Checksum: f.Checksum}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, found := s.Rules[rule]; !found || !maps.Equal(prev.Files, rs.Files) {
+		s.dirty = true
+	}
+	s.Rules[rule] = rs
+	if !s.dirty {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *state) save() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	w, err := file.NewWriter(s.path, s.opts)
+	if err != nil {
+		return fmt.Errorf("state writer %s: %w", s.path, err)
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Abort()
+		return fmt.Errorf("state write %s: %w", s.path, err)
+	}
+	return w.Close()
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dustinevan/chron"
+	"github.com/fsnotify/fsnotify"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
@@ -32,6 +36,24 @@ type watcher struct {
 	rule      *Rule
 	lookback  int    // the number of hours to look back in previous folders based on date
 	frequency string // the duration between checking for new files
+	state     *state // persisted seen files (optional)
+	fsw       *fsnotify.Watcher
+
+	settle time.Duration // time a file must be unchanged before it is sent
+	wake   chan struct{} // check pending files before the next poll
+
+	mu       sync.Mutex
+	cache    fileList                // files already sent
+	pending  map[string]*pendingFile // new files that are not ready to send
+	lastScan time.Time               // time of the last completed scan
+}
+
+// watcherStatus is the status of a rule shown on the status endpoint
+type watcherStatus struct {
+	PathTemplate string    `json:"path_template"`
+	CacheSize    int       `json:"cache_size"`
+	Pending      int       `json:"pending"`
+	LastScan     time.Time `json:"last_scan"`
 }
 
 // newWatchers creates new watchers based on the options provided in configuration files
@@ -43,17 +65,37 @@ func newWatchers(appOpt *options) (watchers []*watcher, err error) {
 		return nil, err
 	}
 
+	st, err := loadState(appOpt.StateFile, appOpt.fileOptions())
+	if err != nil {
+		return nil, err
+	}
+
 	for _, r := range appOpt.Rules {
 		if r.Frequency == "" {
 			r.Frequency = defaultFrequency
 		}
 
+		var settle time.Duration
+		if r.Settle != "" {
+			if settle, err = time.ParseDuration(r.Settle); err != nil {
+				return nil, fmt.Errorf("bad settle %q: %w", r.Settle, err)
+			}
+		}
+
+		// cached file list for the watcher restored from the state file
+		cache, lastScan := st.get(r.PathTemplate)
 		watchers = append(watchers, &watcher{
 			producer:  producer,
 			appOpt:    appOpt,
 			rule:      r,
 			lookback:  r.HourLookback,
 			frequency: r.Frequency,
+			state:     st,
+			settle:    settle,
+			wake:      make(chan struct{}, 1),
+			cache:     cache,
+			pending:   make(map[string]*pendingFile),
+			lastScan:  lastScan,
 		})
 	}
 	return watchers, err
@@ -61,6 +103,9 @@ func newWatchers(appOpt *options) (watchers []*watcher, err error) {
 
 // Close closes the producer and sends sends a close signal
 func (w *watcher) close() error {
+	if w.fsw != nil {
+		w.fsw.Close()
+	}
 	// close the producer
 	if err := w.producer.Stop(); err != nil {
 		return err
@@ -89,28 +134,128 @@ func (w *watcher) runWatch() (err error) {
 		return err
 	}
 
-	// new cached file list for the current watcher
-	cache := make(fileList)
+	// local files are sent as they are written, polling
+	// finds any files missed by the events
+	if w.rule.Events && isLocal(w.rule.PathTemplate) {
+		if err := w.watchLocal(); err != nil {
+			log.Printf("%v local events disabled: %v", w.rule.PathTemplate, err)
+		}
+	}
 
-	for ; ; time.Sleep(d) {
+	for {
 		// update the files and cache and run the watchers rules
 		currentHour := chron.ThisHour()
 		lookbackFiles := getPaths(w.rule.PathTemplate, currentHour, w.lookback)
+		w.watchDirs(lookbackFiles)
 
 		// send the new files, re cache those new files
-		cache = w.process(cache, lookbackFiles...)
+		cache := w.process(lookbackFiles...)
+		if err := w.state.update(w.rule.PathTemplate, cache, time.Now()); err != nil {
+			log.Println("state:", err)
+		}
+		w.sleep(d)
+	}
+}
+
+func (w *watcher) status() watcherStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return watcherStatus{
+		PathTemplate: w.rule.PathTemplate,
+		CacheSize:    len(w.cache),
+		Pending:      len(w.pending),
+		LastScan:     w.lastScan,
 	}
 }
 
 // get the current files for the request path(s)
 // compare those files with the current cache for this watcher
-// find any new files not listed in the cache and send to the Bus
-func (w *watcher) process(currentCache fileList, path ...string) (currentFiles fileList) {
-	currentFiles = w.currentFiles(path...)
-	newFiles := compareFileList(currentCache, currentFiles)
-	w.sendFiles(newFiles)
-	log.Printf("%v found %d files with %d new files", w.rule.PathTemplate, len(currentFiles), len(newFiles))
-	return currentFiles
+// find any new files not listed in the cache and send to the Bus.
+// Returns a copy of the updated cache.
+func (w *watcher) process(path ...string) fileList {
+	currentFiles := w.currentFiles(path...)
+	markers := w.markers(currentFiles)
+
+	w.mu.Lock()
+	now := time.Now()
+	newFiles := compareFileList(w.cache, currentFiles)
+	readyFiles := w.ready(newFiles, markers, now)
+	// files that are not ready are compared again on the next check
+	for p := range newFiles {
+		if _, found := readyFiles[p]; !found {
+			delete(currentFiles, p)
+		}
+	}
+	for p := range w.pending {
+		if !matchAny(path, p) {
+			delete(w.pending, p)
+		}
+	}
+	if w.rule.Events {
+		// keep files sent from events that are not listed yet
+		for p, f := range w.cache {
+			if _, found := currentFiles[p]; !found && matchAny(path, p) {
+				currentFiles[p] = f
+			}
+		}
+	}
+	w.cache, w.lastScan = currentFiles, now
+	cache := make(fileList, len(currentFiles))
+	for p, f := range currentFiles {
+		cache[p] = f
+	}
+	pending := len(w.pending)
+	w.mu.Unlock()
+
+	w.sendFiles(readyFiles)
+	log.Printf("%v found %d files with %d new files (%d pending)", w.rule.PathTemplate, len(currentFiles), len(readyFiles), pending)
+	return cache
+}
+
+// notify sends the file from an event if it is not in the cache
+func (w *watcher) notify(sts stat.Stats) {
+	if w.isMarker(sts.Path) {
+		w.wakeup()
+		return
+	}
+	files := fileList{sts.Path: &sts}
+	markers := w.markers(files)
+
+	w.mu.Lock()
+	newFiles := compareFileList(w.cache, files)
+	readyFiles := w.ready(newFiles, markers, time.Now())
+	for p, f := range readyFiles {
+		w.cache[p] = f
+	}
+	w.mu.Unlock()
+
+	if len(readyFiles) > 0 {
+		log.Printf("%v event %v", w.rule.PathTemplate, sts.Path)
+	}
+	if len(readyFiles) < len(newFiles) {
+		w.wakeup()
+	}
+	w.sendFiles(readyFiles)
+}
+
+// match checks if the file path matches the rule path_template
+// for any of the lookback hours
+func (w *watcher) match(pth string) bool {
+	return matchAny(getPaths(w.rule.PathTemplate, chron.ThisHour(), w.lookback), pth)
+}
+
+// matchAny checks if the path matches one of the glob patterns
+// or is a file in one of the directories (a listed path)
+func matchAny(patterns []string, pth string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, pth); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(strings.TrimSuffix(p, "/"), dir(pth)); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // get the unique paths, check for all paths for each of the lookback hours
@@ -133,20 +278,17 @@ func getPaths(pathTmpl string, start chron.Hour, lookback int) []string {
 }
 
 // currentFiles retrieves the current files from the directory path(s)
-func (w watcher) currentFiles(paths ...string) fileList {
+func (w *watcher) currentFiles(paths ...string) fileList {
 	fileList := make(fileList)
 	for _, p := range paths {
-		list, err := file.List(p, &file.Options{
-			AccessKey: w.appOpt.AccessKey,
-			SecretKey: w.appOpt.SecretKey,
-		})
+		list, err := file.List(p, w.appOpt.fileOptions())
 		if err != nil {
 			log.Printf("issue listing %v: %v", p, err)
 			continue
 		}
 		// iterate over the list to set up the new complete fileList
 		for i := range list {
-			if list[i].IsDir {
+			if list[i].IsDir || w.isMarker(list[i].Path) {
 				continue
 			}
 			fileList[list[i].Path] = &list[i]
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"os"
 	"testing"
 	"time"
 
+	"github.com/dustinevan/chron"
+	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/file/stat"
 	"github.com/pcelvng/task/bus"
+	"github.com/pcelvng/task/bus/nop"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -151,3 +155,241 @@ func TestCompareFileList(t *testing.T) {
 	assert.Equal(t, 3, len(newFiles))
 	assert.Equal(t, newFiles["new_file_four"], curFiles["new_file_four"])
 }
+
+func TestState(t *testing.T) {
+	pth := t.TempDir() + "/state.json"
+
+	// missing state file starts empty
+	s, err := loadState(pth, &file.Options{})
+	assert.Nil(t, err)
+	cache, last := s.get("gs://folder/*.json")
+	assert.Equal(t, 0, len(cache))
+	assert.True(t, last.IsZero())
+
+	scan := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := fileList{
+		"gs://folder/a.json": {Path: "gs://folder/a.json", Size: 10, Created: "2020-01-02T03:00:00Z", Checksum: "abc"},
+		"gs://folder/b.json": {Path: "gs://folder/b.json", Size: 20},
+	}
+	assert.Nil(t, s.update("gs://folder/*.json", files, scan))
+
+	// reload the saved state
+	s, err = loadState(pth, &file.Options{})
+	assert.Nil(t, err)
+	cache, last = s.get("gs://folder/*.json")
+	assert.Equal(t, files, cache)
+	assert.Equal(t, scan, last.UTC())
+	cache, _ = s.get("other")
+	assert.Equal(t, 0, len(cache))
+
+	// a restart does not resend seen files
+	assert.Equal(t, 0, len(compareFileList(cache, fileList{})))
+	cache, _ = s.get("gs://folder/*.json")
+	assert.Equal(t, 0, len(compareFileList(cache, files)))
+
+	// the state file is only written when the files change
+	info, _ := os.Stat(pth)
+	os.Chtimes(pth, time.Time{}, info.ModTime().Add(-time.Hour))
+	assert.Nil(t, s.update("gs://folder/*.json", files, scan.Add(time.Minute)))
+	unchanged, _ := os.Stat(pth)
+	assert.Equal(t, info.ModTime().Add(-time.Hour), unchanged.ModTime())
+	files["gs://folder/c.json"] = &stat.Stats{Path: "gs://folder/c.json", Size: 30}
+	assert.Nil(t, s.update("gs://folder/*.json", files, scan.Add(2*time.Minute)))
+	s, _ = loadState(pth, &file.Options{})
+	cache, last = s.get("gs://folder/*.json")
+	assert.Equal(t, 3, len(cache))
+	assert.Equal(t, scan.Add(2*time.Minute), last.UTC())
+
+	// nil state is not persisted
+	var ns *state
+	assert.Nil(t, ns.update("rule", files, scan))
+	cache, _ = ns.get("rule")
+	assert.Equal(t, 0, len(cache))
+}
+
+func TestStateInvalid(t *testing.T) {
+	pth := t.TempDir() + "/state.json"
+	os.WriteFile(pth, []byte("{bad"), 0644)
+	_, err := loadState(pth, &file.Options{})
+	assert.NotNil(t, err)
+
+	o := &options{StateFile: pth, Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules, &Rule{})
+	_, err = newWatchers(o)
+	assert.NotNil(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	o := &options{}
+	o.Bus = &bus.Options{
+		Bus:     "nop",
+		NopMock: "msg_msg_done",
+	}
+	o.Rules = append(o.Rules, &Rule{PathTemplate: "nop://folder/*.json"})
+
+	ws, _ := newWatchers(o)
+	scan := time.Now()
+	ws[0].cache = fileList{"nop://folder/a.json": &stat.Stats{}, "nop://folder/b.json": &stat.Stats{}}
+	ws[0].lastScan = scan
+	assert.Equal(t, watcherStatus{PathTemplate: "nop://folder/*.json", CacheSize: 2, LastScan: scan}, ws[0].status())
+}
+
+func TestParseEvent(t *testing.T) {
+	gcs := `{"kind":"storage#object","id":"bucket/path/2020/01/02/file.json/1","name":"path/2020/01/02/file.json","bucket":"bucket","size":"123","md5Hash":"abc=","timeCreated":"2020-01-02T03:04:05.123Z"}`
+	s3 := `{"Records":[
+		{"eventName":"ObjectCreated:Put","eventTime":"2020-01-02T03:04:05.000Z","s3":{"bucket":{"name":"bucket"},"object":{"key":"path/my+file%3D1.json","size":10,"eTag":"\"abc\""}}},
+		{"eventName":"ObjectRemoved:Delete","eventTime":"2020-01-02T03:04:05.000Z","s3":{"bucket":{"name":"bucket"},"object":{"key":"path/old.json"}}}]}`
+	minio := `{"EventName":"s3:ObjectCreated:Put","Key":"bucket/a.json","Records":[{"eventName":"s3:ObjectCreated:Put","eventTime":"2020-01-02T03:04:05Z","s3":{"bucket":{"name":"bucket"},"object":{"key":"a.json","size":5,"eTag":"def"}}}]}`
+
+	assert.Equal(t, []stat.Stats{{Path: "gs://bucket/path/2020/01/02/file.json", Size: 123, Checksum: "abc=", Created: "2020-01-02T03:04:05Z"}}, parseEvent([]byte(gcs)))
+	assert.Equal(t, []stat.Stats{{Path: "s3://bucket/path/my file=1.json", Size: 10, Checksum: "abc", Created: "2020-01-02T03:04:05Z"}}, parseEvent([]byte(s3)))
+	assert.Equal(t, []stat.Stats{{Path: "s3://bucket/a.json", Size: 5, Checksum: "def", Created: "2020-01-02T03:04:05Z"}}, parseEvent([]byte(minio)))
+	assert.Equal(t, []stat.Stats{{Path: "gs://bucket/b.json", Size: 7}}, parseEvent([]byte(`{"path":"gs://bucket/b.json","size":7}`)))
+	assert.Nil(t, parseEvent([]byte(`{"path":"gs://bucket/dir","isDir":true}`)))
+	assert.Nil(t, parseEvent([]byte(`invalid`)))
+}
+
+// msgConsumer returns each message and is then done
+type msgConsumer struct {
+	nop.Consumer
+	msgs []string
+}
+
+func (c *msgConsumer) Msg() ([]byte, bool, error) {
+	if len(c.msgs) == 0 {
+		return nil, true, nil
+	}
+	m := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return []byte(m), false, nil
+}
+
+func TestReadEvents(t *testing.T) {
+	o := &options{FilesTopic: "files", Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules,
+		&Rule{PathTemplate: "gs://bucket/{YYYY}/*.json", Events: true},
+		&Rule{PathTemplate: "mc://bucket/*.csv", Events: true},
+		&Rule{PathTemplate: "gs://bucket/*/*.json"}, // polling only
+	)
+	ws, _ := newWatchers(o)
+	year := time.Now().Format("2006")
+	readEvents(&msgConsumer{msgs: []string{
+		`{"path":"gs://bucket/` + year + `/a.json","size":1}`,
+		`{"path":"gs://bucket/` + year + `/a.json","size":1}`, // duplicate
+		`{"path":"gs://bucket/2001/b.json","size":1}`,         // outside lookback
+		`{"Records":[{"eventName":"s3:ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"c.csv","size":5}}}]}`,
+	}}, ws)
+
+	p := ws[0].producer.(*nop.Producer)
+	assert.Equal(t, 2, len(p.Messages["files"]))
+	assert.True(t, p.Contains("files", []byte(`"path":"gs://bucket/`+year+`/a.json"`)))
+	assert.True(t, p.Contains("files", []byte(`"path":"mc://bucket/c.csv"`)))
+	assert.Equal(t, 1, ws[0].status().CacheSize)
+	assert.Equal(t, 1, ws[1].status().CacheSize)
+	assert.Equal(t, 0, ws[2].status().CacheSize)
+
+	// polling keeps event files that are not listed yet
+	ws[0].process("gs://bucket/" + year + "/*.json")
+	assert.Equal(t, 1, ws[0].status().CacheSize)
+}
+
+func TestReadEvents_multipleRules(t *testing.T) {
+	o := &options{FilesTopic: "files", Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules,
+		&Rule{PathTemplate: "mc://bucket/*.csv", Events: true},
+		&Rule{PathTemplate: "s3://bucket/*.csv", Events: true},
+	)
+	ws, _ := newWatchers(o)
+	readEvents(&msgConsumer{msgs: []string{
+		`{"Records":[{"eventName":"s3:ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"c.csv","size":5}}}]}`,
+	}}, ws)
+
+	p := ws[0].producer.(*nop.Producer)
+	assert.Equal(t, 2, len(p.Messages["files"]))
+	assert.True(t, p.Contains("files", []byte(`"path":"mc://bucket/c.csv"`)))
+	assert.True(t, p.Contains("files", []byte(`"path":"s3://bucket/c.csv"`)))
+	assert.Equal(t, 1, ws[0].status().CacheSize)
+	assert.Equal(t, 1, ws[1].status().CacheSize)
+}
+
+func TestLocalEvents(t *testing.T) {
+	dir := t.TempDir()
+	o := &options{FilesTopic: "files", Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules, &Rule{PathTemplate: dir + "/*.json", Events: true})
+	ws, _ := newWatchers(o)
+	w := ws[0]
+	assert.Nil(t, w.watchLocal())
+	defer w.close()
+	w.watchDirs(getPaths(w.rule.PathTemplate, chron.ThisHour(), w.lookback))
+
+	os.WriteFile(dir+"/a.json", []byte("data"), 0644)
+	os.WriteFile(dir+"/b.txt", []byte("data"), 0644)
+	p := w.producer.(*nop.Producer)
+	for i := 0; i < 100 && !p.Contains("files", []byte(`"size":4`)); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, p.Contains("files", []byte(`"path":"`+dir+`/a.json"`)))
+	assert.False(t, p.Contains("files", []byte("b.txt")))
+}
+
+func TestSettle(t *testing.T) {
+	o := &options{FilesTopic: "files", Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules, &Rule{PathTemplate: "gs://bucket/*.json", Settle: "5m"})
+	ws, err := newWatchers(o)
+	assert.Nil(t, err)
+	w := ws[0]
+
+	now := time.Now()
+	files := fileList{"gs://bucket/a.json": {Path: "gs://bucket/a.json", Size: 10, Created: "2020-01-02T03:04:05Z"}}
+	assert.Equal(t, 0, len(w.ready(files, nil, now)))
+	assert.Equal(t, 0, len(w.ready(files, nil, now.Add(time.Minute))))
+
+	// a change restarts the settle period
+	changed := fileList{"gs://bucket/a.json": {Path: "gs://bucket/a.json", Size: 20, Created: "2020-01-02T03:06:05Z"}}
+	assert.Equal(t, 0, len(w.ready(changed, nil, now.Add(4*time.Minute))))
+	assert.Equal(t, 0, len(w.ready(changed, nil, now.Add(8*time.Minute))))
+	assert.Equal(t, changed, w.ready(changed, nil, now.Add(9*time.Minute)))
+	assert.Equal(t, 0, len(w.pending))
+
+	o.Rules[0].Settle = "bad"
+	_, err = newWatchers(o)
+	assert.NotNil(t, err)
+}
+
+func TestMarker(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(dir+"/a", 0755)
+	os.Mkdir(dir+"/b", 0755)
+	os.WriteFile(dir+"/a/1.json", []byte("data"), 0644)
+	os.WriteFile(dir+"/a/2.json", []byte("data"), 0644)
+	os.WriteFile(dir+"/b/1.json", []byte("data"), 0644)
+
+	o := &options{FilesTopic: "files", Bus: &bus.Options{Bus: "nop"}}
+	o.Rules = append(o.Rules, &Rule{PathTemplate: dir + "/*/*", Marker: "_SUCCESS"})
+	ws, _ := newWatchers(o)
+	w := ws[0]
+	p := w.producer.(*nop.Producer)
+
+	// local paths are listed by directory
+	w.process(dir+"/a", dir+"/b")
+	assert.Equal(t, 0, len(p.Messages["files"]))
+	assert.Equal(t, watcherStatus{PathTemplate: w.rule.PathTemplate, Pending: 3, LastScan: w.lastScan}, w.status())
+
+	// all the files in the directory are sent with the marker
+	os.WriteFile(dir+"/a/_SUCCESS", nil, 0644)
+	w.process(dir+"/a", dir+"/b")
+	assert.Equal(t, 2, len(p.Messages["files"]))
+	assert.True(t, p.Contains("files", []byte(dir+"/a/1.json")))
+	assert.True(t, p.Contains("files", []byte(dir+"/a/2.json")))
+	assert.False(t, p.Contains("files", []byte("_SUCCESS")))
+	assert.Equal(t, 2, w.status().CacheSize)
+	assert.Equal(t, 1, w.status().Pending)
+
+	// a marker event wakes the watcher to check pending files
+	w.notify(stat.Stats{Path: dir + "/b/_SUCCESS"})
+	select {
+	case <-w.wake:
+	default:
+		t.Error("expected wake up")
+	}
+}
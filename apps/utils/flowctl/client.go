@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pcelvng/task"
+)
+
+// client of the flowlord http api
+type client struct {
+	host string
+	http *http.Client
+}
+
+func newClient(host string) *client {
+	return &client{
+		host: strings.TrimSuffix(host, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do the request and decode the json response into v
+func (c *client) do(method, pth string, body io.Reader, v any) error {
+	req, err := http.NewRequest(method, c.host+pth, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, v)
+}
+
+// info is the /info response
+type info struct {
+	AppName  string                      `json:"app_name"`
+	Version  string                      `json:"version"`
+	RunTime  string                      `json:"runtime"`
+	Workflow map[string]map[string]entry `json:"workflow"`
+}
+
+type entry struct {
+	Next     *time.Time
+	Prev     *time.Time
+	Warning  string `json:"warning"`
+	Schedule []string
+	Child    []string
+}
+
+// Workflows prints the phases of each workflow file (or only the named file)
+func (c *client) Workflows(name string, w io.Writer) error {
+	var inf info
+	if err := c.do(http.MethodGet, "/info", nil, &inf); err != nil {
+		return err
+	}
+	files := make([]string, 0, len(inf.Workflow))
+	for f := range inf.Workflow {
+		if name == "" || f == name {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 && name != "" {
+		return fmt.Errorf("workflow %q not found", name)
+	}
+	sort.Strings(files)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKFLOW\tPHASE\tSCHEDULE\tNEXT\tCHILDREN\tWARNING")
+	for _, f := range files {
+		phases := inf.Workflow[f]
+		keys := make([]string, 0, len(phases))
+		for k := range phases {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e := phases[k]
+			var next string
+			if e.Next != nil {
+				next = e.Next.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", f, k,
+				strings.Join(e.Schedule, ", "), next, strings.Join(e.Child, ", "), e.Warning)
+		}
+	}
+	return tw.Flush()
+}
+
+// backloadRequest is the /backload request body
+type backloadRequest struct {
+	Task     string
+	Job      string              `json:",omitempty"`
+	Template string              `json:",omitempty"`
+	From     string              `json:",omitempty"`
+	To       string              `json:",omitempty"`
+	At       string              `json:",omitempty"`
+	By       string              `json:",omitempty"`
+	Meta     map[string][]string `json:"meta,omitempty"`
+	Metafile string              `json:"meta-file,omitempty"`
+	Execute  bool
+}
+
+// parseBackload converts the query (task=name&from=2024-01-01&meta=key:a,b|key2:1,2)
+// to a backload request. The task may include the job (task=name:job).
+func parseBackload(query string) (backloadRequest, error) {
+	var req backloadRequest
+	v, err := url.ParseQuery(query)
+	if err != nil {
+		return req, err
+	}
+	for k := range v {
+		switch k {
+		case "task", "job", "template", "from", "to", "at", "by", "meta", "meta-file":
+		default:
+			return req, fmt.Errorf("unknown field %q", k)
+		}
+	}
+	req.Task, req.Job, _ = strings.Cut(v.Get("task"), ":")
+	if j := v.Get("job"); j != "" {
+		req.Job = j
+	}
+	if req.Task == "" {
+		return req, fmt.Errorf("task is required")
+	}
+	req.Template = v.Get("template")
+	req.From, req.To, req.At = v.Get("from"), v.Get("to"), v.Get("at")
+	req.By = v.Get("by")
+	req.Metafile = v.Get("meta-file")
+	if m := v.Get("meta"); m != "" {
+		req.Meta = make(map[string][]string)
+		for _, kv := range strings.Split(m, "|") {
+			key, vals, found := strings.Cut(kv, ":")
+			if !found || key == "" {
+				return req, fmt.Errorf("invalid meta %q (key:a,b|key2:1,2)", kv)
+			}
+			req.Meta[key] = strings.Split(vals, ",")
+		}
+	}
+	return req, nil
+}
+
+// Backload previews (execute=false) or sends the tasks of a backload
+func (c *client) Backload(query string, execute bool, w io.Writer) error {
+	req, err := parseBackload(query)
+	if err != nil {
+		return err
+	}
+	req.Execute = execute
+	b, _ := json.Marshal(req)
+
+	var resp struct {
+		Status string
+		Count  int
+		Tasks  []task.Task
+	}
+	if err := c.do(http.MethodPost, "/backload", bytes.NewReader(b), &resp); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, resp.Status)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tJOB\tINFO\tMETA")
+	for _, t := range resp.Tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Type, t.Job, t.Info, t.Meta)
+	}
+	return tw.Flush()
+}
+
+// Task prints the task and its children. Child tasks have the same id as the parent.
+func (c *client) Task(id string, w io.Writer) error {
+	var tj struct {
+		LastUpdate time.Time
+		Completed  bool
+		Events     []task.Task
+	}
+	if err := c.do(http.MethodGet, "/task/"+url.PathEscape(id), nil, &tj); err != nil {
+		return err
+	}
+	if len(tj.Events) == 0 {
+		return fmt.Errorf("task %s not found", id)
+	}
+	fmt.Fprintf(w, "id: %s completed: %v last update: %s\n", id, tj.Completed, tj.LastUpdate.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tJOB\tRESULT\tCREATED\tSTARTED\tENDED\tINFO\tMSG")
+	for _, t := range tj.Events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.Type, t.Job, t.Result, t.Created, t.Started, t.Ended, t.Info, t.Msg)
+	}
+	return tw.Flush()
+}
+
+// alert is a record of the /alerts response
+type alert struct {
+	ID        int64     `json:"id"`
+	TaskID    string    `json:"task_id"`
+	TaskTime  time.Time `json:"task_time"`
+	Type      string    `json:"type"`
+	Job       string    `json:"job"`
+	Msg       string    `json:"msg"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Alerts prints the alerts created after the time and returns
+// the time of the last alert to continue from. Flowlord compares the
+// times by the second, so the alerts of the previous second are requested
+// again and the alerts in seen (id: created time) are skipped.
+func (c *client) Alerts(after time.Time, seen map[int64]time.Time, w io.Writer) (time.Time, error) {
+	var alerts []alert
+	q := url.Values{"after": {after.Add(-time.Second).UTC().Format(time.RFC3339)}}
+	if err := c.do(http.MethodGet, "/alerts?"+q.Encode(), nil, &alerts); err != nil {
+		return after, err
+	}
+	for _, a := range alerts {
+		if _, found := seen[a.ID]; found {
+			continue
+		}
+		seen[a.ID] = a.CreatedAt
+		name := a.Type
+		if a.Job != "" {
+			name += ":" + a.Job
+		}
+		fmt.Fprintf(w, "%s %s %s %s %s\n", a.CreatedAt.Format(time.RFC3339), name,
+			a.TaskTime.Format("2006-01-02T15"), a.TaskID, a.Msg)
+		if a.CreatedAt.After(after) {
+			after = a.CreatedAt
+		}
+	}
+	// alerts before the requested time are not returned again
+	for id, t := range seen {
+		if t.Before(after.Add(-time.Second)) {
+			delete(seen, id)
+		}
+	}
+	return after, nil
+}
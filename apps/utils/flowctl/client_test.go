@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydronica/trial"
+)
+
+func TestParseBackload(t *testing.T) {
+	cases := trial.Cases[string, backloadRequest]{
+		"task and job": {
+			Input:    "task=sql:load&from=2024-01-01&to=2024-01-05&by=day",
+			Expected: backloadRequest{Task: "sql", Job: "load", From: "2024-01-01", To: "2024-01-05", By: "day"},
+		},
+		"job field": {
+			Input:    "task=sql&job=load&at=2024-01-01T05&template=?hour={yyyy}-{mm}-{dd}T{hh}",
+			Expected: backloadRequest{Task: "sql", Job: "load", At: "2024-01-01T05", Template: "?hour={yyyy}-{mm}-{dd}T{hh}"},
+		},
+		"meta": {
+			Input: "task=b-meta&meta=key:a,b|val:1,2",
+			Expected: backloadRequest{Task: "b-meta", Meta: map[string][]string{
+				"key": {"a", "b"},
+				"val": {"1", "2"},
+			}},
+		},
+		"missing task": {Input: "from=2024-01-01", ShouldErr: true},
+		"bad meta":     {Input: "task=t&meta=a,b", ShouldErr: true},
+		"unknown":      {Input: "task=t&day=2024-01-01", ShouldErr: true},
+	}
+	trial.New(parseBackload, cases).SubTest(t)
+}
+
+// flowlord is a test server with the canned responses by path
+func flowlord(t *testing.T, reqs *[]string) *client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*reqs = append(*reqs, r.Method+" "+r.URL.String()+" "+string(b))
+		switch r.URL.Path {
+		case "/info":
+			w.Write([]byte(`{"workflow":{"f1.toml":{
+				"task1":{"Next":"2024-01-02T00:00:00Z","Schedule":["0 0 * * *?offset=0s"],"Child":["task2"]},
+				"task4":{"warning":"non-scheduled phase"}}}}`))
+		case "/backload":
+			w.Write([]byte(`{"Status":"DRY RUN ONLY: 1 tasks","Count":1,"Tasks":[{"type":"sql","job":"load","info":"?day=2024-01-01","meta":"job=load"}]}`))
+		case "/task/abc":
+			w.Write([]byte(`{"LastUpdate":"2024-01-01T01:00:00Z","Completed":true,"Events":[
+				{"type":"task1","id":"abc","info":"?day=2024-01-01","result":"complete"},
+				{"type":"task2","id":"abc","info":"?day=2024-01-01","result":"error","msg":"bad"}]}`))
+		case "/task/none":
+			w.Write([]byte(`{"LastUpdate":"0001-01-01T00:00:00Z","Completed":false,"Events":null}`))
+		case "/alerts":
+			w.Write([]byte(`[{"id":1,"task_id":"abc","task_time":"2024-01-01T00:00:00Z","type":"task2","msg":"bad","created_at":"2024-01-01T01:00:00Z"}]`))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return newClient(srv.URL + "/")
+}
+
+func TestClient(t *testing.T) {
+	var reqs []string
+	c := flowlord(t, &reqs)
+
+	type output struct {
+		Out string
+		Req string
+	}
+	fn := func(cmd func(io.Writer) error) (output, error) {
+		reqs = nil
+		var buf bytes.Buffer
+		err := cmd(&buf)
+		var req string
+		if len(reqs) > 0 {
+			req = reqs[0]
+		}
+		return output{Out: buf.String(), Req: req}, err
+	}
+	cases := trial.Cases[func(io.Writer) error, output]{
+		"workflows": {
+			Input: func(w io.Writer) error { return c.Workflows("", w) },
+			Expected: output{
+				Req: "GET /info ",
+				Out: "WORKFLOW  PHASE  SCHEDULE             NEXT                  CHILDREN  WARNING\n" +
+					"f1.toml   task1  0 0 * * *?offset=0s  2024-01-02T00:00:00Z  task2     \n" +
+					"f1.toml   task4                                                       non-scheduled phase\n",
+			},
+		},
+		"unknown workflow": {
+			Input:     func(w io.Writer) error { return c.Workflows("f2.toml", w) },
+			ShouldErr: true,
+		},
+		"preview": {
+			Input: func(w io.Writer) error { return c.Backload("task=sql:load&from=2024-01-01", false, w) },
+			Expected: output{
+				Req: `POST /backload {"Task":"sql","Job":"load","From":"2024-01-01","Execute":false}`,
+				Out: "DRY RUN ONLY: 1 tasks\n" +
+					"TYPE  JOB   INFO             META\n" +
+					"sql   load  ?day=2024-01-01  job=load\n",
+			},
+		},
+		"task": {
+			Input: func(w io.Writer) error { return c.Task("abc", w) },
+			Expected: output{
+				Req: "GET /task/abc ",
+				Out: "id: abc completed: true last update: 2024-01-01T01:00:00Z\n" +
+					"TYPE   JOB  RESULT    CREATED  STARTED  ENDED  INFO             MSG\n" +
+					"task1       complete                           ?day=2024-01-01  \n" +
+					"task2       error                              ?day=2024-01-01  bad\n",
+			},
+		},
+		"task not found": {
+			Input:     func(w io.Writer) error { return c.Task("none", w) },
+			ShouldErr: true,
+		},
+		"http error": {
+			Input:       func(w io.Writer) error { return c.Task("x/y", w) },
+			ExpectedErr: errors.New("404 Not Found: not found"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestAlerts(t *testing.T) {
+	var reqs []string
+	c := flowlord(t, &reqs)
+	var buf bytes.Buffer
+	seen := make(map[int64]time.Time)
+	after, err := c.Alerts(trial.TimeDay("2024-01-01"), seen, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := "2024-01-01T01:00:00Z task2 2024-01-01T00 abc bad\n"; buf.String() != s {
+		t.Errorf("got %q expected %q", buf.String(), s)
+	}
+	if !after.Equal(trial.TimeHour("2024-01-01T01")) {
+		t.Errorf("expected the last alert time %v", after)
+	}
+	if !strings.HasSuffix(reqs[0], "/alerts?after=2023-12-31T23%3A59%3A59Z ") {
+		t.Errorf("unexpected request %q", reqs[0])
+	}
+
+	// the alert is returned again for the same second but only printed once
+	buf.Reset()
+	if after, err = c.Alerts(after, seen, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected repeated alert %q", buf.String())
+	}
+	if !strings.HasSuffix(reqs[1], "/alerts?after=2024-01-01T00%3A59%3A59Z ") {
+		t.Errorf("unexpected request %q", reqs[1])
+	}
+	if len(seen) != 1 || !after.Equal(trial.TimeHour("2024-01-01T01")) {
+		t.Errorf("unexpected seen %v after %v", seen, after)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	tools "github.com/pcelvng/task-tools"
+)
+
+const usage = `Usage: flowctl [flags] command [args ...]
+Available commands
+  workflows [file]     List the workflows and phases loaded in flowlord
+  validate <path>      Validate a workflow file or directory offline
+  preview <query>      Show the tasks a backload would create (task=name&job=j&from=2024-01-01&to=2024-01-05&by=day&meta=key:a,b)
+  backload <query>     Create and send the tasks of a backload
+  task <id>            Show a task and its children
+  alerts               Show recent alerts (-since, -follow)
+Flags
+`
+
+var (
+	host     = flag.String("host", envOr("FLOWLORD_HOST", "http://localhost:8080"), "flowlord url (FLOWLORD_HOST)")
+	since    = flag.Duration("since", 24*time.Hour, "alerts created within the duration")
+	follow   = flag.Bool("follow", false, "poll for new alerts")
+	interval = flag.Duration("interval", 30*time.Second, "alert poll interval with -follow")
+	version  = flag.Bool("v", false, "show version")
+)
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *version {
+		fmt.Println(tools.String())
+		os.Exit(0)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	cmd, arg := args[0], ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+
+	c := newClient(*host)
+	var err error
+	switch strings.ToLower(cmd) {
+	case "workflows", "ls":
+		err = c.Workflows(arg, os.Stdout)
+	case "validate":
+		if arg == "" {
+			log.Fatal("validate requires a workflow path")
+		}
+		var issues int
+		issues, err = validate(arg, nil, os.Stdout)
+		if err == nil && issues > 0 {
+			os.Exit(1)
+		}
+	case "preview":
+		err = c.Backload(arg, false, os.Stdout)
+	case "backload":
+		err = c.Backload(arg, true, os.Stdout)
+	case "task":
+		if arg == "" {
+			log.Fatal("task requires an id")
+		}
+		err = c.Task(arg, os.Stdout)
+	case "alerts":
+		after := time.Now().Add(-*since)
+		seen := make(map[int64]time.Time)
+		for {
+			if after, err = c.Alerts(after, seen, os.Stdout); err != nil || !*follow {
+				break
+			}
+			time.Sleep(*interval)
+		}
+	default:
+		log.Fatalf("Unknown command %s\n%s", cmd, usage)
+	}
+	if err != nil {
+		log.Fatalf("%s: %s", cmd, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pcelvng/task-tools/apps/flowlord/sqlite"
+	"github.com/pcelvng/task-tools/file"
+)
+
+// validate loads the workflow file or directory into an in-memory cache the
+// same way flowlord does and reports parse errors, phase warnings and
+// missing parents. The number of errors (not warnings) is returned.
+func validate(pth string, opt *file.Options, w io.Writer) (errCount int, err error) {
+	// hide the cache schema logs
+	log.SetOutput(io.Discard)
+	cache := &sqlite.SQLite{LocalPath: ":memory:"}
+	err = cache.Open(pth, opt)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		if len(cache.GetWorkflowFiles()) == 0 {
+			return 0, err
+		}
+		// report the files that could not be parsed and check the rest
+		fmt.Fprintln(w, "error:", err)
+		errCount++
+	}
+	defer cache.Close()
+
+	workflows := cache.GetAllPhasesGrouped()
+	files := make([]string, 0, len(workflows))
+	for f := range workflows {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var phaseCount, warnCount int
+	for _, f := range files {
+		phases := workflows[f]
+		names := make(map[string]bool)
+		for _, ph := range phases {
+			names[ph.Task] = true
+			names[ph.Topic()] = true
+			if ph.Job() != "" {
+				names[ph.Topic()+":"+ph.Job()] = true
+			}
+		}
+		for _, ph := range phases {
+			phaseCount++
+			if ph.DependsOn != "" && !names[ph.DependsOn] {
+				fmt.Fprintf(w, "error: %s %s: parent task not found: %s\n", f, ph.Task, ph.DependsOn)
+				errCount++
+			}
+			if ph.Status == "" {
+				continue
+			}
+			// rules that can't be parsed are errors, other statuses are warnings
+			if strings.HasPrefix(ph.Status, "invalid") {
+				fmt.Fprintf(w, "error: %s %s: %s\n", f, ph.Task, ph.Status)
+				errCount++
+			} else {
+				fmt.Fprintf(w, "warning: %s %s: %s\n", f, ph.Task, ph.Status)
+				warnCount++
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d workflows, %d phases, %d errors, %d warnings\n", len(files), phaseCount, errCount, warnCount)
+	return errCount, nil
+}
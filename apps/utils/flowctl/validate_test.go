@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var buf bytes.Buffer
+	errCount, err := validate("../../../internal/test/workflow/", nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errCount != 0 {
+		t.Errorf("unexpected errors %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "4 workflows, 20 phases, 0 errors, 5 warnings") {
+		t.Errorf("unexpected summary %s", buf.String())
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/bad.toml", []byte("[[phase]]\ntask = \"a\"\nrule = \"cron=bad\"\n\n[[phase]]\ntask = \"b:j\"\ndependsOn = \"a:j\"\n"), 0644)
+	buf.Reset()
+	if errCount, err = validate(dir, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if errCount != 2 {
+		t.Errorf("expected 2 errors got %d %s", errCount, buf.String())
+	}
+	for _, s := range []string{"bad.toml a: invalid cron", "bad.toml b:j: parent task not found: a:j"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in %s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inhies/go-bytesize"
+	"github.com/jbsmith7741/uri"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// entry is a file found by walk with its path relative to the walked directory
+type entry struct {
+	rel string
+	stat.Stats
+}
+
+// walk recursively lists all files in the directory
+func walk(dir string, opt *file.Options) ([]entry, error) {
+	return walkRel(dir, "", opt)
+}
+
+func walkRel(dir, rel string, opt *file.Options) ([]entry, error) {
+	sts, err := file.List(dir, opt)
+	if err != nil {
+		return nil, err
+	}
+	var files []entry
+	for _, s := range sts {
+		name := path.Join(rel, path.Base(strings.TrimSuffix(s.Path, "/")))
+		if !s.IsDir {
+			files = append(files, entry{rel: name, Stats: s})
+			continue
+		}
+		sub, err := walkRel(strings.TrimSuffix(s.Path, "/")+"/", name, opt)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sub...)
+	}
+	return files, nil
+}
+
+// join adds the relative path to the directory
+func join(dir, rel string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + rel
+}
+
+// cleanPath removes duplicate, dot and trailing elements after the scheme
+func cleanPath(pth string) string {
+	scheme, rest, found := strings.Cut(pth, "://")
+	if !found {
+		return path.Clean(pth)
+	}
+	return scheme + "://" + path.Clean(rest)
+}
+
+// overlaps checks if the paths are the same or one is in the other's directory
+func overlaps(a, b string) bool {
+	a, b = cleanPath(a), cleanPath(b)
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// rm deletes the files matching the glob path after confirmation
+func rm(pth string, opt *file.Options, in io.Reader) error {
+	files, err := file.Glob(pth, opt)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files found for %s", pth)
+	}
+	for _, f := range files {
+		fmt.Println(format(f))
+	}
+	fmt.Printf("remove %d files? [y/N] ", len(files))
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return fmt.Errorf("canceled")
+	}
+	for _, f := range files {
+		if err := file.Remove(f.Path, opt); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("removed %d files\n", len(files))
+	return nil
+}
+
+// mv copies the file to the destination and removes the original.
+// A destination ending with / is a directory.
+func mv(from, to string, opt *file.Options) error {
+	if to == "" || from == "" {
+		return fmt.Errorf(usage)
+	}
+	if strings.HasSuffix(to, "/") {
+		to += path.Base(from)
+	}
+	// copying to itself and removing the original would delete the file
+	if cleanPath(from) == cleanPath(to) {
+		return fmt.Errorf("%s and %s are the same file", from, to)
+	}
+	if err := cp(from, to, opt); err != nil {
+		return err
+	}
+	return file.Remove(from, opt)
+}
+
+// syncDir copies all files in the from directory to the to directory.
+// Files with the same checksum in the destination are skipped.
+func syncDir(from, to string, opt *file.Options) error {
+	if to == "" || from == "" {
+		return fmt.Errorf(usage)
+	}
+	if overlaps(from, to) {
+		return fmt.Errorf("%s and %s overlap", from, to)
+	}
+	files, err := walk(from, opt)
+	if err != nil {
+		return err
+	}
+	// the destination may not exist yet
+	dest := make(map[string]stat.Stats)
+	existing, _ := walk(to, opt)
+	for _, f := range existing {
+		dest[f.rel] = f.Stats
+	}
+	var copied, skipped int
+	for _, f := range files {
+		if d, found := dest[f.rel]; found && identical(f.Stats, d, opt) {
+			skipped++
+			continue
+		}
+		if err := cp(f.Path, join(to, f.rel), opt); err != nil {
+			return fmt.Errorf("%s: %w", f.rel, err)
+		}
+		fmt.Println(f.rel)
+		copied++
+	}
+	fmt.Printf("copied %d files, %d unchanged\n", copied, skipped)
+	return nil
+}
+
+// identical compares the checksums of the files. gzip files are
+// recompressed when copied so the uncompressed content is compared.
+func identical(a, b stat.Stats, opt *file.Options) bool {
+	if a.Checksum != "" && strings.Trim(a.Checksum, `"`) == strings.Trim(b.Checksum, `"`) {
+		return true
+	}
+	if path.Ext(a.Path) != ".gz" {
+		return false
+	}
+	sumA, err := contentSum(a.Path, opt)
+	if err != nil {
+		return false
+	}
+	sumB, err := contentSum(b.Path, opt)
+	return err == nil && sumA == sumB
+}
+
+// contentSum is the md5 of the uncompressed file content
+func contentSum(pth string, opt *file.Options) (string, error) {
+	r, err := file.NewReader(pth, opt)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lineCount parses the optional number of lines (default 10)
+func lineCount(s string) (int, error) {
+	if s == "" {
+		return 10, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid line count %q", s)
+	}
+	return n, nil
+}
+
+// head prints the first n lines of the file
+func head(pth, count string, opt *file.Options, w io.Writer) error {
+	n, err := lineCount(count)
+	if err != nil {
+		return err
+	}
+	r, err := file.NewReader(pth, opt)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	s := file.NewScanner(r)
+	for i := 0; i < n && s.Scan(); i++ {
+		fmt.Fprintln(w, s.Text())
+	}
+	return s.Err()
+}
+
+// tail prints the last n lines of the file
+func tail(pth, count string, opt *file.Options, w io.Writer) error {
+	n, err := lineCount(count)
+	if err != nil {
+		return err
+	}
+	r, err := file.NewReader(pth, opt)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if n == 0 {
+		return nil
+	}
+	lines := make([]string, 0, n)
+	s := file.NewScanner(r)
+	for s.Scan() {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, s.Text())
+	}
+	for _, ln := range lines {
+		fmt.Fprintln(w, ln)
+	}
+	return s.Err()
+}
+
+// findFilter of the files to find
+// fz find s3://bucket/logs "name=*.gz&newer=24h&min_size=1MB"
+type findFilter struct {
+	Name    string `uri:"name"`     // glob pattern of the file name
+	Newer   string `uri:"newer"`    // modified within the duration
+	Older   string `uri:"older"`    // modified before the duration
+	MinSize string `uri:"min_size"` // 10KB, 1MB, etc
+	MaxSize string `uri:"max_size"`
+
+	newer, older time.Time
+	min, max     int64
+}
+
+func newFindFilter(query string, now time.Time) (*findFilter, error) {
+	f := &findFilter{}
+	if err := uri.UnmarshalQuery(query, f); err != nil {
+		return nil, err
+	}
+	if _, err := filepath.Match(f.Name, ""); err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+	for _, d := range []struct {
+		s string
+		t *time.Time
+	}{{f.Newer, &f.newer}, {f.Older, &f.older}} {
+		if d.s == "" {
+			continue
+		}
+		age, err := time.ParseDuration(d.s)
+		if err != nil {
+			return nil, err
+		}
+		*d.t = now.Add(-age)
+	}
+	for _, s := range []struct {
+		s string
+		v *int64
+	}{{f.MinSize, &f.min}, {f.MaxSize, &f.max}} {
+		if s.s == "" {
+			continue
+		}
+		b, err := bytesize.Parse(s.s)
+		if err != nil {
+			return nil, err
+		}
+		*s.v = int64(b)
+	}
+	return f, nil
+}
+
+// Match checks the file against all set filter fields
+func (f *findFilter) Match(sts stat.Stats) bool {
+	if f.Name != "" {
+		if ok, _ := filepath.Match(f.Name, path.Base(sts.Path)); !ok {
+			return false
+		}
+	}
+	if (f.min > 0 && sts.Size < f.min) || (f.max > 0 && sts.Size > f.max) {
+		return false
+	}
+	if f.newer.IsZero() && f.older.IsZero() {
+		return true
+	}
+	created := sts.ParseCreated()
+	return (f.newer.IsZero() || created.After(f.newer)) &&
+		(f.older.IsZero() || created.Before(f.older))
+}
+
+// find recursively lists the files in the directory that match the filter
+func find(dir, query string, opt *file.Options, w io.Writer) error {
+	f, err := newFindFilter(query, time.Now())
+	if err != nil {
+		return err
+	}
+	files, err := walk(dir, opt)
+	if err != nil {
+		return err
+	}
+	for _, e := range files {
+		if f.Match(e.Stats) {
+			fmt.Fprintln(w, format(e.Stats))
+		}
+	}
+	return nil
+}
+
+// du prints the total size of each file and directory in the path
+func du(dir string, opt *file.Options, w io.Writer) error {
+	files, err := walk(dir, opt)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64)
+	counts := make(map[string]int)
+	var total int64
+	for _, f := range files {
+		prefix, _, _ := strings.Cut(f.rel, "/")
+		sizes[prefix] += f.Size
+		counts[prefix]++
+		total += f.Size
+	}
+	names := make([]string, 0, len(sizes))
+	for k := range sizes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(w, "%6s %6d %s\n", fByte(sizes[k]), counts[k], join(dir, k))
+	}
+	fmt.Fprintf(w, "%6s %6d total\n", fByte(total), len(files))
+	return nil
+}
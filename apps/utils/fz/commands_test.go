@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hydronica/trial"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// writeFiles creates the files with the content in the dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		w, err := file.NewWriter(filepath.Join(dir, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	from, to := t.TempDir(), t.TempDir()
+	writeFiles(t, from, map[string]string{
+		"a.txt":          "a\n",
+		"sub/b.txt":      "b\n",
+		"sub/deep/c.gz":  "c\n",
+		"sub/deep/d.txt": "d\n",
+	})
+	writeFiles(t, to, map[string]string{"sub/deep/d.txt": "old\n"})
+
+	if err := syncDir(from, to, nil); err != nil {
+		t.Fatal(err)
+	}
+	files, err := walk(to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range files {
+		var buf bytes.Buffer
+		if err := head(f.Path, "", nil, &buf); err != nil {
+			t.Fatal(err)
+		}
+		got[f.rel] = buf.String()
+	}
+	if eq, diff := trial.Equal(got, map[string]string{
+		"a.txt":          "a\n",
+		"sub/b.txt":      "b\n",
+		"sub/deep/c.gz":  "c\n",
+		"sub/deep/d.txt": "d\n",
+	}); !eq {
+		t.Error(diff)
+	}
+
+	// unchanged files (including gzip) are skipped
+	src, err := walk(from, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make(map[string]stat.Stats)
+	for _, f := range files {
+		dest[f.rel] = f.Stats
+	}
+	for _, f := range src {
+		if !identical(f.Stats, dest[f.rel], nil) {
+			t.Errorf("%s should be identical", f.rel)
+		}
+	}
+
+	// a destination in the source would also be synced
+	if err := syncDir(from, from+"/sub/", nil); err == nil {
+		t.Error("expected overlap error")
+	}
+}
+
+func TestMv(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n"})
+	if err := mv(dir+"/a.txt", dir+"/sub/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "/a.txt"); !os.IsNotExist(err) {
+		t.Error("expected a.txt to be removed")
+	}
+	if b, err := os.ReadFile(dir + "/sub/a.txt"); err != nil || string(b) != "a\n" {
+		t.Errorf("sub/a.txt %q %v", b, err)
+	}
+
+	// moving a file to itself keeps the file
+	for _, to := range []string{dir + "/sub/a.txt", dir + "/sub/", dir + "/sub/./a.txt"} {
+		if err := mv(dir+"/sub/a.txt", to, nil); err == nil {
+			t.Errorf("%s: expected same file error", to)
+		}
+	}
+	if _, err := os.Stat(dir + "/sub/a.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	fn := func(in [2]string) (bool, error) {
+		return overlaps(in[0], in[1]), nil
+	}
+	cases := trial.Cases[[2]string, bool]{
+		"same":          {Input: [2]string{"/data/a/", "/data/a"}, Expected: true},
+		"subdirectory":  {Input: [2]string{"/data/a/", "/data/a/b/"}, Expected: true},
+		"parent":        {Input: [2]string{"s3://bucket/a/b", "s3://bucket/a/"}, Expected: true},
+		"sibling":       {Input: [2]string{"/data/a/", "/data/ab/"}, Expected: false},
+		"other bucket":  {Input: [2]string{"s3://bucket/a/", "gs://bucket/a/"}, Expected: false},
+		"relative same": {Input: [2]string{"./a/", "a"}, Expected: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestRm(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.json": "a", "b.json": "b", "c.txt": "c"})
+
+	if err := rm(dir+"/*.json", nil, strings.NewReader("n\n")); err == nil {
+		t.Error("expected canceled error")
+	}
+	if err := rm(dir+"/*.json", nil, strings.NewReader("y\n")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := walk(dir, nil)
+	if len(files) != 1 || files[0].rel != "c.txt" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestHeadTail(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"f.gz": "1\n2\n3\n4\n5\n"})
+	type input struct {
+		fn    func(string, string, *file.Options, *bytes.Buffer) error
+		count string
+	}
+	headFn := func(p, n string, o *file.Options, w *bytes.Buffer) error { return head(p, n, o, w) }
+	tailFn := func(p, n string, o *file.Options, w *bytes.Buffer) error { return tail(p, n, o, w) }
+	fn := func(in input) (string, error) {
+		var buf bytes.Buffer
+		err := in.fn(dir+"/f.gz", in.count, nil, &buf)
+		return buf.String(), err
+	}
+	cases := trial.Cases[input, string]{
+		"head 2":       {Input: input{fn: headFn, count: "2"}, Expected: "1\n2\n"},
+		"head default": {Input: input{fn: headFn}, Expected: "1\n2\n3\n4\n5\n"},
+		"tail 2":       {Input: input{fn: tailFn, count: "2"}, Expected: "4\n5\n"},
+		"tail 0":       {Input: input{fn: tailFn, count: "0"}, Expected: ""},
+		"bad count":    {Input: input{fn: tailFn, count: "x"}, ShouldErr: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestFindFilter(t *testing.T) {
+	now := trial.TimeDay("2024-01-10")
+	files := []stat.Stats{
+		{Path: "dir/a.json.gz", Size: 2048, Created: "2024-01-09T12:00:00Z"},
+		{Path: "dir/b.json", Size: 10, Created: "2024-01-01T00:00:00Z"},
+		{Path: "dir/sub/c.json.gz", Size: 5 << 20, Created: "2024-01-05T00:00:00Z"},
+	}
+	fn := func(q string) ([]string, error) {
+		f, err := newFindFilter(q, now)
+		if err != nil {
+			return nil, err
+		}
+		var found []string
+		for _, s := range files {
+			if f.Match(s) {
+				found = append(found, s.Path)
+			}
+		}
+		return found, nil
+	}
+	cases := trial.Cases[string, []string]{
+		"all":      {Input: "", Expected: []string{"dir/a.json.gz", "dir/b.json", "dir/sub/c.json.gz"}},
+		"name":     {Input: "name=*.gz", Expected: []string{"dir/a.json.gz", "dir/sub/c.json.gz"}},
+		"newer":    {Input: "newer=24h", Expected: []string{"dir/a.json.gz"}},
+		"older":    {Input: "older=48h", Expected: []string{"dir/b.json", "dir/sub/c.json.gz"}},
+		"min size": {Input: "min_size=1KB", Expected: []string{"dir/a.json.gz", "dir/sub/c.json.gz"}},
+		"max size": {Input: "max_size=1MB&name=*.gz", Expected: []string{"dir/a.json.gz"}},
+		"bad age":  {Input: "newer=1x", ShouldErr: true},
+		"bad size": {Input: "min_size=lots", ShouldErr: true},
+		"bad name": {Input: "name=[", ShouldErr: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestDu(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.txt":       "12345",
+		"sub/b.txt":   "1234567890",
+		"sub/c/d.txt": "1234567890",
+	})
+	var buf bytes.Buffer
+	if err := du(dir, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "     5      1 " + dir + "/a.txt\n" +
+		"    20      2 " + dir + "/sub\n" +
+		"    25      3 total\n"
+	if eq, diff := trial.Equal(buf.String(), expected); !eq {
+		t.Error(diff)
+	}
+}
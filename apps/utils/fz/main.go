@@ -22,6 +22,14 @@ Available commands
   ls  <path>        List files and directories within a path
   cat <path>        Concat file content to stdout
   cp  <from> <to>   Copy a file from a location to another
+  mv  <from> <to>   Move a file to another location (to ending in / is a directory)
+  rm  <path>        Remove the files matching the glob path after confirmation
+  sync <from> <to>  Copy all files of a directory recursively, skipping files with the same checksum
+  head <path> [n]   Print the first n lines of a file (default 10)
+  tail <path> [n]   Print the last n lines of a file (default 10)
+  find <path> [filter] Recursively list files (filter: name=*.gz&newer=24h&older=1h&min_size=1MB&max_size=1GB)
+  du  <path>        Summarize the size of each file and directory in the path
+  query <path> [filter] Find tasks in logger files (filter: id,type,job,result,from,to,format=json|table,workers)
   slack <url> <text> Send slack message to channel 
 `
 
@@ -48,6 +56,22 @@ func main() {
 		err = stats(f1, &conf)
 	case "cp":
 		err = cp(f1, f2, &conf)
+	case "mv":
+		err = mv(f1, f2, &conf)
+	case "rm":
+		err = rm(f1, &conf, os.Stdin)
+	case "sync":
+		err = syncDir(f1, f2, &conf)
+	case "head":
+		err = head(f1, f2, &conf, os.Stdout)
+	case "tail":
+		err = tail(f1, f2, &conf, os.Stdout)
+	case "find":
+		err = find(f1, f2, &conf, os.Stdout)
+	case "du":
+		err = du(f1, &conf, os.Stdout)
+	case "query":
+		err = query(f1, f2, &conf)
 	case "slack":
 		if err := slack.Notify(f1, f2); err != nil {
 			log.Fatal(err)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jbsmith7741/uri"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+// filter of the tasks to find in the logger archive
+// fz query "logs/*/*.json.gz" "type=sql-load&result=error&from=2024-01-01&to=2024-01-02T12"
+type filter struct {
+	ID     string `uri:"id"`
+	Type   string `uri:"type"`
+	Job    string `uri:"job"`
+	Result string `uri:"result"`
+	From   string `uri:"from"` // task created time, inclusive
+	To     string `uri:"to"`   // task created time, exclusive
+
+	Format  string `uri:"format" default:"json"` // json or table
+	Workers int    `uri:"workers" default:"4"`   // files scanned in parallel
+
+	from, to time.Time
+}
+
+var timeFormats = []string{time.RFC3339, "2006-01-02T15", "2006-01-02"}
+
+func parseTime(s string) (t time.Time, err error) {
+	for _, f := range timeFormats {
+		if t, err = time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return t, fmt.Errorf("invalid time %q", s)
+}
+
+func newFilter(query string) (*filter, error) {
+	f := &filter{}
+	if err := uri.UnmarshalQuery(query, f); err != nil {
+		return nil, err
+	}
+	var err error
+	if f.From != "" {
+		if f.from, err = parseTime(f.From); err != nil {
+			return nil, err
+		}
+	}
+	if f.To != "" {
+		if f.to, err = parseTime(f.To); err != nil {
+			return nil, err
+		}
+	}
+	if f.Format != "json" && f.Format != "table" {
+		return nil, fmt.Errorf("unknown format %q", f.Format)
+	}
+	if f.Workers < 1 {
+		f.Workers = 1
+	}
+	return f, nil
+}
+
+// Match checks the task against all set filter fields
+func (f *filter) Match(t task.Task) bool {
+	if (f.ID != "" && t.ID != f.ID) ||
+		(f.Type != "" && t.Type != f.Type) ||
+		(f.Job != "" && t.Job != f.Job) ||
+		(f.Result != "" && string(t.Result) != f.Result) {
+		return false
+	}
+	if f.from.IsZero() && f.to.IsZero() {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, t.Created)
+	if err != nil {
+		return false
+	}
+	return (f.from.IsZero() || !created.Before(f.from)) &&
+		(f.to.IsZero() || created.Before(f.to))
+}
+
+// query scans the files of the path in parallel and prints the
+// matching tasks in file order
+func query(pth, q string, opt *file.Options) error {
+	f, err := newFilter(q)
+	if err != nil {
+		return err
+	}
+	files, err := file.Glob(pth, opt)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files found for %s", pth)
+	}
+
+	type result struct {
+		tasks []task.Task
+		err   error
+	}
+	results := make([]chan result, len(files))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	paths := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < f.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range paths {
+				tasks, err := f.scan(files[i].Path, opt)
+				results[i] <- result{tasks: tasks, err: err}
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			paths <- i
+		}
+		close(paths)
+		wg.Wait()
+	}()
+
+	out := newPrinter(os.Stdout, f.Format)
+	for i, r := range results {
+		res := <-r
+		if res.err != nil {
+			return fmt.Errorf("%s: %w", files[i].Path, res.err)
+		}
+		for _, t := range res.tasks {
+			out.Print(t)
+		}
+	}
+	return out.Flush()
+}
+
+// scan reads the task lines of a file and returns the matching tasks.
+// gzip is handled by the file reader, zstd (.zst) is decoded here.
+func (f *filter) scan(pth string, opt *file.Options) ([]task.Task, error) {
+	r, err := file.NewReader(pth, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var rd io.Reader = r
+	if strings.HasSuffix(pth, ".zst") {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		rd = d
+	}
+	return f.match(rd)
+}
+
+// match returns the tasks of the json lines that match the filter.
+// lines that are not a task are skipped.
+func (f *filter) match(r io.Reader) (tasks []task.Task, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for s.Scan() {
+		var t task.Task
+		if err := json.Unmarshal(s.Bytes(), &t); err != nil {
+			continue
+		}
+		if f.Match(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, s.Err()
+}
+
+type printer struct {
+	table *tabwriter.Writer
+	enc   *json.Encoder
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	if format == "table" {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTYPE\tJOB\tRESULT\tCREATED\tENDED\tINFO\tMSG")
+		return &printer{table: tw}
+	}
+	return &printer{enc: json.NewEncoder(w)}
+}
+
+func (p *printer) Print(t task.Task) {
+	if p.table == nil {
+		p.enc.Encode(t)
+		return
+	}
+	fmt.Fprintf(p.table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		t.ID, t.Type, t.Job, t.Result, t.Created, t.Ended, t.Info, t.Msg)
+}
+
+func (p *printer) Flush() error {
+	if p.table == nil {
+		return nil
+	}
+	return p.table.Flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hydronica/trial"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+var logTasks = []task.Task{
+	{ID: "1", Type: "sql-load", Job: "j1", Result: task.CompleteResult, Created: "2024-01-01T10:00:00Z"},
+	{ID: "2", Type: "sql-load", Job: "j2", Result: task.ErrResult, Created: "2024-01-01T12:00:00Z"},
+	{ID: "3", Type: "transform", Result: task.ErrResult, Created: "2024-01-02T00:00:00Z"},
+}
+
+func TestFilter(t *testing.T) {
+	fn := func(q string) ([]string, error) {
+		f, err := newFilter(q)
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		for _, tsk := range logTasks {
+			if f.Match(tsk) {
+				ids = append(ids, tsk.ID)
+			}
+		}
+		return ids, nil
+	}
+	cases := trial.Cases[string, []string]{
+		"all":        {Input: "", Expected: []string{"1", "2", "3"}},
+		"id":         {Input: "id=2", Expected: []string{"2"}},
+		"type":       {Input: "type=sql-load", Expected: []string{"1", "2"}},
+		"job":        {Input: "type=sql-load&job=j1", Expected: []string{"1"}},
+		"result":     {Input: "result=error", Expected: []string{"2", "3"}},
+		"from":       {Input: "from=2024-01-01T11", Expected: []string{"2", "3"}},
+		"time range": {Input: "from=2024-01-01&to=2024-01-02", Expected: []string{"1", "2"}},
+		"bad time":   {Input: "from=yesterday", ShouldErr: true},
+		"bad format": {Input: "format=xml", ShouldErr: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	var lines []byte
+	for _, tsk := range logTasks {
+		lines = append(append(lines, tsk.JSONBytes()...), '\n')
+	}
+	lines = append(lines, "not a task\n"...)
+
+	// gzip and plain files are written by the file writer
+	for _, pth := range []string{dir + "/t.json", dir + "/t.json.gz"} {
+		w, err := file.NewWriter(pth, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(lines)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zf, err := os.Create(dir + "/t.json.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := zstd.NewWriter(zf)
+	enc.Write(lines)
+	enc.Close()
+	zf.Close()
+
+	fn := func(pth string) ([]task.Task, error) {
+		f, _ := newFilter("result=error")
+		return f.scan(pth, nil)
+	}
+	cases := trial.Cases[string, []task.Task]{
+		"json":    {Input: dir + "/t.json", Expected: logTasks[1:]},
+		"gzip":    {Input: dir + "/t.json.gz", Expected: logTasks[1:]},
+		"zstd":    {Input: dir + "/t.json.zst", Expected: logTasks[1:]},
+		"missing": {Input: dir + "/missing.json", ShouldErr: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// newWriter creates the log file writer. gzip is handled by the
+// file writer from the .gz extension, zstd is encoded before writing.
+func newWriter(pth string, opts *file.Options, compress string) (file.Writer, error) {
+	w, err := file.NewWriter(pth, opts)
+	if err != nil {
+		return nil, err
+	}
+	switch compress {
+	case "zstd", "zst":
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			w.Abort()
+			return nil, err
+		}
+		return &zstdWriter{Writer: w, enc: enc}, nil
+	}
+	return w, nil
+}
+
+// zstdWriter compresses lines to the file writer. The stats
+// byte and line counts are of the uncompressed data.
+type zstdWriter struct {
+	file.Writer
+	enc *zstd.Encoder
+
+	mu    sync.Mutex
+	bytes int64
+	lines int64
+}
+
+func (w *zstdWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.enc.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *zstdWriter) WriteLine(ln []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.enc.Write(ln)
+	if err == nil {
+		_, err = w.enc.Write([]byte{'\n'})
+		n++
+	}
+	w.bytes += int64(n)
+	w.lines++
+	return err
+}
+
+func (w *zstdWriter) Stats() stat.Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sts := w.Writer.Stats()
+	sts.ByteCnt, sts.LineCnt = w.bytes, w.lines
+	return sts
+}
+
+func (w *zstdWriter) Close() error {
+	if err := w.enc.Close(); err != nil {
+		w.Writer.Abort()
+		return err
+	}
+	return w.Writer.Close()
+}
+
+func (w *zstdWriter) Abort() error {
+	w.enc.Close()
+	return w.Writer.Abort()
+}
@@ -1,30 +1,53 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jbsmith7741/go-tools/appenderr"
+	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
 
 	"github.com/pcelvng/task-tools/file"
 )
 
+// policy is when a topic's log file is rotated and which messages are logged
+type policy struct {
+	maxBytes int64           // rotate once the uncompressed bytes written reach maxBytes
+	maxLines int64           // rotate once the lines written reach maxLines
+	compress string          // gzip or zstd
+	results  map[string]bool // only log tasks with the results, nil logs all messages
+}
+
 type Logger struct {
 	mu       sync.Mutex
 	topic    string
 	consumer bus.Consumer
 	writer   file.Writer
 	Messages int
+	Skipped  int
 	done     chan struct{}
+
+	policy
+	opts *file.Options
+	dest string // destination template
+	path string // path of the current writer without the sequence
+	seq  int    // sequence of files written to the same path
 }
 
-func newlog(topic string, c bus.Consumer, destination string, opts *file.Options) (*Logger, error) {
+func newlog(topic string, c bus.Consumer, destination string, opts *file.Options, p policy) (*Logger, error) {
 	l := &Logger{
 		topic:    topic,
 		consumer: c,
 		done:     make(chan struct{}),
+		policy:   p,
+		opts:     opts,
+		dest:     destination,
 	}
 	if err := l.CreateWriters(opts, destination); err != nil {
 		return nil, err
@@ -41,20 +64,27 @@ func (l *Logger) Stop() {
 	<-l.done
 }
 
+// CreateWriters rotates the log file to a new writer at the destination.
+// A number is added to the file name if the destination is the same
+// as the current file (rotated by size or lines within the template time).
 func (l *Logger) CreateWriters(opts *file.Options, destination string) error {
-	errs := appenderr.New()
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	// create new writer
-	path := Parse(destination, l.topic, time.Now())
+	pth := compressPath(Parse(destination, l.topic, time.Now()), l.compress)
+	seq := 0
+	if pth == l.path {
+		seq = l.seq + 1
+	}
 	opts.FileBufPrefix = l.topic
-	w, err := file.NewWriter(path, opts)
+	w, err := newWriter(seqPath(pth, seq), opts, l.compress)
 	if err != nil {
-		errs.Add(err)
+		return err
 	}
 
-	// close and reset open writer
-	l.mu.Lock()
-	// the previous writer should be closed or aborted before the new writer are set
+	// the previous writer should be closed or aborted before the new writer is set
+	errs := appenderr.New()
 	if l.writer != nil {
 		if l.writer.Stats().ByteCnt > 0 {
 			errs.Add(l.writer.Close())
@@ -64,7 +94,7 @@ func (l *Logger) CreateWriters(opts *file.Options, destination string) error {
 	}
 	// set new writer
 	l.writer = w
-	l.mu.Unlock()
+	l.path, l.seq = pth, seq
 	return errs.ErrOrNil()
 }
 
@@ -77,9 +107,22 @@ func (l *Logger) Read() {
 		if done {
 			break
 		}
+		if !l.logged(msg) {
+			l.mu.Lock()
+			l.Skipped++
+			l.mu.Unlock()
+			continue
+		}
 		l.mu.Lock()
 		l.writer.WriteLine(msg)
+		l.Messages++
+		full := l.full()
 		l.mu.Unlock()
+		if full {
+			if err := l.CreateWriters(l.opts, l.dest); err != nil {
+				log.Printf("rotate %s: %v", l.topic, err)
+			}
+		}
 	}
 
 	if l.writer.Stats().ByteCnt > 0 {
@@ -89,3 +132,59 @@ func (l *Logger) Read() {
 	}
 	l.done <- struct{}{}
 }
+
+// logged checks the task result filter of the topic
+func (l *Logger) logged(msg []byte) bool {
+	if l.results == nil {
+		return true
+	}
+	tsk, err := task.NewFromBytes(msg)
+	if err != nil {
+		return false
+	}
+	return l.results[string(tsk.Result)]
+}
+
+// full checks if the current file has reached the max size or lines
+func (l *Logger) full() bool {
+	sts := l.writer.Stats()
+	return (l.maxBytes > 0 && sts.ByteCnt >= l.maxBytes) ||
+		(l.maxLines > 0 && sts.LineCnt >= l.maxLines)
+}
+
+// compressPath adds the file extension of the compression
+func compressPath(pth, compress string) string {
+	var ext string
+	switch compress {
+	case "gzip", "gz":
+		ext = ".gz"
+	case "zstd", "zst":
+		ext = ".zst"
+	}
+	if strings.HasSuffix(pth, ext) {
+		return pth
+	}
+	return pth + ext
+}
+
+// seqPath adds the sequence number to the file name
+// before the extensions (dir/topic.json.gz -> dir/topic_1.json.gz)
+func seqPath(pth string, seq int) string {
+	if seq == 0 {
+		return pth
+	}
+	dir, name := path.Split(pth)
+	base, ext, _ := strings.Cut(name, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	return dir + base + "_" + strconv.Itoa(seq) + ext
+}
+
+func validCompress(s string) error {
+	switch s {
+	case "", "gzip", "gz", "zstd", "zst":
+		return nil
+	}
+	return errors.New("compress must be gzip or zstd")
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/hydronica/trial"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
 	"github.com/pcelvng/task/bus/nop"
 
@@ -113,6 +120,22 @@ func TestValidate(t *testing.T) {
 			},
 			ShouldErr: true,
 		},
+		"bad compress": {
+			Input: app{
+				Bus:      bus.Options{Bus: "nop"},
+				LogPath:  "nop://file",
+				Compress: "lz4",
+			},
+			ShouldErr: true,
+		},
+		"bad max size": {
+			Input: app{
+				Bus:     bus.Options{Bus: "nop"},
+				LogPath: "nop://file",
+				MaxSize: "10 bits",
+			},
+			ShouldErr: true,
+		},
 		"pubsub- no project id ": {
 			Input: app{
 				Bus: bus.Options{
@@ -126,3 +149,222 @@ func TestValidate(t *testing.T) {
 
 	trial.New(fn, cases).Test(t)
 }
+
+func TestSeqPath(t *testing.T) {
+	type input struct {
+		path     string
+		compress string
+		seq      int
+	}
+	fn := func(in input) (string, error) {
+		return seqPath(compressPath(in.path, in.compress), in.seq), nil
+	}
+	cases := trial.Cases[input, string]{
+		"first file": {
+			Input:    input{path: "dir/topic.json", compress: "gzip"},
+			Expected: "dir/topic.json.gz",
+		},
+		"extension in path": {
+			Input:    input{path: "dir/topic.json.gz", compress: "gzip"},
+			Expected: "dir/topic.json.gz",
+		},
+		"rotated zstd": {
+			Input:    input{path: "s3://bucket/dir/topic.json", compress: "zstd", seq: 2},
+			Expected: "s3://bucket/dir/topic_2.json.zst",
+		},
+		"no extension": {
+			Input:    input{path: "dir/topic", seq: 1},
+			Expected: "dir/topic_1",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+// msgConsumer returns each message then done
+type msgConsumer struct {
+	nop.Consumer
+	msgs [][]byte
+}
+
+func (c *msgConsumer) Msg() ([]byte, bool, error) {
+	if len(c.msgs) == 0 {
+		return nil, true, nil
+	}
+	b := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return b, false, nil
+}
+
+func (c *msgConsumer) Stop() error { return nil }
+
+func TestRotate(t *testing.T) {
+	type output struct {
+		Files   map[string]int // lines by file name
+		Skipped int
+	}
+	msgs := [][]byte{
+		task.New("t1", "?id=1").JSONBytes(),
+		[]byte(`{"type":"t1","info":"?id=2","result":"error"}`),
+		[]byte(`{"type":"t1","info":"?id=3","result":"complete"}`),
+		[]byte(`{"type":"t1","info":"?id=4","result":"error"}`),
+		[]byte(`{"type":"t1","info":"?id=5","result":"complete"}`),
+	}
+	fn := func(p policy) (output, error) {
+		dir := t.TempDir()
+		l, err := newlog("t1", &msgConsumer{msgs: msgs}, dir+"/{topic}.json", &file.Options{}, p)
+		if err != nil {
+			return output{}, err
+		}
+		l.Stop()
+		files, err := file.Glob(dir+"/*", nil)
+		if err != nil {
+			return output{}, err
+		}
+		out := output{Files: make(map[string]int), Skipped: l.Skipped}
+		for _, f := range files {
+			r, err := file.NewReader(f.Path, nil)
+			if err != nil {
+				return output{}, err
+			}
+			var lines int
+			if p.compress == "zstd" {
+				d, err := zstd.NewReader(r)
+				if err != nil {
+					return output{}, err
+				}
+				b, err := io.ReadAll(d)
+				d.Close()
+				if err != nil {
+					return output{}, err
+				}
+				lines = bytes.Count(b, []byte{'\n'})
+			} else {
+				for _, err := r.ReadLine(); err == nil; _, err = r.ReadLine() {
+					lines++
+				}
+			}
+			r.Close()
+			out.Files[path.Base(f.Path)] = lines
+		}
+		return out, nil
+	}
+	cases := trial.Cases[policy, output]{
+		"no rotation": {
+			Input:    policy{},
+			Expected: output{Files: map[string]int{"t1.json": 5}},
+		},
+		"max lines": {
+			Input:    policy{maxLines: 2},
+			Expected: output{Files: map[string]int{"t1.json": 2, "t1_1.json": 2, "t1_2.json": 1}},
+		},
+		"max size": {
+			Input:    policy{maxBytes: 1},
+			Expected: output{Files: map[string]int{"t1.json": 1, "t1_1.json": 1, "t1_2.json": 1, "t1_3.json": 1, "t1_4.json": 1}},
+		},
+		"gzip": {
+			Input:    policy{maxLines: 3, compress: "gzip"},
+			Expected: output{Files: map[string]int{"t1.json.gz": 3, "t1_1.json.gz": 2}},
+		},
+		"zstd": {
+			Input:    policy{maxLines: 3, compress: "zstd"},
+			Expected: output{Files: map[string]int{"t1.json.zst": 3, "t1_1.json.zst": 2}},
+		},
+		"error results": {
+			Input:    policy{results: map[string]bool{"error": true}},
+			Expected: output{Files: map[string]int{"t1.json": 2}, Skipped: 3},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestPolicy(t *testing.T) {
+	a := &app{
+		MaxLines: 10,
+		Results: map[string][]string{
+			"done": {"error", "alert"},
+			"*":    {"error"},
+		},
+	}
+	fn := func(topic string) (map[string]bool, error) {
+		return a.policy(topic).results, nil
+	}
+	cases := trial.Cases[string, map[string]bool]{
+		"topic":   {Input: "done", Expected: map[string]bool{"error": true, "alert": true}},
+		"default": {Input: "other", Expected: map[string]bool{"error": true}},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	a.Results = nil
+	if p := a.policy("done"); p.results != nil || p.maxLines != 10 {
+		t.Errorf("unexpected policy %+v", p)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	type input struct {
+		logPath string
+		files   map[string]time.Time
+	}
+	now := trial.TimeDay("2024-01-10")
+	old, recent := trial.TimeDay("2024-01-01"), trial.TimeDay("2024-01-09")
+	fn := func(in input) ([]string, error) {
+		dir := t.TempDir()
+		for name, tm := range in.files {
+			pth := dir + "/" + name
+			if err := os.MkdirAll(path.Dir(pth), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(pth, []byte("{}"), 0644); err != nil {
+				return nil, err
+			}
+			if err := os.Chtimes(pth, tm, tm); err != nil {
+				return nil, err
+			}
+		}
+		a := &app{
+			LogPath:       dir + "/" + in.logPath,
+			Compress:      "gzip",
+			RetentionDays: 7,
+		}
+		if err := a.removeExpired(now); err != nil {
+			return nil, err
+		}
+		var removed []string
+		for name := range in.files {
+			if _, err := os.Stat(dir + "/" + name); os.IsNotExist(err) {
+				removed = append(removed, name)
+			}
+		}
+		sort.Strings(removed)
+		return removed, nil
+	}
+	cases := trial.Cases[input, []string]{
+		"day": {
+			Input: input{
+				logPath: "{yyyy}/{mm}/{dd}/{topic}.json",
+				files: map[string]time.Time{
+					"2024/01/01/t1.json.gz":   old,
+					"2024/01/01/t1_1.json.gz": old,
+					"2024/01/09/t1.json.gz":   recent,
+					"2024/01/01/t1.txt":       old,
+				},
+			},
+			Expected: []string{"2024/01/01/t1.json.gz", "2024/01/01/t1_1.json.gz"},
+		},
+		"hour slug": {
+			Input: input{
+				logPath: "{HOUR_SLUG}/{topic}_{TS}.json",
+				files: map[string]time.Time{
+					"2024/01/01/05/t1_20240101T050000.json.gz":   old,
+					"2024/01/01/05/t1_20240101T050000_2.json.gz": old,
+					"2024/01/09/05/t1_20240109T050000.json.gz":   recent,
+					"2024/01/01/05/t1.json.gz":                   old, // no timestamp
+					"2024/01/01/05/t1_20240101T050000.json":      old, // not compressed
+					"data/01/01/05/t1_20240101T050000.json.gz":   old, // not a date directory
+				},
+			},
+			Expected: []string{"2024/01/01/05/t1_20240101T050000.json.gz", "2024/01/01/05/t1_20240101T050000_2.json.gz"},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
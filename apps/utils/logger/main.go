@@ -6,11 +6,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/inhies/go-bytesize"
 	"github.com/jbsmith7741/go-tools/appenderr"
 	"github.com/pcelvng/task/bus"
 
@@ -21,14 +24,24 @@ import (
 )
 
 type app struct {
-	StatusPort  int           `toml:"status_port"`
-	LogPath     string        `toml:"log_path" comment:"destination template path for the logs to be written to"`
-	Bus         bus.Options   `toml:"bus"`
-	PollPeriod  time.Duration `toml:"poll_period" comment:"refresh time to check current topics"`
-	File        file.Options  `toml:"file"`
-	RotateFiles time.Duration `toml:"rotate_files" comment:"time between rotation for log files default is an hour (3600000000000 nano seconds)"`
-	topics      map[string]*Logger
-	TopicPrefix string `toml:"topic_prefix" comment:"(optional) topic prefix filter. Can be used to only connect to topic with a certain prefix"`
+	StatusPort    int           `toml:"status_port"`
+	LogPath       string        `toml:"log_path" comment:"destination template path for the logs to be written to"`
+	Bus           bus.Options   `toml:"bus"`
+	PollPeriod    time.Duration `toml:"poll_period" comment:"refresh time to check current topics"`
+	File          file.Options  `toml:"file"`
+	RotateFiles   time.Duration `toml:"rotate_files" comment:"max age of a log file before it is rotated default is an hour (3600000000000 nano seconds)"`
+	MaxSize       string        `toml:"max_size" comment:"(optional) rotate a log file once it has the size of uncompressed data (100MB)"`
+	MaxLines      int64         `toml:"max_lines" comment:"(optional) rotate a log file once it has the number of lines"`
+	Compress      string        `toml:"compress" comment:"(optional) gzip or zstd compress the log files, the .gz or .zst extension is added to the log_path"`
+	RetentionDays int           `toml:"retention_days" comment:"(optional) delete log files matching the log_path older than the number of days"`
+	topics        map[string]*Logger
+	TopicPrefix   string `toml:"topic_prefix" comment:"(optional) topic prefix filter. Can be used to only connect to topic with a certain prefix"`
+
+	// Results to log by topic, * applies to all other topics
+	//   [results]
+	//     done = ["error", "alert"]
+	Results map[string][]string `toml:"results" comment:"(optional) only log tasks with the results by topic"`
+	maxSize int64
 }
 
 const (
@@ -68,7 +81,13 @@ func main() {
 func (a *app) Info() interface{} {
 	data := make(map[string]int)
 	for name, topic := range a.topics {
-		data[name] = topic.Messages
+		topic.mu.Lock()
+		messages, skipped := topic.Messages, topic.Skipped
+		topic.mu.Unlock()
+		data[name] = messages
+		if skipped > 0 {
+			data[name+"_skipped"] = skipped
+		}
 	}
 	return data
 }
@@ -110,7 +129,7 @@ func (a *app) UpdateTopics() {
 					continue
 				}
 				log.Printf("connecting to %s", t)
-				l, err := newlog(t, c, Parse(a.LogPath, t, time.Now()), &a.File)
+				l, err := newlog(t, c, a.LogPath, &a.File, a.policy(t))
 				if err != nil {
 					log.Fatalf("writer err for %s: %s", t, err)
 				}
@@ -129,7 +148,120 @@ func (a *app) RotateLogs() {
 			log.Println(err)
 			a.Stop()
 		}
+		if err := a.removeExpired(time.Now()); err != nil {
+			log.Println("retention:", err)
+		}
+	}
+}
+
+// policy of the topic logger
+func (a *app) policy(topic string) policy {
+	p := policy{
+		maxBytes: a.maxSize,
+		maxLines: a.MaxLines,
+		compress: a.Compress,
+	}
+	results, found := a.Results[topic]
+	if !found {
+		results, found = a.Results["*"]
+	}
+	if found {
+		p.results = make(map[string]bool)
+		for _, r := range results {
+			p.results[r] = true
+		}
 	}
+	return p
+}
+
+var (
+	tmplTags = regexp.MustCompile(`{[^}]+}`)
+	digits   = regexp.MustCompile(`[0-9]`)
+)
+
+// removeExpired deletes the log files matching the log_path
+// that were created before the retention days.
+func (a *app) removeExpired(now time.Time) error {
+	if a.RetentionDays <= 0 {
+		return nil
+	}
+	pattern, re, err := retentionMatch(compressPath(a.LogPath, a.Compress), now)
+	if err != nil {
+		return err
+	}
+	files, err := file.Glob(pattern, &a.File)
+	if err != nil {
+		return err
+	}
+	expire := now.AddDate(0, 0, -a.RetentionDays)
+	errs := appenderr.New()
+	var matched, count int
+	for _, f := range files {
+		if !re.MatchString(f.Path) {
+			continue
+		}
+		matched++
+		created := f.ParseCreated()
+		if created.IsZero() || !created.Before(expire) {
+			continue
+		}
+		if err := file.Remove(f.Path, &a.File); err != nil {
+			errs.Add(err)
+			continue
+		}
+		count++
+	}
+	if matched == 0 {
+		log.Printf("retention: no log files found for %s", pattern)
+	}
+	if count > 0 {
+		log.Printf("removed %d log files older than %d days", count, a.RetentionDays)
+	}
+	return errs.ErrOrNil()
+}
+
+// retentionMatch returns the glob pattern and regexp of the log files
+// written for the log path template. A tag in the glob pattern matches
+// as many directories as it expands to ({HOUR_SLUG} is */*/*/*).
+// The regexp only matches digits for time tags and the rotation
+// number so other files in the directories are not removed.
+func retentionMatch(logPath string, t time.Time) (string, *regexp.Regexp, error) {
+	pattern := tmplTags.ReplaceAllStringFunc(logPath, func(tag string) string {
+		return strings.Repeat("*/", strings.Count(Parse(tag, "", t), "/")) + "*"
+	})
+
+	// the regexp starts at the first directory with a tag
+	// so the path prefix does not need to match exactly
+	start := 0
+	if loc := tmplTags.FindStringIndex(logPath); loc != nil {
+		start = strings.LastIndex(logPath[:loc[0]], "/") + 1
+	}
+	dir, name := path.Split(logPath[start:])
+	base, ext, found := strings.Cut(name, ".")
+	if found {
+		ext = "." + ext
+	}
+	re, err := regexp.Compile("(^|/)" + tagExpr(dir+base, t) + `(_[0-9]+)?` + tagExpr(ext, t) + "$")
+	return pattern, re, err
+}
+
+// tagExpr is the regexp of the template where a topic matches
+// a file or directory name and other tags match their digits.
+func tagExpr(s string, t time.Time) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range tmplTags.FindAllStringIndex(s, -1) {
+		b.WriteString(regexp.QuoteMeta(s[last:loc[0]]))
+		tag := s[loc[0]:loc[1]]
+		if tag == "{topic}" || tag == "{TOPIC}" {
+			b.WriteString("[^/]+")
+		} else {
+			b.WriteString(digits.ReplaceAllString(regexp.QuoteMeta(Parse(tag, "", t)), "[0-9]"))
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(s[last:]))
+	return b.String()
 }
 
 func (a *app) rotateWriters() error {
@@ -176,5 +308,15 @@ func (a *app) Validate() error {
 	if a.LogPath == "" {
 		return errors.New("error: log path is required")
 	}
+	if err := validCompress(a.Compress); err != nil {
+		return err
+	}
+	if a.MaxSize != "" {
+		b, err := bytesize.Parse(a.MaxSize)
+		if err != nil {
+			return fmt.Errorf("max_size: %w", err)
+		}
+		a.maxSize = int64(b)
+	}
 	return nil
 }
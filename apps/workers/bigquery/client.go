@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/option"
+)
+
+// client is the BigQuery operations used by the worker. Every job
+// waits until it is done and returns its statistics or the job error.
+type client interface {
+	// Load appends (or truncates) the source data into the table
+	Load(ctx context.Context, dst Destination, src loadSource, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error)
+
+	// Query runs the query, results can be read from the returned job
+	Query(ctx context.Context, q queryConfig) (queryJob, error)
+
+	// Extract exports the table to files in GCS
+	Extract(ctx context.Context, src Destination, dst *bigquery.GCSReference, disableHeader bool) (*bigquery.JobStatistics, error)
+
+	// Copy copies or snapshots the src table to the dst table
+	Copy(ctx context.Context, src, dst Destination, op bigquery.TableCopyOperationType, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error)
+
+	// SetExpiration sets the time the table is deleted
+	SetExpiration(ctx context.Context, table Destination, t time.Time) error
+
+	Close() error
+}
+
+// loadSource is the data of a load job, either a GCS reference
+// or a reader of the file
+type loadSource struct {
+	GCSPath string
+	Reader  io.Reader
+	Format  bigquery.DataFormat
+}
+
+// queryConfig describes a query job
+type queryConfig struct {
+	SQL         string
+	Params      []bigquery.QueryParameter
+	Priority    bigquery.QueryPriority
+	Dst         *Destination // table to write results to (optional)
+	Disposition bigquery.TableWriteDisposition
+	DryRun      bool
+}
+
+// queryJob is a completed query
+type queryJob interface {
+	// Statistics of the job, dry runs only include the bytes processed.
+	// nil when the job did not return statistics.
+	Statistics() *bigquery.JobStatistics
+
+	// Read returns an iterator over the query results
+	Read(ctx context.Context) (rowIterator, error)
+}
+
+// rowIterator iterates over query results (see bigquery.RowIterator)
+type rowIterator interface {
+	Schema() bigquery.Schema
+	Next(dst interface{}) error
+}
+
+// bqClient implements client with a bigquery.Client
+type bqClient struct {
+	*bigquery.Client
+}
+
+func newBQClient(ctx context.Context, project, auth string) (*bqClient, error) {
+	opts := make([]option.ClientOption, 0)
+	if auth != "" {
+		opts = append(opts, option.WithCredentialsFile(auth))
+	}
+	c, err := bigquery.NewClient(ctx, project, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &bqClient{Client: c}, nil
+}
+
+func (c *bqClient) table(d Destination) *bigquery.Table {
+	return d.BqTable(c.Client)
+}
+
+// wait for the job to finish and return the job error if any
+func wait(ctx context.Context, job *bigquery.Job) (*bigquery.JobStatus, error) {
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return status, status.Err()
+}
+
+func (c *bqClient) Load(ctx context.Context, dst Destination, src loadSource, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error) {
+	var loader *bigquery.Loader
+	if src.GCSPath != "" {
+		gcsRef := bigquery.NewGCSReference(src.GCSPath)
+		gcsRef.SourceFormat = src.Format
+		loader = c.table(dst).LoaderFrom(gcsRef)
+	} else {
+		bqRef := bigquery.NewReaderSource(src.Reader)
+		bqRef.SourceFormat = src.Format
+		bqRef.MaxBadRecords = 1
+		loader = c.table(dst).LoaderFrom(bqRef)
+	}
+	loader.WriteDisposition = disposition
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := wait(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return status.Statistics, nil
+}
+
+func (c *bqClient) Query(ctx context.Context, q queryConfig) (queryJob, error) {
+	bq := c.Client.Query(q.SQL)
+	bq.Parameters = q.Params
+	bq.Priority = q.Priority
+	bq.DryRun = q.DryRun
+	if q.Dst != nil {
+		bq.Dst = c.table(*q.Dst)
+		bq.WriteDisposition = q.Disposition
+	}
+	job, err := bq.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if q.DryRun {
+		// dry run jobs are not created, the statistics are returned immediately
+		return &bqJob{job: job, status: job.LastStatus()}, nil
+	}
+	status, err := wait(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return &bqJob{job: job, status: status}, nil
+}
+
+func (c *bqClient) Extract(ctx context.Context, src Destination, dst *bigquery.GCSReference, disableHeader bool) (*bigquery.JobStatistics, error) {
+	extractor := c.table(src).ExtractorTo(dst)
+	extractor.DisableHeader = disableHeader
+	job, err := extractor.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := wait(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return status.Statistics, nil
+}
+
+func (c *bqClient) Copy(ctx context.Context, src, dst Destination, op bigquery.TableCopyOperationType, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error) {
+	copier := c.table(dst).CopierFrom(c.table(src))
+	copier.OperationType = op
+	copier.WriteDisposition = disposition
+	job, err := copier.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := wait(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return status.Statistics, nil
+}
+
+func (c *bqClient) SetExpiration(ctx context.Context, table Destination, t time.Time) error {
+	_, err := c.table(table).Update(ctx, bigquery.TableMetadataToUpdate{ExpirationTime: t}, "")
+	return err
+}
+
+// bqJob implements queryJob
+type bqJob struct {
+	job    *bigquery.Job
+	status *bigquery.JobStatus
+}
+
+func (j *bqJob) Statistics() *bigquery.JobStatistics {
+	if j.status == nil {
+		return nil
+	}
+	return j.status.Statistics
+}
+
+func (j *bqJob) Read(ctx context.Context) (rowIterator, error) {
+	it, err := j.job.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &bqRows{it: it}, nil
+}
+
+// bqRows implements rowIterator
+type bqRows struct {
+	it *bigquery.RowIterator
+}
+
+func (r *bqRows) Schema() bigquery.Schema    { return r.it.Schema }
+func (r *bqRows) Next(dst interface{}) error { return r.it.Next(dst) }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pcelvng/task"
+)
+
+// extractFormat returns the file format and compression of an extract job.
+// Defaults are based on the dest_path extension (.json.gz is gzipped json).
+func extractFormat(format, compression, pth string) (bigquery.DataFormat, bigquery.Compression, error) {
+	gz := strings.HasSuffix(pth, ".gz")
+	pth = strings.TrimSuffix(pth, ".gz")
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(pth), ".")
+	}
+
+	var f bigquery.DataFormat
+	switch strings.ToLower(format) {
+	case "csv":
+		f = bigquery.CSV
+	case "json":
+		f = bigquery.JSON
+	case "avro":
+		f = bigquery.Avro
+	case "parquet":
+		f = bigquery.Parquet
+	default:
+		return "", "", fmt.Errorf("unsupported extract format %q (csv, json, avro or parquet)", format)
+	}
+
+	c := bigquery.None
+	if gz {
+		c = bigquery.Gzip
+	}
+	switch strings.ToLower(compression) {
+	case "":
+	case "none":
+		c = bigquery.None
+	case "gzip":
+		c = bigquery.Gzip
+	case "deflate":
+		c = bigquery.Deflate
+	case "snappy":
+		c = bigquery.Snappy
+	default:
+		return "", "", fmt.Errorf("unsupported compression %q (none, gzip, deflate or snappy)", compression)
+	}
+	return f, c, nil
+}
+
+// Extract exports the origin table to files in GCS
+func (w *worker) Extract(ctx context.Context, client client) (task.Result, string) {
+	format, compression, _ := extractFormat(w.Format, w.Compression, w.DestPath)
+	gcsRef := bigquery.NewGCSReference(w.DestPath)
+	gcsRef.DestinationFormat = format
+	gcsRef.Compression = compression
+
+	stats, err := client.Extract(ctx, w.srcTable, gcsRef, w.NoHeader)
+	if err != nil {
+		return task.Failf("extract: %v", err)
+	}
+
+	var files int64
+	if sts, ok := stats.Details.(*bigquery.ExtractStatistics); ok {
+		for _, c := range sts.DestinationURIFileCounts {
+			files += c
+		}
+	}
+	w.SetMeta("file", w.DestPath)
+	w.SetMeta("files", strconv.FormatInt(files, 10))
+	return task.Completed("%s extracted to %d files at %s", w.srcTable, files, w.DestPath)
+}
+
+// Copy copies or snapshots the origin table to the dest_table
+func (w *worker) Copy(ctx context.Context, client client, op bigquery.TableCopyOperationType) (task.Result, string) {
+	dest := w.DestTable.Partition(w.Partition)
+	var disposition bigquery.TableWriteDisposition
+	switch {
+	case op == bigquery.SnapshotOperation:
+		disposition = bigquery.WriteEmpty // snapshots must be new tables
+	case w.Truncate:
+		disposition = bigquery.WriteTruncate
+	default:
+		disposition = bigquery.WriteAppend
+	}
+
+	if _, err := client.Copy(ctx, w.srcTable, dest, op, disposition); err != nil {
+		return task.Failf("%s: %v", strings.ToLower(string(op)), err)
+	}
+
+	if op == bigquery.SnapshotOperation && w.Expiration > 0 {
+		exp := time.Now().Add(w.Expiration)
+		if err := client.SetExpiration(ctx, dest, exp); err != nil {
+			return task.Failf("snapshot expiration: %s", err)
+		}
+		w.SetMeta("expires", exp.UTC().Format(time.RFC3339))
+	}
+	if op == bigquery.SnapshotOperation {
+		return task.Completed("snapshot of %s created at %s", w.srcTable, dest)
+	}
+	return task.Completed("%s copied to %s", w.srcTable, dest)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// fakeClient is an in-memory client. Tables are a list of json
+// rows keyed by the table name (including any partition decorator).
+type fakeClient struct {
+	tables map[string][]string
+	files  map[string][]string // gcs files available to load
+
+	schema bigquery.Schema    // schema of the query results
+	rows   [][]bigquery.Value // query results
+	bytes  int64              // bytes processed by every query
+
+	queries  []queryConfig
+	extracts []string // extract uri and format
+	expires  map[string]time.Time
+	err      error // returned by every job
+	noStats  bool  // queries return no statistics
+	closed   bool
+}
+
+func newFakeClient(tables map[string][]string) *fakeClient {
+	if tables == nil {
+		tables = make(map[string][]string)
+	}
+	return &fakeClient{
+		tables:  tables,
+		files:   make(map[string][]string),
+		expires: make(map[string]time.Time),
+	}
+}
+
+func (c *fakeClient) Load(_ context.Context, dst Destination, src loadSource, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var rows []string
+	if src.GCSPath != "" {
+		var found bool
+		if rows, found = c.files[src.GCSPath]; !found {
+			return nil, fmt.Errorf("not found: %s", src.GCSPath)
+		}
+	} else {
+		scanner := bufio.NewScanner(src.Reader)
+		for scanner.Scan() {
+			rows = append(rows, scanner.Text())
+		}
+	}
+	var size int64
+	for _, r := range rows {
+		size += int64(len(r))
+	}
+	c.write(dst.String(), rows, disposition)
+	return &bigquery.JobStatistics{Details: &bigquery.LoadStatistics{
+		OutputRows:  int64(len(rows)),
+		OutputBytes: size,
+	}}, nil
+}
+
+func (c *fakeClient) write(table string, rows []string, disposition bigquery.TableWriteDisposition) {
+	if disposition == bigquery.WriteTruncate {
+		c.tables[table] = nil
+	}
+	c.tables[table] = append(c.tables[table], rows...)
+}
+
+var deleteRe = regexp.MustCompile("^delete from `(.+)` where (.+)$")
+
+func (c *fakeClient) Query(_ context.Context, q queryConfig) (queryJob, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.queries = append(c.queries, q)
+	if c.noStats {
+		return &fakeJob{}, nil
+	}
+	stats := &bigquery.JobStatistics{TotalBytesProcessed: c.bytes}
+	if q.DryRun {
+		return &fakeJob{stats: stats}, nil
+	}
+	details := &bigquery.QueryStatistics{TotalBytesBilled: c.bytes}
+	stats.Details = details
+	if m := deleteRe.FindStringSubmatch(q.SQL); m != nil {
+		details.NumDMLAffectedRows = c.delete(m[1], strings.Split(m[2], " and "))
+		return &fakeJob{stats: stats}, nil
+	}
+	job := &fakeJob{stats: stats, schema: c.schema, rows: c.rows}
+	if q.Dst != nil {
+		var rows []string
+		for _, r := range c.rows {
+			m := make(map[string]bigquery.Value)
+			for i, f := range c.schema {
+				m[f.Name] = r[i]
+			}
+			b, _ := json.Marshal(m)
+			rows = append(rows, string(b))
+		}
+		c.write(q.Dst.String(), rows, q.Disposition)
+	}
+	return job, nil
+}
+
+// delete removes rows that match all the "field = value" conditions
+func (c *fakeClient) delete(table string, conditions []string) int64 {
+	var kept []string
+	var deleted int64
+	for _, r := range c.tables[table] {
+		row := make(map[string]any)
+		json.Unmarshal([]byte(r), &row)
+		match := true
+		for _, cond := range conditions {
+			k, v, _ := strings.Cut(cond, " = ")
+			if fmt.Sprint(row[k]) != strings.Trim(v, `'"`) {
+				match = false
+			}
+		}
+		if match {
+			deleted++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	c.tables[table] = kept
+	return deleted
+}
+
+func (c *fakeClient) Extract(_ context.Context, src Destination, dst *bigquery.GCSReference, _ bool) (*bigquery.JobStatistics, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if _, found := c.tables[src.String()]; !found {
+		return nil, fmt.Errorf("not found: table %s", src)
+	}
+	c.extracts = append(c.extracts, fmt.Sprintf("%s %s %s", strings.Join(dst.URIs, ","), dst.DestinationFormat, dst.Compression))
+	return &bigquery.JobStatistics{Details: &bigquery.ExtractStatistics{DestinationURIFileCounts: []int64{1}}}, nil
+}
+
+func (c *fakeClient) Copy(_ context.Context, src, dst Destination, op bigquery.TableCopyOperationType, disposition bigquery.TableWriteDisposition) (*bigquery.JobStatistics, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	rows, found := c.tables[src.String()]
+	if !found {
+		return nil, fmt.Errorf("not found: table %s", src)
+	}
+	if _, exists := c.tables[dst.String()]; exists && disposition == bigquery.WriteEmpty {
+		return nil, fmt.Errorf("already exists: table %s", dst)
+	}
+	c.write(dst.String(), rows, disposition)
+	return &bigquery.JobStatistics{}, nil
+}
+
+func (c *fakeClient) SetExpiration(_ context.Context, table Destination, t time.Time) error {
+	c.expires[table.String()] = t
+	return nil
+}
+
+func (c *fakeClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+// fakeJob is a completed query
+type fakeJob struct {
+	stats  *bigquery.JobStatistics
+	schema bigquery.Schema
+	rows   [][]bigquery.Value
+}
+
+func (j *fakeJob) Statistics() *bigquery.JobStatistics { return j.stats }
+
+func (j *fakeJob) Read(context.Context) (rowIterator, error) {
+	return &fakeRows{schema: j.schema, rows: j.rows}, nil
+}
+
+type fakeRows struct {
+	schema bigquery.Schema
+	rows   [][]bigquery.Value
+}
+
+func (r *fakeRows) Schema() bigquery.Schema { return r.schema }
+
+func (r *fakeRows) Next(dst interface{}) error {
+	if len(r.rows) == 0 {
+		return iterator.Done
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return dst.(bigquery.ValueLoader).Load(row, r.schema)
+}
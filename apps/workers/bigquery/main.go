@@ -32,7 +32,7 @@ func main() {
 	app := bootstrap.NewWorkerApp(taskType, opts.NewWorker, opts).
 		Description(desc).
 		Version(tools.Version).Initialize()
-	
+
 	app.Run()
 }
 
@@ -60,6 +60,14 @@ func (d Destination) String() string {
 	return d.Project + "." + d.Dataset + "." + d.Table
 }
 
+// Partition returns the destination with a partition decorator (table$20200102)
+func (d Destination) Partition(p string) Destination {
+	if p != "" {
+		d.Table += "$" + p
+	}
+	return d
+}
+
 func (d Destination) BqTable(client *bigquery.Client) *bigquery.Table {
 	return client.DatasetInProject(d.Project, d.Dataset).Table(d.Table)
 }
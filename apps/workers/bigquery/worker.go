@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,7 +16,6 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
-	"google.golang.org/api/option"
 
 	"github.com/pcelvng/task-tools/file"
 )
@@ -28,19 +28,43 @@ type worker struct {
 	SrcTable  Destination `uri:"src_table"`  // Used for table templating
 
 	File        string            `uri:"origin" required:"true"`      // if not GCS ref must be file, can be folder (for GCS)
+	Job         string            `uri:"job"`                         // load, query, extract, copy or snapshot (default based on the origin file)
 	FromGCS     bool              `uri:"direct_load" default:"false"` // load directly from GCS ref, can use wildcards *
 	Truncate    bool              `uri:"truncate"`                    //remove all data in table before insert
 	DeleteMap   map[string]string `uri:"delete"`                      // map of fields with value to check and delete
 	QueryParams map[string]string `uri:"params"`                      // query parameters in format param:value
+	Partition   string            `uri:"partition"`                   // partition decorator of the dest_table (YYYYMMDD)
+	DryRun      bool              `uri:"dry_run"`                     // validate a query and report the bytes it would process
 
 	// Read options
 	Interactive bool   `uri:"interactive"` // makes queries run faster for local development
 	DestPath    string `uri:"dest_path"`
 
+	// Extract options
+	Format      string `uri:"format"`      // csv, json, avro or parquet (default based on dest_path)
+	Compression string `uri:"compression"` // none, gzip, deflate or snappy (default gzip for .gz files)
+	NoHeader    bool   `uri:"no_header"`   // do not write a header row to csv files
+
+	// Snapshot options
+	Expiration time.Duration `uri:"expiration"` // time until the snapshot table expires
+
 	writeToFile bool
 	delete      bool
+	srcTable    Destination // origin table of extract, copy and snapshot jobs
+	client      client      // created and closed in DoTask when not set
 }
 
+// job types
+const (
+	jobLoad     = "load"
+	jobQuery    = "query"
+	jobExtract  = "extract"
+	jobCopy     = "copy"
+	jobSnapshot = "snapshot"
+)
+
+var partitionRe = regexp.MustCompile(`^(\d{4}|\d{6}|\d{8}|\d{10}|__NULL__|__UNPARTITIONED__)$`)
+
 func (o *options) NewWorker(info string) task.Worker {
 	w := &worker{
 		Meta:    task.NewMeta(),
@@ -56,6 +80,27 @@ func (o *options) NewWorker(info string) task.Worker {
 	if w.delete && w.Truncate {
 		return task.InvalidWorker("truncate and delete options must be selected independently")
 	}
+	if w.Partition != "" {
+		w.Partition = strings.ReplaceAll(w.Partition, "-", "")
+		if !partitionRe.MatchString(w.Partition) {
+			return task.InvalidWorker("invalid partition %q (YYYY, YYYYMM, YYYYMMDD or YYYYMMDDHH)", w.Partition)
+		}
+	}
+
+	job := w.jobType()
+	if w.DryRun && job != jobQuery {
+		return task.InvalidWorker("dry_run is only supported for queries")
+	}
+	switch job {
+	case jobLoad, jobQuery, "":
+	case jobExtract, jobCopy, jobSnapshot:
+		if err := w.srcTable.UnmarshalText([]byte(w.File)); err != nil {
+			return task.InvalidWorker("origin table %v", err)
+		}
+		return w.validateTableJob(job)
+	default:
+		return task.InvalidWorker("unknown job %q (load, query, extract, copy or snapshot)", w.Job)
+	}
 
 	if w.DestPath == "" && w.DestTable.IsZero() {
 		if filepath.Ext(w.File) != ".sql" {
@@ -66,16 +111,66 @@ func (o *options) NewWorker(info string) task.Worker {
 	return w
 }
 
+// jobType is the job set in the info string or the default for the origin file
+func (w *worker) jobType() string {
+	if w.Job != "" {
+		return w.Job
+	}
+	switch filepath.Ext(w.File) {
+	case ".sql":
+		return jobQuery
+	case ".json", ".csv":
+		return jobLoad
+	}
+	return ""
+}
+
+// validateTableJob checks the options of jobs that read from the origin table
+func (w *worker) validateTableJob(job string) task.Worker {
+	if w.delete {
+		return task.InvalidWorker("delete is not supported for %s jobs", job)
+	}
+	if job == jobExtract {
+		if !strings.HasPrefix(w.DestPath, "gs://") {
+			return task.InvalidWorker("extract requires a gs:// dest_path")
+		}
+		if _, _, err := extractFormat(w.Format, w.Compression, w.DestPath); err != nil {
+			return task.InvalidWorker("%v", err)
+		}
+		return w
+	}
+	if w.DestTable.IsZero() {
+		return task.InvalidWorker("requires dest_table (project.dataset.table)")
+	}
+	if job == jobSnapshot && w.Truncate {
+		return task.InvalidWorker("truncate is not supported for snapshots")
+	}
+	if job == jobCopy && w.Expiration > 0 {
+		return task.InvalidWorker("expiration is only supported for snapshots")
+	}
+	return w
+}
+
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
-	opts := make([]option.ClientOption, 0)
-	if w.BqAuth != "" {
-		opts = append(opts, option.WithCredentialsFile(w.BqAuth))
+	// only a client created by the worker is closed
+	client := w.client
+	if client == nil {
+		c, err := newBQClient(ctx, w.Project, w.BqAuth)
+		if err != nil {
+			return task.Failf("bigquery client init %s", err)
+		}
+		defer c.Close()
+		client = c
 	}
-	client, err := bigquery.NewClient(ctx, w.Project, opts...)
-	if err != nil {
-		return task.Failf("bigquery client init %s", err)
+
+	switch w.jobType() {
+	case jobExtract:
+		return w.Extract(ctx, client)
+	case jobCopy:
+		return w.Copy(ctx, client, bigquery.CopyOperation)
+	case jobSnapshot:
+		return w.Copy(ctx, client, bigquery.SnapshotOperation)
 	}
-	defer client.Close()
 
 	var format bigquery.DataFormat
 	switch filepath.Ext(w.File) {
@@ -125,60 +220,44 @@ func isGCSExport(s string) bool {
 	return !strings.Contains(d, "*") && strings.Count(f, "*") == 1
 }
 
-func (w *worker) Load(ctx context.Context, client *bigquery.Client, format bigquery.DataFormat) (task.Result, string) {
-	var loader *bigquery.Loader
+func (w *worker) Load(ctx context.Context, client client, format bigquery.DataFormat) (task.Result, string) {
+	src := loadSource{Format: format}
 	if w.FromGCS { // load from Google Cloud Storage
-		gcsRef := bigquery.NewGCSReference(w.File)
-		gcsRef.SourceFormat = format
-		loader = w.DestTable.BqTable(client).LoaderFrom(gcsRef)
+		src.GCSPath = w.File
 	} else { // load from file reader
 		r, err := file.NewReader(w.File, &w.Fopts)
 		if err != nil {
 			return task.Failf("problem with file: %s", err)
 		}
-		bqRef := bigquery.NewReaderSource(r)
-		bqRef.SourceFormat = format
-		bqRef.MaxBadRecords = 1
-		loader = w.DestTable.BqTable(client).LoaderFrom(bqRef)
+		defer r.Close()
+		src.Reader = r
 	}
 
-	loader.WriteDisposition = bigquery.WriteAppend
 	if len(w.DeleteMap) > 0 {
 		q := delStatement(w.DeleteMap, w.DestTable)
-		j, err := client.Query(q).Run(ctx)
-		if err != nil {
-			return task.Failf("delete statement: %s", err)
-		}
-		status, err := j.Wait(ctx)
+		job, err := client.Query(ctx, queryConfig{SQL: q})
 		if err != nil {
-			return task.Failf("delete wait: %s", err)
+			return task.Failf("delete: %s", err)
 		}
-		if status.Err() != nil {
-			return task.Failf("delete: %s", status.Err())
-		}
-		status = j.LastStatus()
-		if qSts, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
-			w.SetMeta("rows_del", strconv.FormatInt(qSts.NumDMLAffectedRows, 10))
+		if stats := job.Statistics(); stats != nil {
+			if qSts, ok := stats.Details.(*bigquery.QueryStatistics); ok {
+				w.SetMeta("rows_del", strconv.FormatInt(qSts.NumDMLAffectedRows, 10))
+			}
 		}
 	}
 
+	disposition := bigquery.WriteAppend
 	if w.Truncate {
-		loader.WriteDisposition = bigquery.WriteTruncate
+		disposition = bigquery.WriteTruncate
 	}
 
-	job, err := loader.Run(ctx)
+	stats, err := client.Load(ctx, w.DestTable.Partition(w.Partition), src, disposition)
 	if err != nil {
-		return task.Failf("loader run: %s", err)
+		return task.Failf("load: %v", err)
 	}
-	status, err := job.Wait(ctx)
-	if err == nil {
-		if status.Err() != nil {
-			return task.Failf("job completed with error: %v", status.Errors)
-		}
-		if sts, ok := status.Statistics.Details.(*bigquery.LoadStatistics); ok {
-			w.SetMeta("rows_insert", strconv.FormatInt(sts.OutputRows, 10))
-			return task.Completed("%d rows (%s) loaded", sts.OutputRows, humanize.Bytes(uint64(sts.OutputBytes)))
-		}
+	if sts, ok := stats.Details.(*bigquery.LoadStatistics); ok {
+		w.SetMeta("rows_insert", strconv.FormatInt(sts.OutputRows, 10))
+		return task.Completed("%d rows (%s) loaded", sts.OutputRows, humanize.Bytes(uint64(sts.OutputBytes)))
 	}
 
 	return task.Completed("completed")
@@ -194,8 +273,8 @@ func delStatement(m map[string]string, d Destination) string {
 	return fmt.Sprintf("delete from `%s` where %s", d.String(), strings.Join(s, " and "))
 }
 
-func (w *worker) Query(ctx context.Context, client *bigquery.Client, query string) (task.Result, string) {
-	bq := client.Query(query)
+func (w *worker) Query(ctx context.Context, client client, query string) (task.Result, string) {
+	bq := queryConfig{SQL: query, DryRun: w.DryRun}
 
 	// Add query parameters if provided
 	if len(w.QueryParams) > 0 {
@@ -208,7 +287,8 @@ func (w *worker) Query(ctx context.Context, client *bigquery.Client, query strin
 			param := inferQueryParameter(name, value)
 			params = append(params, param)
 		}
-		bq.Parameters = params
+		sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+		bq.Params = params
 	}
 
 	if w.Interactive {
@@ -217,24 +297,32 @@ func (w *worker) Query(ctx context.Context, client *bigquery.Client, query strin
 		bq.Priority = bigquery.BatchPriority
 	}
 
-	if w.DestTable.String() != "" && w.DestPath == "" {
+	if !w.DestTable.IsZero() && w.DestPath == "" {
 		// project is defined in the client
-		bq.Dst = w.DestTable.BqTable(client)
-		bq.WriteDisposition = bigquery.WriteAppend
+		bq.Dst = &w.DestTable
+		bq.Disposition = bigquery.WriteAppend
 	}
-	job, err := bq.Run(ctx)
+	job, err := client.Query(ctx, bq)
 	if err != nil {
-		return task.Failf("bq build: %v", err)
+		return task.Failf("query: %v", err)
+	}
+	stats := job.Statistics()
+	if stats == nil {
+		if w.DryRun {
+			return task.Failf("dry run: no statistics returned")
+		}
+		stats = &bigquery.JobStatistics{}
 	}
-	status, err := job.Wait(ctx)
-	if err != nil || status.Err() != nil {
-		return task.Failf("wait: %v", err)
+	if w.DryRun {
+		bytes := stats.TotalBytesProcessed
+		w.SetMeta("bq_bytes_processed", strconv.FormatInt(bytes, 10))
+		return task.Completed("dry run: %s would be processed", humanize.Bytes(uint64(bytes)))
 	}
 
-	if bqStats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+	if bqStats, ok := stats.Details.(*bigquery.QueryStatistics); ok {
 
 		w.SetMeta("bq_bytes_billed", strconv.FormatInt(bqStats.TotalBytesBilled, 10))
-		w.SetMeta("bq_query_time", status.Statistics.EndTime.Sub(status.Statistics.StartTime).String())
+		w.SetMeta("bq_query_time", stats.EndTime.Sub(stats.StartTime).String())
 	}
 
 	var msg string
@@ -245,14 +333,19 @@ func (w *worker) Query(ctx context.Context, client *bigquery.Client, query strin
 			return task.Failf("writer create(%v): %v", w.DestPath, err)
 		}
 		format := strings.Trim(filepath.Ext(w.DestPath), ".")
-		if sts, err := writeToFile(ctx, job, writer, format); err != nil {
+		rows, err := job.Read(ctx)
+		if err != nil {
+			writer.Abort()
+			return task.Failf("read results: %v", err)
+		}
+		if sts, err := writeToFile(rows, writer, format); err != nil {
 			return task.Failf("write to %v: %v", w.DestPath, err)
 		} else {
 			msg = fmt.Sprintf("%d lines writen to %v", sts.LineCnt, sts.Path)
 		}
 
 	}
-	return task.Completed("BQ byte processed: %v "+msg, humanize.Bytes(uint64(status.Statistics.TotalBytesProcessed)))
+	return task.Completed("BQ byte processed: %v "+msg, humanize.Bytes(uint64(stats.TotalBytesProcessed)))
 }
 
 var timeFormats = [...]string{
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
 
 	"github.com/hydronica/trial"
 	"github.com/pcelvng/task"
@@ -70,6 +77,88 @@ func TestNewWorker(t *testing.T) {
 				DestPath: "output.json",
 			},
 		},
+		"load partition": {
+			Input: "gs://file.json?dest_table=p.d.t&partition=2020-01-02&truncate",
+			Expected: &worker{
+				Meta:      task.NewMeta(),
+				File:      "gs://file.json",
+				DestTable: Destination{"p", "d", "t"},
+				Partition: "20200102",
+				Truncate:  true,
+			},
+		},
+		"invalid partition": {
+			Input:       "gs://file.json?dest_table=p.d.t&partition=2020010",
+			ExpectedErr: errors.New("invalid partition"),
+		},
+		"extract": {
+			Input: "p.d.t?job=extract&dest_path=gs://bucket/out/*.json.gz",
+			Expected: &worker{
+				Meta:     task.NewMeta(),
+				File:     "p.d.t",
+				Job:      "extract",
+				DestPath: "gs://bucket/out/*.json.gz",
+				srcTable: Destination{"p", "d", "t"},
+			},
+		},
+		"extract not gcs": {
+			Input:       "p.d.t?job=extract&dest_path=s3://bucket/out/*.json",
+			ExpectedErr: errors.New("extract requires a gs:// dest_path"),
+		},
+		"extract format": {
+			Input:       "p.d.t?job=extract&dest_path=gs://bucket/out/*.txt",
+			ExpectedErr: errors.New("unsupported extract format"),
+		},
+		"extract invalid table": {
+			Input:       "gs://file.json?job=extract&dest_path=gs://bucket/out/*.json",
+			ExpectedErr: errors.New("origin table requires (project.dataset.table)"),
+		},
+		"copy": {
+			Input: "p.d.t?job=copy&dest_table=p.d.t2&truncate",
+			Expected: &worker{
+				Meta:      task.NewMeta(),
+				File:      "p.d.t",
+				Job:       "copy",
+				DestTable: Destination{"p", "d", "t2"},
+				Truncate:  true,
+				srcTable:  Destination{"p", "d", "t"},
+			},
+		},
+		"copy without dest": {
+			Input:       "p.d.t?job=copy",
+			ExpectedErr: errors.New("requires dest_table"),
+		},
+		"snapshot": {
+			Input: "p.d.t?job=snapshot&dest_table=p.d.t_snap&expiration=72h",
+			Expected: &worker{
+				Meta:       task.NewMeta(),
+				File:       "p.d.t",
+				Job:        "snapshot",
+				DestTable:  Destination{"p", "d", "t_snap"},
+				Expiration: 72 * time.Hour,
+				srcTable:   Destination{"p", "d", "t"},
+			},
+		},
+		"snapshot truncate": {
+			Input:       "p.d.t?job=snapshot&dest_table=p.d.t_snap&truncate",
+			ExpectedErr: errors.New("truncate is not supported for snapshots"),
+		},
+		"unknown job": {
+			Input:       "p.d.t?job=merge&dest_table=p.d.t2",
+			ExpectedErr: errors.New("unknown job"),
+		},
+		"dry run": {
+			Input: "gs://query.sql?dry_run",
+			Expected: &worker{
+				Meta:   map[string][]string{"warn": {"query ran with no destination"}},
+				File:   "gs://query.sql",
+				DryRun: true,
+			},
+		},
+		"dry run load": {
+			Input:       "gs://file.json?dest_table=p.d.t&dry_run",
+			ExpectedErr: errors.New("dry_run is only supported for queries"),
+		},
 		"query_without_dest": {
 			Input: "gs://query.sql",
 			Expected: &worker{
@@ -115,3 +204,257 @@ func TestIsGCSExport(t *testing.T) {
 	}
 	trial.New(fn, cases).Test(t)
 }
+
+func TestExtractFormat(t *testing.T) {
+	type input struct {
+		format, compression, path string
+	}
+	type output struct {
+		Format      bigquery.DataFormat
+		Compression bigquery.Compression
+	}
+	fn := func(in input) (output, error) {
+		f, c, err := extractFormat(in.format, in.compression, in.path)
+		return output{f, c}, err
+	}
+	cases := trial.Cases[input, output]{
+		"json": {
+			Input:    input{path: "gs://bucket/*.json"},
+			Expected: output{bigquery.JSON, bigquery.None},
+		},
+		"gzip csv": {
+			Input:    input{path: "gs://bucket/*.csv.gz"},
+			Expected: output{bigquery.CSV, bigquery.Gzip},
+		},
+		"avro snappy": {
+			Input:    input{format: "avro", compression: "snappy", path: "gs://bucket/*"},
+			Expected: output{bigquery.Avro, bigquery.Snappy},
+		},
+		"parquet": {
+			Input:    input{path: "gs://bucket/data-*.parquet"},
+			Expected: output{bigquery.Parquet, bigquery.None},
+		},
+		"format override": {
+			Input:    input{format: "json", compression: "none", path: "gs://bucket/*.txt.gz"},
+			Expected: output{bigquery.JSON, bigquery.None},
+		},
+		"unknown format": {
+			Input:     input{path: "gs://bucket/*.txt"},
+			ShouldErr: true,
+		},
+		"unknown compression": {
+			Input:     input{compression: "zip", path: "gs://bucket/*.csv"},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWorker_DoTask(t *testing.T) {
+	type output struct {
+		Msg      string
+		Tables   map[string][]string
+		Meta     map[string][]string
+		Queries  []string
+		Extracts []string
+		Expires  []string
+		File     string // dest_path file content
+	}
+	fn := func(info string) (output, error) {
+		dir := t.TempDir()
+		os.WriteFile(dir+"/data.json", []byte(`{"id":12,"name":"c"}`+"\n"+`{"id":13,"name":"d"}`+"\n"), 0644)
+		os.WriteFile(dir+"/query.sql", []byte("select id, name from {src_table} where id > @id"), 0644)
+
+		c := newFakeClient(map[string][]string{
+			"p.d.t": {`{"id":10,"name":"a"}`, `{"id":11,"name":"b"}`},
+			"p.d.s": {`{"id":1}`},
+		})
+		c.files["gs://bucket/data.json"] = []string{`{"id":20}`}
+		c.schema = bigquery.Schema{{Name: "id"}, {Name: "name"}}
+		c.rows = [][]bigquery.Value{{int64(1), "x"}, {int64(2), "y,z"}}
+		c.bytes = 2048
+		if strings.Contains(info, "fail") {
+			c.err = errors.New("job failed")
+		}
+		c.noStats = strings.Contains(info, "nostats")
+
+		w := (&options{}).NewWorker(strings.ReplaceAll(info, "{dir}", dir))
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		w.(*worker).client = c
+		result, msg := w.DoTask(context.Background())
+		if c.closed {
+			t.Error("the worker closed a client it did not create")
+		}
+		if result != task.CompleteResult {
+			return output{}, errors.New(msg)
+		}
+		out := output{Msg: strings.ReplaceAll(msg, dir, "{dir}"), Tables: c.tables, Extracts: c.extracts, Meta: w.(*worker).GetMeta()}
+		for _, q := range c.queries {
+			s := q.SQL
+			if q.Dst != nil {
+				s += " -> " + q.Dst.String()
+			}
+			for _, p := range q.Params {
+				s += fmt.Sprintf(" @%s=%v", p.Name, p.Value)
+			}
+			out.Queries = append(out.Queries, s)
+		}
+		for tbl := range c.expires {
+			out.Expires = append(out.Expires, tbl)
+			delete(out.Meta, "expires")
+		}
+		if b, err := os.ReadFile(dir + "/out.csv"); err == nil {
+			out.File = string(b)
+		}
+		return out, nil
+	}
+	rows := []string{`{"id":10,"name":"a"}`, `{"id":11,"name":"b"}`}
+	loaded := []string{`{"id":12,"name":"c"}`, `{"id":13,"name":"d"}`}
+	src := []string{`{"id":1}`}
+	cases := trial.Cases[string, output]{
+		"load append": {
+			Input: "{dir}/data.json?dest_table=p.d.t",
+			Expected: output{
+				Msg:    "2 rows (40 B) loaded",
+				Tables: map[string][]string{"p.d.t": append(rows, loaded...), "p.d.s": src},
+				Meta:   map[string][]string{"rows_insert": {"2"}},
+			},
+		},
+		"load truncate": {
+			Input: "{dir}/data.json?dest_table=p.d.t&truncate",
+			Expected: output{
+				Msg:    "2 rows (40 B) loaded",
+				Tables: map[string][]string{"p.d.t": loaded, "p.d.s": src},
+				Meta:   map[string][]string{"rows_insert": {"2"}},
+			},
+		},
+		"load delete": {
+			Input: "{dir}/data.json?dest_table=p.d.t&delete=id:10",
+			Expected: output{
+				Msg:     "2 rows (40 B) loaded",
+				Tables:  map[string][]string{"p.d.t": append(rows[1:], loaded...), "p.d.s": src},
+				Meta:    map[string][]string{"rows_del": {"1"}, "rows_insert": {"2"}},
+				Queries: []string{"delete from `p.d.t` where id = 10"},
+			},
+		},
+		"load partition": {
+			Input: "{dir}/data.json?dest_table=p.d.t&partition=2020-01-02&truncate",
+			Expected: output{
+				Msg:    "2 rows (40 B) loaded",
+				Tables: map[string][]string{"p.d.t": rows, "p.d.t$20200102": loaded, "p.d.s": src},
+				Meta:   map[string][]string{"rows_insert": {"2"}},
+			},
+		},
+		"direct load": {
+			Input: "gs://bucket/data.json?dest_table=p.d.s&direct_load",
+			Expected: output{
+				Msg:    "1 rows (9 B) loaded",
+				Tables: map[string][]string{"p.d.t": rows, "p.d.s": append(src, `{"id":20}`)},
+				Meta:   map[string][]string{"rows_insert": {"1"}},
+			},
+		},
+		"load error": {
+			Input:     "{dir}/data.json?dest_table=p.d.fail",
+			ShouldErr: true,
+		},
+		"query to table": {
+			Input: "{dir}/query.sql?dest_table=p.d.s&src_table=p.d.t&params=id:5",
+			Expected: output{
+				Msg:     "BQ byte processed: 2.0 kB ",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": append(src, `{"id":1,"name":"x"}`, `{"id":2,"name":"y,z"}`)},
+				Meta:    map[string][]string{"bq_bytes_billed": {"2048"}, "bq_query_time": {"0s"}},
+				Queries: []string{"select id, name from p.d.t where id > @id -> p.d.s @id=5"},
+			},
+		},
+		"query to file": {
+			Input: "{dir}/query.sql?dest_path={dir}/out.csv&src_table=p.d.t",
+			Expected: output{
+				Msg:     "BQ byte processed: 2.0 kB 3 lines writen to {dir}/out.csv",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": src},
+				Meta:    map[string][]string{"bq_bytes_billed": {"2048"}, "bq_query_time": {"0s"}},
+				Queries: []string{"select id, name from p.d.t where id > @id"},
+				File:    "id,name\n1,x\n2,\"y,z\"\n",
+			},
+		},
+		"query export to gcs": {
+			Input: "{dir}/query.sql?dest_path=gs://bucket/out-*.csv",
+			Expected: output{
+				Msg:     "BQ byte processed: 2.0 kB ",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": src},
+				Meta:    map[string][]string{"bq_bytes_billed": {"2048"}, "bq_query_time": {"0s"}},
+				Queries: []string{"EXPORT DATA OPTIONS(\noverwrite=true,\nformat=CSV,\nuri='gs://bucket/out-*.csv') AS \nselect id, name from {src_table} where id > @id"},
+			},
+		},
+		"query without destination": {
+			Input: "{dir}/query.sql?src_table=p.d.t",
+			Expected: output{
+				Msg:     "BQ byte processed: 2.0 kB ",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": src},
+				Meta:    map[string][]string{"bq_bytes_billed": {"2048"}, "bq_query_time": {"0s"}, "warn": {"query ran with no destination"}},
+				Queries: []string{"select id, name from p.d.t where id > @id"},
+			},
+		},
+		"dry run": {
+			Input: "{dir}/query.sql?dest_table=p.d.s&src_table=p.d.t&dry_run",
+			Expected: output{
+				Msg:     "dry run: 2.0 kB would be processed",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": src},
+				Meta:    map[string][]string{"bq_bytes_processed": {"2048"}},
+				Queries: []string{"select id, name from p.d.t where id > @id -> p.d.s"},
+			},
+		},
+		"dry run no statistics": {
+			Input:       "{dir}/query.sql?dest_table=p.d.nostats&dry_run",
+			ExpectedErr: errors.New("dry run: no statistics returned"),
+		},
+		"query error": {
+			Input:     "{dir}/query.sql?dest_table=p.d.fail",
+			ShouldErr: true,
+		},
+		"extract": {
+			Input: "p.d.t?job=extract&dest_path=gs://bucket/t-*.json.gz",
+			Expected: output{
+				Msg:      "p.d.t extracted to 1 files at gs://bucket/t-*.json.gz",
+				Tables:   map[string][]string{"p.d.t": rows, "p.d.s": src},
+				Meta:     map[string][]string{"file": {"gs://bucket/t-*.json.gz"}, "files": {"1"}},
+				Extracts: []string{"gs://bucket/t-*.json.gz NEWLINE_DELIMITED_JSON GZIP"},
+			},
+		},
+		"extract missing table": {
+			Input:     "p.d.missing?job=extract&dest_path=gs://bucket/t-*.csv",
+			ShouldErr: true,
+		},
+		"copy": {
+			Input: "p.d.t?job=copy&dest_table=p.d.s",
+			Expected: output{
+				Msg:    "p.d.t copied to p.d.s",
+				Tables: map[string][]string{"p.d.t": rows, "p.d.s": append(src, rows...)},
+				Meta:   map[string][]string{},
+			},
+		},
+		"copy truncate": {
+			Input: "p.d.t?job=copy&dest_table=p.d.s&truncate",
+			Expected: output{
+				Msg:    "p.d.t copied to p.d.s",
+				Tables: map[string][]string{"p.d.t": rows, "p.d.s": rows},
+				Meta:   map[string][]string{},
+			},
+		},
+		"snapshot": {
+			Input: "p.d.t?job=snapshot&dest_table=p.d.t_snap&expiration=24h",
+			Expected: output{
+				Msg:     "snapshot of p.d.t created at p.d.t_snap",
+				Tables:  map[string][]string{"p.d.t": rows, "p.d.s": src, "p.d.t_snap": rows},
+				Meta:    map[string][]string{},
+				Expires: []string{"p.d.t_snap"},
+			},
+		},
+		"snapshot exists": {
+			Input:     "p.d.t?job=snapshot&dest_table=p.d.s",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
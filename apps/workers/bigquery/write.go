@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,17 +17,12 @@ import (
 	"github.com/pcelvng/task-tools/file/stat"
 )
 
-func writeToFile(ctx context.Context, j *bigquery.Job, w file.Writer, format string) (sts stat.Stats, err error) {
-	rows, err := j.Read(ctx)
-	if err != nil {
-		return sts, fmt.Errorf("row: %w", err)
-	}
-
+func writeToFile(rows rowIterator, w file.Writer, format string) (sts stat.Stats, err error) {
 	loader := &bqValueLoader{}
 
 	// Set header for CSV format
 	if format == "csv" {
-		schema := rows.Schema
+		schema := rows.Schema()
 		header := make([]string, len(schema))
 		for i, field := range schema {
 			header[i] = field.Name
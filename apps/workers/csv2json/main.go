@@ -20,8 +20,34 @@ func (o options) Validate() error {
 
 const desc = `csv2json convert a csv with a header to json 
 
+info params
+ - output: (required) - file path to write the json file
+ - omit_null: don't write empty values
+ - schema: path to a toml file that defines the type of each column. Without a schema
+	values are written as numbers when possible, otherwise as strings. 
+ - strict: rows that don't match the schema fail the task or are written to rejects
+ - rejects: file path to write rejected rows (with the line number and error) instead of failing.
+	The path and count are set in the task meta as rejects and rejects_count
+
+schema file
+headerless = false  # true if the csv has no header, columns are read in the order listed
+
+[[column]]
+name = "zip"         # csv header name
+type = "string"      # string, int, float, bool or timestamp (default string)
+rename = "zip_code"  # json field name (default name)
+default = "00000"    # value used when the field is empty, missing or invalid (non-strict)
+
+[[column]]
+name = "created"
+type = "timestamp"
+layout = "01/02/2006 15:04"  # go time layout (default RFC3339)
+
+Columns not in the schema are written using the default number conversion.
+
 Example: 
 {"type":"csv2json","info":"gs://path/to/file.csv?output=gs://write/to/path/file.json&omitnull=false"}
+{"type":"csv2json","info":"gs://path/to/file.csv?output=gs://path/file.json&schema=gs://path/schema.toml&strict&rejects=gs://path/rejects.json"}
 `
 
 func main() {
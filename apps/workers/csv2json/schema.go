@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hydronica/toml"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+// Schema describes the columns of a csv file and how each
+// value is converted to json.
+//
+//	headerless = false  # the first line is data, columns are read by position
+//
+//	[[column]]
+//	name = "zip"
+//	type = "string"      # string, int, float, bool or timestamp (default string)
+//	rename = "zip_code"  # json field name (default name)
+//	default = "00000"    # value used when the field is empty
+//
+//	[[column]]
+//	name = "created"
+//	type = "timestamp"
+//	layout = "01/02/2006 15:04"  # go time layout (default RFC3339)
+type Schema struct {
+	Headerless bool     `toml:"headerless"`
+	Columns    []Column `toml:"column"`
+}
+
+// Column defines the type and json field of a csv column
+type Column struct {
+	Name    string `toml:"name"`
+	Type    string `toml:"type"`
+	Layout  string `toml:"layout"`
+	Rename  string `toml:"rename"`
+	Default string `toml:"default"`
+
+	def any // converted default value
+}
+
+// column types
+const (
+	typeString    = "string"
+	typeInt       = "int"
+	typeFloat     = "float"
+	typeBool      = "bool"
+	typeTimestamp = "timestamp"
+)
+
+// LoadSchema reads and validates a toml schema file
+func LoadSchema(path string, opts *file.Options) (*Schema, error) {
+	r, err := file.NewReader(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("schema reader %s %w", path, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("schema read %s %w", path, err)
+	}
+	s := &Schema{}
+	if _, err := toml.Decode(string(b), s); err != nil {
+		return nil, fmt.Errorf("schema decode %s %w", path, err)
+	}
+	if len(s.Columns) == 0 {
+		return nil, fmt.Errorf("no columns found in %s", path)
+	}
+	for i := range s.Columns {
+		if err := s.Columns[i].validate(); err != nil {
+			return nil, fmt.Errorf("column %d: %w", i+1, err)
+		}
+	}
+	return s, nil
+}
+
+// validate checks the column definition and sets defaults
+func (c *Column) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if c.Type == "" {
+		c.Type = typeString
+	}
+	if c.Rename == "" {
+		c.Rename = c.Name
+	}
+	switch c.Type {
+	case typeString, typeInt, typeFloat, typeBool:
+	case typeTimestamp:
+		if c.Layout == "" {
+			c.Layout = time.RFC3339
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %q", c.Name, c.Type)
+	}
+	if c.Default != "" {
+		v, err := c.Convert(c.Default)
+		if err != nil {
+			return fmt.Errorf("%s: default %w", c.Name, err)
+		}
+		c.def = v
+	}
+	return nil
+}
+
+// Convert parses the csv value into the column type
+func (c *Column) Convert(s string) (any, error) {
+	switch c.Type {
+	case typeInt:
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case typeFloat:
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case typeBool:
+		return strconv.ParseBool(strings.TrimSpace(s))
+	case typeTimestamp:
+		return time.Parse(c.Layout, s)
+	}
+	return s, nil
+}
+
+// mapping is the schema column for each position of a csv row
+// (nil for columns not defined in the schema) and the schema
+// columns that are not in the csv.
+type mapping struct {
+	cols    []*Column
+	missing []*Column
+}
+
+// Map matches the schema columns to the csv header. Without a header
+// the csv columns are in the order of the schema. In strict mode
+// every schema column must exist in the header.
+func (s *Schema) Map(header []string, strict bool) (mapping, error) {
+	var m mapping
+	if s.Headerless {
+		for i := range s.Columns {
+			m.cols = append(m.cols, &s.Columns[i])
+		}
+		return m, nil
+	}
+	m.cols = make([]*Column, len(header))
+	for j := range s.Columns {
+		c := &s.Columns[j]
+		i := indexOf(header, c.Name)
+		if i == -1 {
+			if strict {
+				return m, fmt.Errorf("column %s not in header", c.Name)
+			}
+			m.missing = append(m.missing, c)
+			continue
+		}
+		m.cols[i] = c
+	}
+	return m, nil
+}
+
+func indexOf(s []string, v string) int {
+	for i, x := range s {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hydronica/trial"
+	"github.com/pcelvng/task"
+)
+
+func TestLoadSchema(t *testing.T) {
+	dir := t.TempDir()
+	fn := func(in string) (*Schema, error) {
+		f := filepath.Join(dir, "schema.toml")
+		if err := os.WriteFile(f, []byte(in), 0644); err != nil {
+			return nil, err
+		}
+		return LoadSchema(f, nil)
+	}
+	cases := trial.Cases[string, *Schema]{
+		"defaults": {
+			Input: "headerless=true\n[[column]]\nname=\"zip\"\n" +
+				"[[column]]\nname=\"ts\"\ntype=\"timestamp\"\nrename=\"time\"\n" +
+				"[[column]]\nname=\"qty\"\ntype=\"int\"\ndefault=\"0\"\n",
+			Expected: &Schema{Headerless: true, Columns: []Column{
+				{Name: "zip", Type: "string", Rename: "zip"},
+				{Name: "ts", Type: "timestamp", Layout: "2006-01-02T15:04:05Z07:00", Rename: "time"},
+				{Name: "qty", Type: "int", Rename: "qty", Default: "0", def: int64(0)},
+			}},
+		},
+		"no columns": {
+			Input:     "headerless=true",
+			ShouldErr: true,
+		},
+		"unknown type": {
+			Input:       "[[column]]\nname=\"a\"\ntype=\"decimal\"",
+			ExpectedErr: errors.New("unknown type"),
+		},
+		"bad default": {
+			Input:       "[[column]]\nname=\"a\"\ntype=\"int\"\ndefault=\"none\"",
+			ExpectedErr: errors.New("default"),
+		},
+		"missing name": {
+			Input:       "[[column]]\ntype=\"int\"",
+			ExpectedErr: errors.New("name required"),
+		},
+	}
+	trial.New(fn, cases).Comparer(trial.EqualOpt(trial.AllowAllUnexported)).SubTest(t)
+}
+
+func TestWorker_Schema(t *testing.T) {
+	schema := `
+[[column]]
+name = "zip"
+[[column]]
+name = "id"
+type = "int"
+rename = "user_id"
+[[column]]
+name = "active"
+type = "bool"
+default = "false"
+[[column]]
+name = "joined"
+type = "timestamp"
+layout = "01/02/2006"
+`
+	type input struct {
+		schema string
+		csv    string
+		params string
+	}
+	type output struct {
+		Lines   []string
+		Rejects []string
+	}
+	fn := func(in input) (output, error) {
+		dir := t.TempDir()
+		files := map[string]string{"schema.toml": in.schema, "data.csv": in.csv}
+		for name, s := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+				return output{}, err
+			}
+		}
+		info := dir + "/data.csv?output=" + dir + "/data.json&rejects=" + dir + "/rejects.json&schema=" + dir + "/schema.toml" + in.params
+		opts := &options{}
+		w := opts.NewWorker(info)
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+			return output{}, errors.New(s)
+		}
+		rejected := readLines(dir + "/rejects.json")
+		for i, s := range rejected {
+			rejected[i] = strings.Replace(s, dir, "{dir}", -1)
+		}
+		return output{Lines: readLines(dir + "/data.json"), Rejects: rejected}, nil
+	}
+	cases := trial.Cases[input, output]{
+		"typed columns": {
+			Input: input{
+				schema: schema,
+				csv:    "zip,id,active,joined,other\n01234,7,true,05/24/2023,1.5\n98000,8,,01/02/2006,x\n",
+			},
+			Expected: output{Lines: []string{
+				`{"active":true,"joined":"2023-05-24T00:00:00Z","other":1.5,"user_id":7,"zip":"01234"}`,
+				`{"active":false,"joined":"2006-01-02T00:00:00Z","other":"x","user_id":8,"zip":"98000"}`,
+			}},
+		},
+		"missing column uses default": {
+			Input: input{
+				schema: schema,
+				csv:    "zip,id\n01234,7\n",
+			},
+			Expected: output{Lines: []string{`{"active":false,"joined":null,"user_id":7,"zip":"01234"}`}},
+		},
+		"invalid value": {
+			Input: input{
+				schema: schema,
+				csv:    "zip,id,active,joined\n01234,seven,yes,05/24/2023\n",
+			},
+			Expected: output{Lines: []string{`{"active":false,"joined":"2023-05-24T00:00:00Z","user_id":null,"zip":"01234"}`}},
+		},
+		"headerless": {
+			Input: input{
+				schema: "headerless = true\n" + schema,
+				csv:    "01234,7,true,05/24/2023\n",
+			},
+			Expected: output{Lines: []string{`{"active":true,"joined":"2023-05-24T00:00:00Z","user_id":7,"zip":"01234"}`}},
+		},
+		"strict rejects": {
+			Input: input{
+				schema: schema,
+				csv:    "zip,id,active,joined\n01234,seven,true,05/24/2023\n01235,8,true,05/24/2023\n1,2\n",
+				params: "&strict",
+			},
+			Expected: output{
+				Lines: []string{`{"active":true,"joined":"2023-05-24T00:00:00Z","user_id":8,"zip":"01235"}`},
+				Rejects: []string{
+					`{"line":2,"source":"` + "{dir}" + `/data.csv","error":"id: invalid int \"seven\"","data":"01234,seven,true,05/24/2023"}`,
+					`{"line":4,"source":"` + "{dir}" + `/data.csv","error":"record on line 4: wrong number of fields","data":"1,2"}`,
+				},
+			},
+		},
+		"strict missing column": {
+			Input: input{
+				schema: schema,
+				csv:    "zip,id\n01234,7\n",
+				params: "&strict",
+			},
+			ExpectedErr: errors.New("column active not in header"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func readLines(pth string) []string {
+	b, _ := os.ReadFile(pth)
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,8 +14,11 @@ import (
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/rejects"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 func (o *options) NewWorker(info string) task.Worker {
@@ -27,15 +32,19 @@ func (o *options) NewWorker(info string) task.Worker {
 		return task.InvalidWorker("uri %s", err)
 	}
 
-	w.reader, err = file.NewReader(w.File, w.fOpts)
-	if err != nil {
-		return task.InvalidWorker("new reader %s", err)
+	if w.SchemaPath != "" {
+		if w.schema, err = LoadSchema(w.SchemaPath, w.fOpts); err != nil {
+			return task.InvalidWorker("%s", err)
+		}
 	}
+
 	tm := tmpl.PathTime(w.File)
 	w.Output = tmpl.Parse(w.Output, tm)
-	w.writer, err = file.NewWriter(w.Output, w.fOpts)
-	if err != nil {
-		return task.InvalidWorker("new writer %s", err)
+	if w.Rejects != "" {
+		w.Rejects = tmpl.Parse(w.Rejects, tm)
+		if w.rejects, err = rejects.New(w.Rejects, w.fOpts); err != nil {
+			return task.InvalidWorker("rejects writer %s", err)
+		}
 	}
 	return w
 }
@@ -47,62 +56,101 @@ type worker struct {
 	OmitNull bool   `uri:"omit_null"`
 	Sep      string `uri:"sep" default:","`
 
+	SchemaPath string `uri:"schema"`  // toml file describing the columns
+	Strict     bool   `uri:"strict"`  // rows that do not match the schema fail the task or are rejected
+	Rejects    string `uri:"rejects"` // file path to write rows that fail strict mode
+
+	schema    *Schema
 	reader    file.Reader
 	writer    file.Writer
+	rejects   *rejects.Writer
 	fOpts     *file.Options
 	fileTopic string
 }
 
+// open creates the reader and writer in spans of the task's trace
+func (w *worker) open(ctx context.Context) (err error) {
+	if w.reader == nil {
+		if w.reader, err = tracing.NewReader(ctx, w.File, w.fOpts); err != nil {
+			return fmt.Errorf("new reader %w", err)
+		}
+	}
+	if w.writer == nil {
+		if w.writer, err = tracing.NewWriter(ctx, w.Output, w.fOpts); err != nil {
+			w.reader.Close()
+			return fmt.Errorf("new writer %w", err)
+		}
+	}
+	return nil
+}
+
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
+	if err := w.open(ctx); err != nil {
+		w.rejects.Abort()
+		return task.Failed(err)
+	}
 	reader := csv.NewReader(w.reader)
 
 	// read header
-
-	headers, err := reader.Read()
-	fmt.Println(headers)
-	if err != nil {
-		return task.Failed(err)
+	var headers []string
+	var err error
+	if w.schema == nil || !w.schema.Headerless {
+		headers, err = reader.Read()
+		if err != nil {
+			return w.fail(err)
+		}
 	}
-	var row []string
-	for row, err = reader.Read(); err == nil; row, err = reader.Read() {
+	var m mapping
+	if w.schema != nil {
+		if m, err = w.schema.Map(headers, w.Strict); err != nil {
+			return w.fail(err)
+		}
+	}
+
+	var invalid int
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if task.IsDone(ctx) {
+			w.abort()
 			return task.Failf("context canceled")
 		}
-		data := map[string]interface{}{}
-		for i, v := range row {
-			h := headers[i]
-			if v == "" {
-				if w.OmitNull {
-					continue
-				}
-				data[h] = nil
-				continue
-			}
-			if i, err := strconv.Atoi(v); err == nil {
-				data[h] = i
-				continue
+		// rows with the wrong number of fields can be rejected
+		if err != nil && !(errors.Is(err, csv.ErrFieldCount) && w.rejects != nil) {
+			w.abort()
+			return task.Failf("scanner %s", err)
+		}
+		var data map[string]interface{}
+		if err == nil {
+			var n int
+			data, n, err = w.convert(headers, m, row)
+			invalid += n
+		}
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			if w.rejects == nil {
+				return w.fail(fmt.Errorf("line %d: %w", line, err))
 			}
-			if f, err := strconv.ParseFloat(v, 64); err == nil {
-				data[h] = f
-				continue
+			if err := w.rejects.Write(int64(line), w.File, csvLine(row, reader.Comma), err); err != nil {
+				return w.fail(err)
 			}
-			data[h] = v
+			continue
 		}
+
 		b, err := json.Marshal(data)
 		if err != nil {
-			w.writer.Abort()
-			return task.Failed(err)
+			return w.fail(err)
 		}
 		if err := w.writer.WriteLine(b); err != nil {
-			w.writer.Abort()
-			return task.Failed(err)
+			return w.fail(err)
 		}
 	}
-	if err != io.EOF {
-		w.writer.Abort()
-		return task.Failf("scanner %s", err)
-	}
 	if err := w.writer.Close(); err != nil {
+		return w.fail(err)
+	}
+	if err := w.rejects.Close(); err != nil {
 		return task.Failed(err)
 	}
 
@@ -113,5 +161,96 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 		}
 	}
 	w.SetMeta("file", sts.Path)
-	return task.Completed("%d bytes writen to %s", sts.ByteCnt, sts.Path)
+	w.SetMeta(bootstrap.MetaRead, w.reader.Stats().JSONString())
+	w.SetMeta(bootstrap.MetaWrite, sts.JSONString())
+	w.rejects.SetMeta(w)
+	msg := fmt.Sprintf("%d bytes writen to %s", sts.ByteCnt, sts.Path)
+	if invalid > 0 {
+		msg += fmt.Sprintf(", %d invalid values", invalid)
+	}
+	if n := w.rejects.Count(); n > 0 {
+		msg += fmt.Sprintf(", %d rows rejected to %s", n, w.rejects.Path())
+	}
+	return task.Completed("%s", msg)
+}
+
+// convert creates the json record for a csv row. Columns in the schema
+// are converted to their type, other columns are converted to a number
+// when possible. In strict mode a value that cannot be converted returns
+// an error, otherwise the column default (or null) is used and counted
+// as invalid.
+func (w *worker) convert(headers []string, m mapping, row []string) (data map[string]interface{}, invalid int, err error) {
+	data = make(map[string]interface{}, len(row))
+	for i, v := range row {
+		var c *Column
+		if i < len(m.cols) {
+			c = m.cols[i]
+		}
+		if c == nil {
+			if i < len(headers) {
+				w.set(data, headers[i], guessType(v))
+			}
+			continue
+		}
+		if v == "" {
+			w.set(data, c.Rename, c.def)
+			continue
+		}
+		value, err := c.Convert(v)
+		if err != nil {
+			if w.Strict {
+				return nil, 0, fmt.Errorf("%s: invalid %s %q", c.Name, c.Type, v)
+			}
+			invalid++
+			value = c.def
+		}
+		w.set(data, c.Rename, value)
+	}
+	for _, c := range m.missing {
+		w.set(data, c.Rename, c.def)
+	}
+	return data, invalid, nil
+}
+
+// set adds the value to the record, nil values are skipped with omit_null
+func (w *worker) set(data map[string]interface{}, key string, v interface{}) {
+	if v == nil && w.OmitNull {
+		return
+	}
+	data[key] = v
+}
+
+// guessType converts the value to an int or float if possible
+func guessType(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(v); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// csvLine encodes the row as it was read for the rejects file
+func csvLine(row []string, comma rune) []byte {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Comma = comma
+	cw.Write(row)
+	cw.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// abort stops the output and rejects writers
+func (w *worker) abort() {
+	w.writer.Abort()
+	w.rejects.Abort()
+}
+
+func (w *worker) fail(err error) (task.Result, string) {
+	w.abort()
+	return task.Failed(err)
 }
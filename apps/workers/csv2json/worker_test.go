@@ -52,14 +52,6 @@ func TestOptions_NewWorker(t *testing.T) {
 			Input:       "nop://file.txt",
 			ExpectedErr: errors.New("output is required"),
 		},
-		"invalid input": {
-			Input:       "nop://init_err?output=nop://file.txt",
-			ExpectedErr: errors.New("new reader"),
-		},
-		"invalid output": {
-			Input:       "nop://file.txt?output=nop://init_err",
-			ExpectedErr: errors.New("new writer"),
-		},
 	}
 	cmpfn := func(act, exp interface{}) (bool, string) {
 		v := act.(*worker)
@@ -168,3 +160,34 @@ func TestWorker_DoTask(t *testing.T) {
 	trial.New(fn, cases).SubTest(t)
 }
 */
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hydronica/trial"
+	"github.com/pcelvng/task"
+)
+
+func TestWorker_open(t *testing.T) {
+	fn := func(in string) (string, error) {
+		w := (&options{}).NewWorker(in)
+		r, s := w.DoTask(context.Background())
+		if r == task.ErrResult {
+			return "", errors.New(s)
+		}
+		return s, nil
+	}
+	cases := trial.Cases[string, string]{
+		"invalid input": {
+			Input:       "nop://init_err?output=nop://file.txt",
+			ExpectedErr: errors.New("new reader"),
+		},
+		"invalid output": {
+			Input:       "nop://file.txt?output=nop://init_err",
+			ExpectedErr: errors.New("new writer"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -8,6 +8,7 @@ import (
 	tools "github.com/pcelvng/task-tools"
 	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/db"
+	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task/bus"
 )
 
@@ -17,33 +18,63 @@ const (
 Runs checks against a given table's data to verify that the data is updated as expected
 
 uri params:
-db_src     - database source
+db_src     - name of the database source from the db config (postgres, mysql, sqlite or clickhouse)
 table      - schema.table name to validate
 type       - type of check
              * missing - alerts if 0 records are found for selected date
              * null - alerts if null values are found in selected field for selected date
              * zero - alerts if zero sum values are found for selected fields for selected date/times
                     - will also alert if no records are found for selected date/times
+rules      - path to a toml rules file of data quality checks (instead of type)
+             critical violations fail the task, all violations are sent to slack
 field      - field name being checked
 date_field - date/timestamp field to query
 date_type  - type of date_field ("dt" = date -or- "ts" = timestamp)
 date       - date value to use in query
              * date (dt) format: yyyy-mm-dd
              * timestamp (ts) format: yyyy-mm-ddThh:00
+dest       - file path to write the check results (json lines) for trending over time
 group_ts   - timestamp field to group by for hour level checking for tables with both a date and a timestamp field
              (optional "zero" type field used for efficiency purposes; date_field should be date type)
 
 Example:
 {"type":"task.db-check","info":"?db_src=mydbsrc&table=myschema.mytable&type=missing|null|zero&field=myfield&date_field=mydatefield&date_type=dt|ts&date=2023-05-24"}
 
-!Note! "missing" type checks do not need the "field" param since the record count only relies on the "date_field" and date value`
+{"type":"task.db-check","info":"?db_src=mydbsrc&table=myschema.mytable&rules=gs://bucket/checks.toml&date_field=mydatefield&date=2023-05-24"}
+
+!Note! "missing" type checks do not need the "field" param since the record count only relies on the "date_field" and date value
+
+rules file:
+[[check]]
+name = "volume"        # optional display name
+type = "row_count"     # row_count, ratio, unique, allowed, freshness, sql
+severity = "critical"  # info, warn (default), critical
+table = "schema.other" # optional, defaults to the table param
+where = "id > 0"       # optional sql filter added to the date filter (trusted, not escaped)
+min = 1000             # row_count, ratio and sql checks pass when min <= value <= max
+max = 5000000
+
+ratio      - count for date divided by the count for date - offset (default "24h")
+unique     - no duplicate records for the set of 'fields'
+allowed    - all 'field' values are in 'values'
+freshness  - max('field') is no older than 'max_lag' (ie "2h")
+sql        - 'query' returns a single number, {table} is replaced and {date} is a bind parameter. Expects 0 without min/max
+             ie: "select count(0) from {table} where amount < 0 and date(ts) = {date}"
+
+config:
+[db.mydbsrc]
+type = "mysql"  # postgres (default), mysql, sqlite or clickhouse
+host = "localhost:3306"
+dbname = "mydb"`
 )
 
-type Postgres struct {
+// Source is a named database connection that checks are run against
+type Source struct {
+	Type        string `toml:"type" comment:"postgres (default), mysql, sqlite or clickhouse"`
 	Host        string `toml:"host"`
 	User        string `toml:"username"`
 	Pass        string `toml:"password"`
-	DBName      string `toml:"dbname"`
+	DBName      string `toml:"dbname" comment:"database name or file path for sqlite"`
 	SSLMode     string `toml:"sslmode" comment:"default is disable, use require for ssl"`
 	SSLCert     string `toml:"sslcert"`
 	SSLKey      string `toml:"sslkey"`
@@ -53,10 +84,12 @@ type Postgres struct {
 }
 
 type options struct {
-	Slack string `toml:"slack"`
+	Slack string        `toml:"slack"`
+	FOpts *file.Options `toml:"file"`
 
-	Bus  *bus.Options        `toml:"bus"`
-	Psql map[string]Postgres `toml:"postgres"` // multiple postgres db server/source connections name:settings
+	Bus     *bus.Options      `toml:"bus"`
+	Sources map[string]Source `toml:"db"`       // multiple db server/source connections name:settings
+	Psql    map[string]Source `toml:"postgres"` // deprecated: use db, sources of type postgres
 
 	producer bus.Producer
 }
@@ -65,7 +98,8 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	o := &options{
-		Psql: make(map[string]Postgres),
+		Sources: make(map[string]Source),
+		FOpts:   file.NewOptions(),
 	}
 
 	app := bootstrap.NewWorkerApp(taskType, o.newWorker, o).
@@ -80,9 +114,32 @@ func main() {
 
 // database connection validation
 func (o *options) Validate() (err error) {
-	// check each psql connection
+	if o.Sources == nil {
+		o.Sources = make(map[string]Source)
+	}
+	// legacy postgres sources share the same source names
 	for name, c := range o.Psql {
-		if c.SSLMode == "" || c.SSLMode == "disable" {
+		if _, found := o.Sources[name]; found {
+			return fmt.Errorf("duplicate db source %s", name)
+		}
+		if c.Type == "" {
+			c.Type = "postgres"
+		}
+		o.Sources[name] = c
+	}
+
+	// check each db connection
+	for name, c := range o.Sources {
+		if c.Type == "" {
+			c.Type = "postgres"
+		}
+		if c.Type != "postgres" {
+			dbOpts := db.Options{Username: c.User, Password: c.Pass, Host: c.Host, DBName: c.DBName}
+			c.DB, err = dbOpts.Openx(c.Type)
+			if err != nil {
+				return fmt.Errorf("could not connect to %s host:%s user:%s error:%s", c.Type, c.Host, c.User, err.Error())
+			}
+		} else if c.SSLMode == "" || c.SSLMode == "disable" {
 			c.DB, err = db.PGx(c.User, c.Pass, c.Host, c.DBName)
 			if err != nil {
 				return fmt.Errorf("could not connect to postgres host:%s user:%s error:%s", c.Host, c.User, err.Error())
@@ -93,7 +150,7 @@ func (o *options) Validate() (err error) {
 				return fmt.Errorf("could not connect to postgres (ssl) host:%s user:%s error:%s", c.Host, c.User, err.Error())
 			}
 		}
-		o.Psql[name] = c
+		o.Sources[name] = c
 	}
 	return nil
 }
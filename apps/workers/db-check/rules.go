@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hydronica/toml"
+	"github.com/jmoiron/sqlx"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+// rule severity levels, only critical violations fail the task
+const (
+	SevInfo     = "info"
+	SevWarn     = "warn"
+	SevCritical = "critical"
+)
+
+// Rules is a declarative set of data quality checks read from a toml file
+//
+//	[[check]]
+//	name = "daily volume"
+//	type = "row_count"
+//	severity = "critical"
+//	min = 1000
+type Rules struct {
+	Checks []Rule `toml:"check"`
+}
+
+// Rule is a single data quality check run against a table for the task date.
+type Rule struct {
+	Name     string        `toml:"name"`
+	Type     string        `toml:"type"`     // row_count, ratio, unique, allowed, freshness, sql
+	Severity string        `toml:"severity"` // info, warn (default), critical
+	Table    string        `toml:"table"`    // defaults to the task table
+	Where    string        `toml:"where"`    // additional sql filter combined with the date filter (not escaped)
+	Field    string        `toml:"field"`    // column for allowed and freshness checks
+	Fields   []string      `toml:"fields"`   // column set for unique checks
+	Values   []string      `toml:"values"`   // permitted values for allowed checks
+	Min      *Limit        `toml:"min"`      // lowest passing value (row_count, ratio, sql)
+	Max      *Limit        `toml:"max"`      // highest passing value (row_count, ratio, sql)
+	Offset   time.Duration `toml:"offset"`   // previous period for ratio checks (default 24h)
+	MaxLag   time.Duration `toml:"max_lag"`  // oldest allowed max(field) for freshness checks
+	Query    string        `toml:"query"`    // custom sql returning a single number, {table} is replaced and {date} is bound
+}
+
+// Limit is a rule threshold that may be written as a toml integer or float
+type Limit float64
+
+func (l *Limit) UnmarshalTOML(v interface{}) error {
+	switch x := v.(type) {
+	case int64:
+		*l = Limit(x)
+	case float64:
+		*l = Limit(x)
+	default:
+		return fmt.Errorf("invalid limit %v", v)
+	}
+	return nil
+}
+
+// Result is the outcome of a single rule
+type Result struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Severity string  `json:"severity"`
+	Table    string  `json:"table"`
+	Passed   bool    `json:"passed"`
+	Value    float64 `json:"value"`
+	Message  string  `json:"message,omitempty"`
+}
+
+// Report is the structured result of all rules run by a task
+type Report struct {
+	Source   string    `json:"db_src"`
+	Table    string    `json:"table"`
+	Date     string    `json:"date"`
+	Checked  time.Time `json:"checked"`
+	Passed   int       `json:"passed"`
+	Failed   int       `json:"failed"`
+	Critical int       `json:"critical"`
+	Results  []Result  `json:"results"`
+}
+
+// record is a single result line written to the results file
+type record struct {
+	Source  string    `json:"db_src"`
+	Date    string    `json:"date"`
+	Checked time.Time `json:"checked"`
+	Result
+}
+
+// newReport creates a report for a single result
+func (w *worker) newReport(res Result) Report {
+	rpt := Report{
+		Source:  w.DBSrc,
+		Table:   w.Table,
+		Date:    w.Date,
+		Checked: time.Now().UTC(),
+		Results: []Result{res},
+	}
+	if res.Passed {
+		rpt.Passed++
+	} else {
+		rpt.Failed++
+		if res.Severity == SevCritical {
+			rpt.Critical++
+		}
+	}
+	return rpt
+}
+
+// writeReport writes each result as a json line to the dest file (if provided)
+// so results can be loaded and trended over time.
+func (w *worker) writeReport(rpt Report) error {
+	if w.Dest == "" {
+		return nil
+	}
+	writer, err := file.NewWriter(w.Dest, w.FOpts)
+	if err != nil {
+		return err
+	}
+	for _, res := range rpt.Results {
+		b, err := json.Marshal(record{Source: rpt.Source, Date: rpt.Date, Checked: rpt.Checked, Result: res})
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+		if err := writer.WriteLine(b); err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	w.SetMeta("dest", w.Dest)
+	return nil
+}
+
+// LoadRules reads and validates the toml rules file at path
+func LoadRules(path string, opts *file.Options) (*Rules, error) {
+	r, err := file.NewReader(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("rules reader %s %w", path, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("rules read %s %w", path, err)
+	}
+	rules := &Rules{}
+	if _, err := toml.Decode(string(b), rules); err != nil {
+		return nil, fmt.Errorf("rules decode %s %w", path, err)
+	}
+	if len(rules.Checks) == 0 {
+		return nil, fmt.Errorf("no checks found in %s", path)
+	}
+	for i := range rules.Checks {
+		if err := rules.Checks[i].validate(); err != nil {
+			return nil, fmt.Errorf("check %d: %w", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// validate checks the required fields for the rule type and sets defaults
+func (r *Rule) validate() error {
+	switch r.Type {
+	case "row_count", "ratio":
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("%s requires min and/or max", r.Type)
+		}
+	case "unique":
+		if len(r.Fields) == 0 {
+			return fmt.Errorf("unique requires fields")
+		}
+	case "allowed":
+		if r.Field == "" || len(r.Values) == 0 {
+			return fmt.Errorf("allowed requires field and values")
+		}
+	case "freshness":
+		if r.Field == "" || r.MaxLag <= 0 {
+			return fmt.Errorf("freshness requires field and max_lag")
+		}
+	case "sql":
+		if r.Query == "" {
+			return fmt.Errorf("sql requires query")
+		}
+	default:
+		return fmt.Errorf("unknown check type %q", r.Type)
+	}
+
+	// identifiers cannot be bound as parameters
+	if r.Table != "" {
+		if err := validIdent(r.Table); err != nil {
+			return err
+		}
+	}
+	if r.Field != "" {
+		if err := validIdent(r.Field); err != nil {
+			return err
+		}
+	}
+	if err := validIdent(r.Fields...); err != nil {
+		return err
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SevWarn
+	case SevInfo, SevWarn, SevCritical:
+	default:
+		return fmt.Errorf("unknown severity %q", r.Severity)
+	}
+
+	if r.Type == "ratio" && r.Offset == 0 {
+		r.Offset = 24 * time.Hour
+	}
+	if r.Name == "" {
+		r.Name = strings.TrimSuffix(r.Type+" "+r.Field+strings.Join(r.Fields, ","), " ")
+	}
+	return nil
+}
+
+// CheckRules runs all the rules and reports the results.
+// Any failed critical rule will fail the task.
+func (w *worker) CheckRules(ctx context.Context) (task.Result, string) {
+	rpt := w.RunRules(ctx)
+
+	w.SetMeta("checks", strconv.Itoa(len(rpt.Results)))
+	w.SetMeta("failed", strconv.Itoa(rpt.Failed))
+	w.SetMeta("critical", strconv.Itoa(rpt.Critical))
+	if err := w.writeReport(rpt); err != nil {
+		return task.Failf("write results: %v", err)
+	}
+
+	if rpt.Failed > 0 {
+		m := w.slack.NewMessage(":radioactive_sign: *task-tools db-check - Data Quality Rules - " + w.Date + "*")
+		for _, r := range rpt.Results {
+			if r.Passed {
+				continue
+			}
+			m.AddElements(fmt.Sprintf("%s  *(%s) %s; %s* - %s", sevIcon(r.Severity), w.DBSrc, r.Table, r.Name, r.Message))
+			log.Printf("%s: (%s) %s; %s - %s\n", r.Severity, w.DBSrc, r.Table, r.Name, r.Message)
+		}
+		w.slack.SendMessage(m)
+	}
+
+	if rpt.Critical > 0 {
+		var names []string
+		for _, r := range rpt.Results {
+			if !r.Passed && r.Severity == SevCritical {
+				names = append(names, r.Name)
+			}
+		}
+		return task.Failf("table %s rules check for date %s, critical violations: %s", w.Table, w.Date, strings.Join(names, ", "))
+	}
+	return task.Completed("table %s rules check completed for date %s, checks: %d, failed: %d", w.Table, w.Date, len(rpt.Results), rpt.Failed)
+}
+
+// RunRules runs each rule against the db source and returns the report.
+func (w *worker) RunRules(ctx context.Context) Report {
+	rpt := Report{
+		Source:  w.DBSrc,
+		Table:   w.Table,
+		Date:    w.Date,
+		Checked: time.Now().UTC(),
+	}
+	for _, r := range w.rules.Checks {
+		res := w.runRule(ctx, r)
+		if res.Passed {
+			rpt.Passed++
+		} else {
+			rpt.Failed++
+			if res.Severity == SevCritical {
+				rpt.Critical++
+			}
+		}
+		rpt.Results = append(rpt.Results, res)
+	}
+	return rpt
+}
+
+// runRule runs a single rule, any query error is reported as a failed result.
+func (w *worker) runRule(ctx context.Context, r Rule) (res Result) {
+	res = Result{Name: r.Name, Type: r.Type, Severity: r.Severity, Table: r.Table}
+	if res.Table == "" {
+		res.Table = w.Table
+	}
+
+	src, found := w.Sources[w.DBSrc]
+	if !found {
+		res.Message = fmt.Sprintf("db source %s not found", w.DBSrc)
+		return res
+	}
+	date, err := w.parseDate()
+	if err != nil {
+		res.Message = err.Error()
+		return res
+	}
+	filter, args := w.dateFilter(src.Type, date)
+	if r.Where != "" {
+		filter += " and (" + r.Where + ")"
+	}
+
+	switch r.Type {
+	case "row_count":
+		res.Value, err = queryValue(ctx, src.DB, fmt.Sprintf("select count(0) from %s where %s", res.Table, filter), args...)
+		if err == nil {
+			res.Passed, res.Message = inRange(res.Value, r.Min, r.Max)
+		}
+	case "ratio":
+		var prev float64
+		res.Value, err = queryValue(ctx, src.DB, fmt.Sprintf("select count(0) from %s where %s", res.Table, filter), args...)
+		if err != nil {
+			break
+		}
+		prevFilter, prevArgs := w.dateFilter(src.Type, date.Add(-r.Offset))
+		if r.Where != "" {
+			prevFilter += " and (" + r.Where + ")"
+		}
+		prev, err = queryValue(ctx, src.DB, fmt.Sprintf("select count(0) from %s where %s", res.Table, prevFilter), prevArgs...)
+		if err != nil {
+			break
+		}
+		if prev == 0 {
+			res.Message = fmt.Sprintf("no records in previous period (%d current)", int64(res.Value))
+			break
+		}
+		res.Value = res.Value / prev
+		res.Passed, res.Message = inRange(res.Value, r.Min, r.Max)
+	case "unique":
+		cols := strings.Join(r.Fields, ", ")
+		res.Value, err = queryValue(ctx, src.DB, fmt.Sprintf(
+			"select count(0) from (select %s from %s where %s group by %s having count(0) > 1) dups",
+			cols, res.Table, filter, cols), args...)
+		if err == nil {
+			res.Passed = res.Value == 0
+			if !res.Passed {
+				res.Message = fmt.Sprintf("%d duplicate keys", int64(res.Value))
+			}
+		}
+	case "allowed":
+		params := strings.TrimSuffix(strings.Repeat("?,", len(r.Values)), ",")
+		for _, v := range r.Values {
+			args = append(args, v)
+		}
+		res.Value, err = queryValue(ctx, src.DB, fmt.Sprintf(
+			"select count(0) from %s where %s and %s not in (%s)",
+			res.Table, filter, r.Field, params), args...)
+		if err == nil {
+			res.Passed = res.Value == 0
+			if !res.Passed {
+				res.Message = fmt.Sprintf("%d records with values not allowed", int64(res.Value))
+			}
+		}
+	case "freshness":
+		var last time.Time
+		last, err = queryTime(ctx, src.DB, fmt.Sprintf("select max(%s) from %s", r.Field, res.Table))
+		if err != nil {
+			break
+		}
+		lag := time.Since(last)
+		res.Value = lag.Seconds()
+		res.Passed = lag <= r.MaxLag
+		if !res.Passed {
+			res.Message = fmt.Sprintf("last record %s is %s old (max %s)", last.Format(time.RFC3339), lag.Truncate(time.Second), r.MaxLag)
+		}
+	case "sql":
+		// {date} is bound as a parameter for each occurrence
+		q := strings.ReplaceAll(r.Query, "{table}", res.Table)
+		args = nil
+		for i := strings.Count(q, "{date}"); i > 0; i-- {
+			args = append(args, w.Date)
+		}
+		q = strings.ReplaceAll(q, "{date}", "?")
+		res.Value, err = queryValue(ctx, src.DB, q, args...)
+		if err != nil {
+			break
+		}
+		if r.Min == nil && r.Max == nil {
+			// assertions without a range expect no violating records
+			zero := Limit(0)
+			res.Passed, res.Message = inRange(res.Value, &zero, &zero)
+		} else {
+			res.Passed, res.Message = inRange(res.Value, r.Min, r.Max)
+		}
+	}
+	if err != nil {
+		res.Passed = false
+		res.Message = err.Error()
+	}
+	return res
+}
+
+// parseDate returns the task date as a time
+func (w *worker) parseDate() (time.Time, error) {
+	if w.DateType == "ts" {
+		return time.Parse("2006-01-02T15:00", w.Date)
+	}
+	return time.Parse("2006-01-02", w.Date)
+}
+
+// dateFilter returns the where clause and its bind values selecting records for date.
+// Timestamps select the full hour, otherwise the full day is selected.
+func (w *worker) dateFilter(dbType string, date time.Time) (string, []any) {
+	if w.DateType == "ts" {
+		return fmt.Sprintf("%s >= ? and %s < ?", w.DateField, w.DateField),
+			[]any{date.Format("2006-01-02 15:04:05"), date.Add(time.Hour).Format("2006-01-02 15:04:05")}
+	}
+	return fmt.Sprintf("%s(%s) = ?", dateFunc(dbType), w.DateField), []any{date.Format("2006-01-02")}
+}
+
+// inRange checks min <= v <= max where a nil limit is unbounded
+func inRange(v float64, min, max *Limit) (bool, string) {
+	if min != nil && v < float64(*min) {
+		return false, fmt.Sprintf("%v is below min %v", v, *min)
+	}
+	if max != nil && v > float64(*max) {
+		return false, fmt.Sprintf("%v is above max %v", v, *max)
+	}
+	return true, ""
+}
+
+// queryValue runs a query that returns a single numeric value
+func queryValue(ctx context.Context, conn *sqlx.DB, q string, args ...any) (float64, error) {
+	var v sql.NullFloat64
+	if err := conn.QueryRowxContext(ctx, conn.Rebind(q), args...).Scan(&v); err != nil {
+		return 0, fmt.Errorf("query %w", err)
+	}
+	return v.Float64, nil
+}
+
+// timeLayouts are the string formats a driver may return for a timestamp
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// queryTime runs a query that returns a single timestamp value
+func queryTime(ctx context.Context, conn *sqlx.DB, q string) (time.Time, error) {
+	var v any
+	if err := conn.QueryRowxContext(ctx, q).Scan(&v); err != nil {
+		return time.Time{}, fmt.Errorf("query %w", err)
+	}
+	var s string
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case nil:
+		return time.Time{}, fmt.Errorf("no records found")
+	case []byte:
+		s = string(x)
+	case string:
+		s = x
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time value %T", v)
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
+
+func sevIcon(sev string) string {
+	switch sev {
+	case SevCritical:
+		return ":octagonal_sign:"
+	case SevInfo:
+		return ":information_source:"
+	}
+	return ":warning:"
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hydronica/trial"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/pcelvng/task-tools/db"
+)
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	fn := func(in string) (*Rules, error) {
+		f := filepath.Join(dir, "rules.toml")
+		if err := os.WriteFile(f, []byte(in), 0644); err != nil {
+			return nil, err
+		}
+		return LoadRules(f, nil)
+	}
+	min, max := Limit(1), Limit(10.0)
+	cases := trial.Cases[string, *Rules]{
+		"defaults": {
+			Input: "[[check]]\ntype=\"row_count\"\nmin=1\nmax=10.0\n" +
+				"[[check]]\ntype=\"ratio\"\nseverity=\"critical\"\nmin=1\n" +
+				"[[check]]\ntype=\"freshness\"\nfield=\"ts\"\nmax_lag=\"2h\"\n",
+			Expected: &Rules{Checks: []Rule{
+				{Name: "row_count", Type: "row_count", Severity: SevWarn, Min: &min, Max: &max},
+				{Name: "ratio", Type: "ratio", Severity: SevCritical, Min: &min, Offset: 24 * time.Hour},
+				{Name: "freshness ts", Type: "freshness", Severity: SevWarn, Field: "ts", MaxLag: 2 * time.Hour},
+			}},
+		},
+		"no checks": {
+			Input:     "",
+			ShouldErr: true,
+		},
+		"unknown type": {
+			Input:     "[[check]]\ntype=\"bad\"",
+			ShouldErr: true,
+		},
+		"unknown severity": {
+			Input:     "[[check]]\ntype=\"unique\"\nfields=[\"id\"]\nseverity=\"high\"",
+			ShouldErr: true,
+		},
+		"row_count without range": {
+			Input:     "[[check]]\ntype=\"row_count\"",
+			ShouldErr: true,
+		},
+		"allowed without values": {
+			Input:     "[[check]]\ntype=\"allowed\"\nfield=\"status\"",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestRunRules(t *testing.T) {
+	sqlDB, err := db.SQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	recent := time.Now().UTC().Add(-time.Hour).Format("2006-01-02 15:04:05")
+	_, err = sqlDB.Exec(`create table events (id integer, status text, amount real, ts timestamp);
+insert into events values
+  (1, 'a', 1.0, '2023-05-23 01:00:00'),
+  (2, 'a', 1.0, '2023-05-23 02:00:00'),
+  (1, 'a', 1.0, '2023-05-24 01:00:00'),
+  (2, 'b', -1.0, '2023-05-24 01:00:00'),
+  (2, 'c', 2.0, '2023-05-24 13:00:00'),
+  (4, 'a', 2.0, '` + recent + `');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := func(r Rule) (Result, error) {
+		if err := r.validate(); err != nil {
+			return Result{}, err
+		}
+		w := &worker{
+			options: options{Sources: map[string]Source{"test": {
+				Type: "sqlite",
+				DB:   sqlx.NewDb(sqlDB, "sqlite"),
+			}}},
+			rules:     &Rules{Checks: []Rule{r}},
+			DBSrc:     "test",
+			Table:     "events",
+			DateField: "ts",
+			Date:      "2023-05-24",
+		}
+		rpt := w.RunRules(context.Background())
+		res := rpt.Results[0]
+		if r.Type == "freshness" {
+			res.Value = 0 // lag depends on the current time
+		}
+		return res, nil
+	}
+	f := func(v Limit) *Limit { return &v }
+	cases := trial.Cases[Rule, Result]{
+		"row_count pass": {
+			Input:    Rule{Type: "row_count", Min: f(1), Max: f(3)},
+			Expected: Result{Name: "row_count", Type: "row_count", Severity: SevWarn, Table: "events", Passed: true, Value: 3},
+		},
+		"row_count below min": {
+			Input: Rule{Type: "row_count", Severity: SevCritical, Min: f(10)},
+			Expected: Result{Name: "row_count", Type: "row_count", Severity: SevCritical, Table: "events", Value: 3,
+				Message: "3 is below min 10"},
+		},
+		"row_count where": {
+			Input:    Rule{Type: "row_count", Where: "status = 'a'", Max: f(1)},
+			Expected: Result{Name: "row_count", Type: "row_count", Severity: SevWarn, Table: "events", Passed: true, Value: 1},
+		},
+		"ratio above max": {
+			Input: Rule{Type: "ratio", Max: f(1.2)},
+			Expected: Result{Name: "ratio", Type: "ratio", Severity: SevWarn, Table: "events", Value: 1.5,
+				Message: "1.5 is above max 1.2"},
+		},
+		"unique": {
+			Input: Rule{Type: "unique", Fields: []string{"id"}},
+			Expected: Result{Name: "unique id", Type: "unique", Severity: SevWarn, Table: "events", Value: 1,
+				Message: "1 duplicate keys"},
+		},
+		"unique set": {
+			Input:    Rule{Type: "unique", Fields: []string{"id", "status"}},
+			Expected: Result{Name: "unique id,status", Type: "unique", Severity: SevWarn, Table: "events", Passed: true},
+		},
+		"allowed": {
+			Input: Rule{Type: "allowed", Field: "status", Values: []string{"a", "b"}},
+			Expected: Result{Name: "allowed status", Type: "allowed", Severity: SevWarn, Table: "events", Value: 1,
+				Message: "1 records with values not allowed"},
+		},
+		"freshness": {
+			Input:    Rule{Type: "freshness", Field: "ts", MaxLag: 2 * time.Hour},
+			Expected: Result{Name: "freshness ts", Type: "freshness", Severity: SevWarn, Table: "events", Passed: true},
+		},
+		"sql assertion": {
+			Input: Rule{Name: "negative", Type: "sql", Query: "select count(*) from {table} where amount < 0 and date(ts) = {date}"},
+			Expected: Result{Name: "negative", Type: "sql", Severity: SevWarn, Table: "events", Value: 1,
+				Message: "1 is above max 0"},
+		},
+		"query error": {
+			Input: Rule{Type: "row_count", Table: "missing", Min: f(1)},
+			Expected: Result{Name: "row_count", Type: "row_count", Severity: SevWarn, Table: "missing",
+				Message: "query SQL logic error: no such table: missing (1)"},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
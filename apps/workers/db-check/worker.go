@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,15 +20,38 @@ type worker struct {
 	options
 	task.Meta
 	slack *slack.Slack
+	rules *Rules
 
 	DBSrc     string `uri:"db_src" required:"true"`     // database source
 	Table     string `uri:"table" required:"true"`      // name of the schema.table to query
-	Type      string `uri:"type" required:"true"`       // type of check
+	Type      string `uri:"type"`                       // type of check
+	Rules     string `uri:"rules"`                      // path to a rules file (instead of type)
 	Field     string `uri:"field"`                      // field name being checked
 	DateField string `uri:"date_field" required:"true"` // date/time field to query
 	DateType  string `uri:"date_type"`                  // date type ("dt" = date, "ts" = timestamp)
 	Date      string `uri:"date" required:"true"`       // date value to use in query
 	GroupTS   string `uri:"group_ts"`                   // date field to group by
+	Dest      string `uri:"dest"`                       // file path to write check results
+}
+
+// identRe matches a table or column name that may be qualified with a schema (schema.table)
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*){0,2}$`)
+
+// validIdent returns an error for any name that is not a valid identifier.
+// Identifiers cannot be bound as query parameters so they are checked
+// before being used in a query.
+func validIdent(names ...string) error {
+	for _, n := range names {
+		if !identRe.MatchString(n) {
+			return fmt.Errorf("invalid identifier %q", n)
+		}
+	}
+	return nil
+}
+
+// fieldList splits a comma separated list of field names
+func fieldList(s string) []string {
+	return strings.Split(strings.Replace(s, " ", "", -1), ",")
 }
 
 type ZeroRec struct {
@@ -52,6 +76,30 @@ func (o *options) newWorker(info string) task.Worker {
 	if err := uri.Unmarshal(info, w); err != nil {
 		return task.InvalidWorker("params uri.unmarshal: %v", err)
 	}
+	if err := validIdent(w.Table, w.DateField); err != nil {
+		return task.InvalidWorker("%v", err)
+	}
+	if w.GroupTS != "" {
+		if err := validIdent(w.GroupTS); err != nil {
+			return task.InvalidWorker("%v", err)
+		}
+	}
+	if w.Field != "" {
+		if err := validIdent(fieldList(w.Field)...); err != nil {
+			return task.InvalidWorker("%v", err)
+		}
+	}
+	if w.Type == "" && w.Rules == "" {
+		return task.InvalidWorker("type or rules is required")
+	}
+	if w.Rules != "" {
+		rules, err := LoadRules(w.Rules, w.FOpts)
+		if err != nil {
+			return task.InvalidWorker("%v", err)
+		}
+		w.rules = rules
+		w.Type = "rules"
+	}
 	if (w.Type == "null" || w.Type == "zero") && w.Field == "" {
 		return task.InvalidWorker("field is required on %s checks", w.Type)
 	}
@@ -61,7 +109,7 @@ func (o *options) newWorker(info string) task.Worker {
 	if w.Type == "zero" && w.DateType == "ts" && w.GroupTS != "" {
 		return task.InvalidWorker("group_ts only valid with 'dt' date_type")
 	}
-	if w.Type == "zero" && w.DateType == "ts" {
+	if (w.Type == "zero" || w.Type == "rules") && w.DateType == "ts" {
 		_, err := time.Parse("2006-01-02T15:00", w.Date)
 		if err != nil {
 			return task.InvalidWorker("invalid date; expected format: yyyy-mm-ddThh:00")
@@ -84,6 +132,8 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 		return w.CheckNull(ctx)
 	case "zero":
 		return w.CheckZeroSum(ctx)
+	case "rules":
+		return w.CheckRules(ctx)
 	default:
 		return task.Failf("unsupported type %s", w.Type)
 	}
@@ -112,23 +162,41 @@ func (w *worker) CheckMissing(ctx context.Context) (task.Result, string) {
 		w.slack.SendMessage(m)
 	}
 
+	res := Result{Name: "missing", Type: w.Type, Severity: SevWarn, Table: w.Table, Passed: issues == 0, Value: float64(cnt)}
+	if err != nil {
+		res.Message = err.Error()
+	} else if cnt == 0 {
+		res.Message = "missing data"
+	}
+	if err := w.writeReport(w.newReport(res)); err != nil {
+		return task.Failf("write results: %v", err)
+	}
+
 	return task.Completed("table %s missing data check completed for date %s, issues: %d", w.Table, w.Date, issues)
 }
 
 func (w *worker) GetRecordCount(ctx context.Context) (count int64, err error) {
-	pg, found := w.Psql[w.DBSrc]
+	src, found := w.Sources[w.DBSrc]
 	if !found {
 		return 0, fmt.Errorf("db source %s not found", w.DBSrc)
 	}
-	qStr := fmt.Sprintf("select count(0) as count from %s where date(%s) = '%s'", w.Table, w.DateField, w.Date)
-	row := pg.DB.QueryRowxContext(ctx, qStr)
+	qStr := fmt.Sprintf("select count(0) as count from %s where %s(%s) = ?", w.Table, dateFunc(src.Type), w.DateField)
+	row := src.DB.QueryRowxContext(ctx, src.DB.Rebind(qStr), w.Date)
 	err = row.Scan(&count)
 	if err != nil {
-		return 0, fmt.Errorf("postgres scan %w", err)
+		return 0, fmt.Errorf("%s scan %w", src.Type, err)
 	}
 	return count, nil
 }
 
+// dateFunc returns the sql function that truncates a timestamp to a date
+func dateFunc(dbType string) string {
+	if dbType == "clickhouse" {
+		return "toDate"
+	}
+	return "date"
+}
+
 func (w *worker) CheckNull(ctx context.Context) (task.Result, string) {
 	m := w.slack.NewMessage(":radioactive_sign: *task-tools db-check - Null Check - " + w.Date + "*")
 	issues := 0
@@ -151,19 +219,29 @@ func (w *worker) CheckNull(ctx context.Context) (task.Result, string) {
 		w.slack.SendMessage(m)
 	}
 
+	res := Result{Name: "null " + w.Field, Type: w.Type, Severity: SevWarn, Table: w.Table, Passed: issues == 0, Value: float64(cnt)}
+	if err != nil {
+		res.Message = err.Error()
+	} else if cnt != 0 {
+		res.Message = "null value"
+	}
+	if err := w.writeReport(w.newReport(res)); err != nil {
+		return task.Failf("write results: %v", err)
+	}
+
 	return task.Completed("table %s; field %s null check completed for date %s, issues: %d", w.Table, w.Field, w.Date, issues)
 }
 
 func (w *worker) GetNullCount(ctx context.Context) (count int64, err error) {
-	pg, found := w.Psql[w.DBSrc]
+	src, found := w.Sources[w.DBSrc]
 	if !found {
 		return 0, fmt.Errorf("db source %s not found", w.DBSrc)
 	}
-	qStr := fmt.Sprintf("select count(0) as count from %s where %s is null and date(%s) = '%s'", w.Table, w.Field, w.DateField, w.Date)
-	row := pg.DB.QueryRowxContext(ctx, qStr)
+	qStr := fmt.Sprintf("select count(0) as count from %s where %s is null and %s(%s) = ?", w.Table, w.Field, dateFunc(src.Type), w.DateField)
+	row := src.DB.QueryRowxContext(ctx, src.DB.Rebind(qStr), w.Date)
 	err = row.Scan(&count)
 	if err != nil {
-		return 0, fmt.Errorf("postgres scan %w", err)
+		return 0, fmt.Errorf("%s scan %w", src.Type, err)
 	}
 	return count, nil
 }
@@ -202,18 +280,27 @@ func (w *worker) CheckZeroSum(ctx context.Context) (task.Result, string) {
 		w.slack.SendMessage(m)
 	}
 
+	res := Result{Name: "zero " + w.Field, Type: w.Type, Severity: SevWarn, Table: w.Table, Passed: issues == 0, Value: float64(issues)}
+	if err != nil {
+		res.Message = err.Error()
+	} else if issues > 0 {
+		res.Message = fmt.Sprintf("%d zero sum(s), %d missing hour(s)", len(zr), len(mr))
+	}
+	if err := w.writeReport(w.newReport(res)); err != nil {
+		return task.Failf("write results: %v", err)
+	}
+
 	return task.Completed("table %s; zero sum check completed for date %s, issues: %d", w.Table, w.Date, issues)
 }
 
 func (w *worker) GetZeroSums(ctx context.Context) (zr ZeroRecs, mr MissingRecs, err error) {
-	pg, found := w.Psql[w.DBSrc]
+	src, found := w.Sources[w.DBSrc]
 	if !found {
 		return nil, nil, fmt.Errorf("db source %s not found", w.DBSrc)
 	}
 
 	selString := ""
-	s := strings.Split(strings.Replace(w.Field, " ", "", -1), ",")
-	for _, v := range s {
+	for _, v := range fieldList(w.Field) {
 		selString += fmt.Sprintf("sum(%s) as %s,", v, v)
 	}
 	selString = selString[:len(selString)-1] //remove last comma
@@ -229,16 +316,16 @@ func (w *worker) GetZeroSums(ctx context.Context) (zr ZeroRecs, mr MissingRecs,
 	}
 	foundHours := make([]bool, 24)
 
-	qStr := fmt.Sprintf("select %s, %s as date from %s where %s = '%s' %s;", selString, asDate, w.Table, w.DateField, w.Date, grpString)
-	rows, err := pg.DB.QueryxContext(ctx, qStr)
+	qStr := fmt.Sprintf("select %s, %s as date from %s where %s = ? %s", selString, asDate, w.Table, w.DateField, grpString)
+	rows, err := src.DB.QueryxContext(ctx, src.DB.Rebind(qStr), w.Date)
 	if err != nil {
-		return nil, nil, fmt.Errorf("postgres query %w", err)
+		return nil, nil, fmt.Errorf("%s query %w", src.Type, err)
 	}
 	defer rows.Close()
 
 	colNames, err := rows.Columns()
 	if err != nil {
-		return nil, nil, fmt.Errorf("postgres columns %w", err)
+		return nil, nil, fmt.Errorf("%s columns %w", src.Type, err)
 	}
 	var colVals = make(map[string]interface{})
 	cols := make([]interface{}, len(colNames))
@@ -249,7 +336,7 @@ func (w *worker) GetZeroSums(ctx context.Context) (zr ZeroRecs, mr MissingRecs,
 	for rows.Next() {
 		err = rows.Scan(colPtrs...)
 		if err != nil {
-			return nil, nil, fmt.Errorf("postgres scan %w", err)
+			return nil, nil, fmt.Errorf("%s scan %w", src.Type, err)
 		}
 		for i, col := range cols {
 			colVals[colNames[i]] = col
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/hydronica/trial"
 	"github.com/jmoiron/sqlx"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/db"
 )
 
 func TestGetZeroSums(t *testing.T) {
@@ -43,7 +49,7 @@ func TestGetZeroSums(t *testing.T) {
 		eq.WillReturnRows(rows)
 
 		w := &worker{
-			options: options{Psql: map[string]Postgres{"test": {
+			options: options{Sources: map[string]Source{"test": {
 				DB: sqlx.NewDb(db, "sql"),
 			}}},
 			DBSrc:    "test",
@@ -154,3 +160,97 @@ func TestGetZeroSums(t *testing.T) {
 
 	trial.New(fn, cases).Test(t)
 }
+
+func TestGetRecordCount(t *testing.T) {
+	sqlDB, err := db.SQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	_, err = sqlDB.Exec(`create table events (id integer, ts timestamp);
+insert into events values (1, '2023-05-24 01:00:00'), (2, '2023-05-24 13:00:00'), (3, '2023-05-25 00:00:00');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := func(date string) (int64, error) {
+		w := &worker{
+			options: options{Sources: map[string]Source{"test": {
+				Type: "sqlite",
+				DB:   sqlx.NewDb(sqlDB, "sqlite"),
+			}}},
+			DBSrc:     "test",
+			Table:     "events",
+			DateField: "ts",
+			Date:      date,
+		}
+		return w.GetRecordCount(context.Background())
+	}
+	cases := trial.Cases[string, int64]{
+		"two records": {
+			Input:    "2023-05-24",
+			Expected: 2,
+		},
+		"no records": {
+			Input:    "2023-05-26",
+			Expected: 0,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestNewWorker(t *testing.T) {
+	fn := func(info string) (string, error) {
+		wrkr := (&options{}).newWorker(info)
+		if invalid, msg := task.IsInvalidWorker(wrkr); invalid {
+			return "", errors.New(msg)
+		}
+		return wrkr.(*worker).Table, nil
+	}
+	cases := trial.Cases[string, string]{
+		"valid": {
+			Input:    "?db_src=test&table=schema.table&type=missing&date_field=ts&date=2023-05-24",
+			Expected: "schema.table",
+		},
+		"invalid table": {
+			Input:       "?db_src=test&table=schema.table;drop table x&type=missing&date_field=ts&date=2023-05-24",
+			ExpectedErr: errors.New(`invalid identifier "schema.table;drop table x"`),
+		},
+		"invalid date_field": {
+			Input:       "?db_src=test&table=t&type=missing&date_field=ts) or (1=1&date=2023-05-24",
+			ExpectedErr: errors.New("invalid identifier"),
+		},
+		"invalid field list": {
+			Input:       "?db_src=test&table=t&type=zero&date_type=dt&field=a,b c,d--&date_field=ts&date=2023-05-24",
+			ExpectedErr: errors.New("invalid identifier"),
+		},
+		"type or rules": {
+			Input:       "?db_src=test&table=t&date_field=ts&date=2023-05-24",
+			ExpectedErr: errors.New("type or rules is required"),
+		},
+	}
+	trial.New(fn, cases).Comparer(trial.Contains).SubTest(t)
+}
+
+func TestWriteReport(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "results.json")
+	w := &worker{
+		Meta:  task.NewMeta(),
+		DBSrc: "test",
+		Date:  "2023-05-24",
+		Dest:  dest,
+	}
+	rpt := w.newReport(Result{Name: "missing", Type: "missing", Severity: SevWarn, Table: "events", Value: 0, Message: "missing data"})
+	rpt.Checked = time.Date(2023, 5, 25, 0, 0, 0, 0, time.UTC)
+	if err := w.writeReport(rpt); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"db_src":"test","date":"2023-05-24","checked":"2023-05-25T00:00:00Z","name":"missing","type":"missing","severity":"warn","table":"events","passed":false,"value":0,"message":"missing data"}` + "\n"
+	if string(b) != exp {
+		t.Errorf("got %s\nexpected %s", b, exp)
+	}
+}
@@ -15,7 +15,7 @@ type options struct {
 
 var (
 	taskType    = "filecopy"
-	description = `filecopy is a simple worker to copy a file from one location to another, local, or remotely (s3)
+	description = `filecopy is a simple worker to copy files from one location to another, local, or remotely (s3)
 
 ## Info Definition
 worker info string uses a url type format:
@@ -23,7 +23,7 @@ worker info string uses a url type format:
 "{src-path}?{querystring-params}"
 
 Where:
-  * src-path - is a file path to copy to another location.
+  * src-path - is a file, directory or glob path (/path/*.json) of the files to copy to another location.
   
   * querystring-params can be:
   - dest-template - copied file destination and supports the following template tags:
@@ -39,13 +39,27 @@ Where:
     {DAY_SLUG} (date day slug, shorthand for {YYYY}/{MM}/{DD})
     {MONTH_SLUG} (date month slug, shorthand for {YYYY}/{MM})
 
+    use {SRC_FILE} when copying more than one file so each file has its own destination.
+
     - use-file-buffer - set to 'true' if file processing should use a file buffer instead of memory
     - note: the worker app user must have permissions to read and write to this location
+  - threads - number of files copied at the same time (default: 1)
+  - verify - compare the md5 checksum of the written file to the bytes written and to the source
+    checksum when the file is copied byte for byte (default: true). A file that fails is removed.
+  - skip-identical - skip files where the destination has the same md5 checksum and size as the source
+  - resume - only copy files that are missing at the destination. A destination with a different
+    size than the source is an incomplete copy and is copied again (sizes are not compared
+    when the compression changes).
+
+Every file is copied even if another file fails. The task fails with the first error and the
+number of failed files. The copied, skipped and failed paths are set in the task meta as
+'file', 'skipped' and 'failed'.
 
 Example task:
  
 {"type":"file-copy","info":"s3://bucket/path/to/file.json?dest-template=s3://bucket/to/destination/location/{SRC_FILE}"}
 {"type":"file-copy","info":"/path/to/source.json?dest-template=/path/to/destination/location/output.json"}
+{"type":"file-copy","info":"s3://bucket/path/*.gz?dest-template=gs://bucket/backup/{SRC_FILE}&threads=4&resume=true"}
  
  Query string params:
  - dest-template 
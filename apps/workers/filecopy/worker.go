@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 func newInfoOptions(info string) (*infoOptions, error) {
@@ -23,19 +30,41 @@ func newInfoOptions(info string) (*infoOptions, error) {
 // infoOptions contains the parsed info values
 // of a task.
 type infoOptions struct {
-	SrcPath       string `uri:"origin"`          // source file path - can be a directory or single file
-	DestTemplate  string `uri:"dest-template"`   // template for destination files
-	UseFileBuffer bool   `uri:"use-file-buffer"` // directs the writer to use a file buffer instead of in-memory when writing final deduped records
+	SrcPath       string `uri:"origin"`                // source file path - can be a directory, glob or single file
+	DestTemplate  string `uri:"dest-template"`         // template for destination files
+	UseFileBuffer bool   `uri:"use-file-buffer"`       // directs the writer to use a file buffer instead of in-memory when writing final deduped records
+	Threads       int    `uri:"threads" default:"1"`   // number of files copied at the same time
+	Verify        bool   `uri:"verify" default:"true"` // compare the md5 of the written file to the source
+	SkipIdentical bool   `uri:"skip-identical"`        // skip files where the destination has the same checksum and size
+	Resume        bool   `uri:"resume"`                // only copy files missing at the destination
+}
+
+// copy outcomes of a file
+const (
+	statusCopied    = "copied"
+	statusIdentical = "identical"
+	statusExists    = "exists"
+	statusFailed    = "failed"
+)
+
+// copyFile is a source file and its destination
+type copyFile struct {
+	src  stat.Stats
+	dest string
+
+	status string
+	err    error
+	read   stat.Stats // read file stats
+	sts    stat.Stats // written file stats
 }
 
 type worker struct {
-	iOpt         infoOptions
-	linesWritten int64
-	writer       file.Writer
-	reader       file.Reader
-	fileTopic    string
-	// csv
-	indexFields []int // csv index fields (set during validation)
+	task.Meta
+	iOpt      infoOptions
+	files     []*copyFile
+	fileTopic string
+	rOpts     *file.Options
+	wOpts     *file.Options
 }
 
 // validate populated info options
@@ -64,48 +93,265 @@ func (c *options) newWorker(info string) task.Worker {
 	if err != nil {
 		return task.InvalidWorker("%v", err)
 	}
+	if iOpt.Threads < 1 {
+		return task.InvalidWorker("invalid threads %d (min: 1)", iOpt.Threads)
+	}
 	fileDay := tmpl.PathTime(iOpt.SrcPath)
-	readPath := tmpl.Parse(iOpt.SrcPath, fileDay)
-	writePath := parseTmpl(readPath, iOpt.DestTemplate)
-	w := &worker{fileTopic: c.FileTopic}
+	srcPath := tmpl.Parse(iOpt.SrcPath, fileDay)
 
-	// create file writer
-	w.writer, err = file.NewWriter(writePath, c.WriteOptions)
-	if err != nil {
-		return task.InvalidWorker("writer: cannot initialize writer %s", writePath)
+	wOpts := c.WriteOptions
+	if iOpt.UseFileBuffer {
+		o := file.NewOptions()
+		if wOpts != nil {
+			*o = *wOpts
+		}
+		o.UseFileBuf = true
+		wOpts = o
+	}
+	w := &worker{
+		Meta:      task.NewMeta(),
+		iOpt:      *iOpt,
+		fileTopic: c.FileTopic,
+		rOpts:     c.ReadOptions,
+		wOpts:     wOpts,
 	}
 
-	// create file reader
-	w.reader, err = file.NewReader(iOpt.SrcPath, c.ReadOptions)
+	srcFiles, err := sourceFiles(srcPath, c.ReadOptions)
 	if err != nil {
-		return task.InvalidWorker("invalid reader info path: %v", err)
+		return task.InvalidWorker("%v", err)
+	}
+	dests := make(map[string]string)
+	for _, sts := range srcFiles {
+		dest := parseTmpl(sts.Path, iOpt.DestTemplate)
+		if src, found := dests[dest]; found {
+			return task.InvalidWorker("%s and %s have the same destination %s (use {SRC_FILE} in the dest-template)", src, sts.Path, dest)
+		}
+		dests[dest] = sts.Path
+		w.files = append(w.files, &copyFile{src: sts, dest: dest})
 	}
 
 	return w
 }
 
+// sourceFiles lists the files to copy. The path can be a
+// glob pattern, a directory or a single file.
+func sourceFiles(pth string, opts *file.Options) ([]stat.Stats, error) {
+	var files []stat.Stats
+	if strings.ContainsAny(pth, "[]*?") {
+		sts, err := file.Glob(pth, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = sts
+	} else {
+		sts, err := file.Stat(pth, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !sts.IsDir {
+			return []stat.Stats{sts}, nil
+		}
+		list, err := file.List(pth, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range list {
+			if !s.IsDir {
+				files = append(files, s)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in %s", pth)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 	if ctx.Err() != nil {
 		return task.Interrupted()
 	}
-	// copy the file from the reader to the writer
-	_, err := io.Copy(w.writer, w.reader)
-	w.reader.Close()
+
+	in := make(chan *copyFile)
+	var wg sync.WaitGroup
+	for i := 0; i < w.iOpt.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range in {
+				w.copy(ctx, f)
+			}
+		}()
+	}
+loop:
+	for _, f := range w.files {
+		select {
+		case in <- f:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(in)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return task.Interrupted()
+	}
+
+	// report the outcome of each file
+	var firstErr error
+	var copied, skipped, failed, read, written []string
+	for _, f := range w.files {
+		switch f.status {
+		case statusCopied:
+			copied = append(copied, f.sts.Path)
+			read = append(read, f.read.JSONString())
+			written = append(written, f.sts.JSONString())
+			if err := producer.Send(w.fileTopic, f.sts.JSONBytes()); err != nil {
+				log.Printf("could not publish to %s", w.fileTopic)
+			}
+		case statusIdentical, statusExists:
+			skipped = append(skipped, f.src.Path)
+		case statusFailed:
+			failed = append(failed, f.src.Path)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", f.src.Path, f.err)
+			}
+		}
+		if f.err != nil {
+			log.Printf("%s %s -> %s: %v", f.status, f.src.Path, f.dest, f.err)
+		} else {
+			log.Printf("%s %s -> %s", f.status, f.src.Path, f.dest)
+		}
+	}
+	meta := map[string][]string{"file": copied, "skipped": skipped, "failed": failed,
+		bootstrap.MetaRead: read, bootstrap.MetaWrite: written}
+	for k, v := range meta {
+		if len(v) > 0 {
+			w.SetMeta(k, v...)
+		}
+	}
+	if firstErr != nil {
+		return task.Failf("%d of %d files failed: %v", len(failed), len(w.files), firstErr)
+	}
+	if len(w.files) == 1 && len(copied) == 1 {
+		return task.Completed("Completed, wrote file %s", copied[0])
+	}
+	return task.Completed("copied %d of %d files (%d skipped)", len(copied), len(w.files), len(skipped))
+}
+
+// copy copies a single file unless it can be skipped
+// and sets the outcome on f.
+func (w *worker) copy(ctx context.Context, f *copyFile) {
+	if w.iOpt.Resume || w.iOpt.SkipIdentical {
+		if status := w.skip(f); status != "" {
+			f.status = status
+			return
+		}
+	}
+	f.sts, f.err = w.copyFile(ctx, f)
+	f.status = statusCopied
+	if f.err != nil {
+		f.status = statusFailed
+	}
+}
+
+// skip checks the destination and returns the skip status
+// or an empty string if the file needs to be copied.
+func (w *worker) skip(f *copyFile) string {
+	dest, err := file.Stat(f.dest, w.wOpts)
+	if err != nil || dest.IsDir {
+		return "" // not found
+	}
+	if w.iOpt.Resume {
+		// a different size is an incomplete copy, sizes
+		// can only be compared when the compression is unchanged
+		if isGzip(f.src.Path) != isGzip(f.dest) || dest.Size == f.src.Size {
+			return statusExists
+		}
+		return ""
+	}
+	src := f.src
+	if !isMD5(src.Checksum) {
+		// listed files may not include checksums
+		if src, err = file.Stat(f.src.Path, w.rOpts); err != nil {
+			return ""
+		}
+	}
+	if isMD5(src.Checksum) && src.Checksum == dest.Checksum && src.Size == dest.Size {
+		return statusIdentical
+	}
+	return ""
+}
+
+// copyFile copies the source to the destination
+// and verifies the written file.
+func (w *worker) copyFile(ctx context.Context, f *copyFile) (stat.Stats, error) {
+	writer, err := tracing.NewWriter(ctx, f.dest, w.wOpts)
+	if err != nil {
+		return stat.Stats{}, fmt.Errorf("writer: %w", err)
+	}
+	reader, err := tracing.NewReader(ctx, f.src.Path, w.rOpts)
 	if err != nil {
-		return task.Failf("io: copy %v", err)
+		writer.Abort()
+		return stat.Stats{}, fmt.Errorf("reader: %w", err)
 	}
 
-	err = w.writer.Close()
+	// copy the file from the reader to the writer
+	_, err = io.Copy(writer, reader)
+	reader.Close()
+	f.read = reader.Stats()
 	if err != nil {
-		return task.Failed(err)
+		writer.Abort()
+		return stat.Stats{}, fmt.Errorf("io: copy %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return stat.Stats{}, err
 	}
+	sts := writer.Stats()
+	if w.iOpt.Verify {
+		if err := w.verify(f.src.Path, f.read, sts); err != nil {
+			// don't leave a bad file at the destination
+			if rmErr := file.Remove(sts.Path, w.wOpts); rmErr != nil {
+				err = fmt.Errorf("%w (remove: %v)", err, rmErr)
+			}
+			return sts, err
+		}
+	}
+	return sts, nil
+}
 
-	stats := w.writer.Stats()
-	if err := producer.Send(w.fileTopic, stats.JSONBytes()); err != nil {
-		log.Printf("could not publish to %s", w.fileTopic)
+// verify compares the md5 checksum of the written file to the bytes
+// written. When the file is copied byte for byte (no change in
+// compression) the written bytes must also match the source checksum.
+// Checksums that are not an md5 (multipart uploads) are not compared.
+func (w *worker) verify(src string, read, written stat.Stats) error {
+	dest, err := file.Stat(written.Path, w.wOpts)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if isMD5(dest.Checksum) && dest.Checksum != written.Checksum {
+		return fmt.Errorf("verify: checksum %s does not match written %s", dest.Checksum, written.Checksum)
+	}
+	if isGzip(src) || isGzip(written.Path) {
+		return nil
 	}
+	if isMD5(read.Checksum) && read.Checksum != written.Checksum {
+		return fmt.Errorf("verify: checksum %s does not match source %s", written.Checksum, read.Checksum)
+	}
+	return nil
+}
+
+var md5Re = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// isMD5 checks if the checksum is a hex encoded md5 hash
+func isMD5(s string) bool {
+	return md5Re.MatchString(strings.Trim(s, `"`))
+}
 
-	return task.Completed("Completed, wrote file %s", stats.Path)
+func isGzip(pth string) bool {
+	return strings.HasSuffix(pth, ".gz")
 }
 
 // parseTmpl is a one-time tmpl parsing that supports the
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strings"
 	"testing"
@@ -11,7 +12,9 @@ import (
 	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
 )
 
 func TestMain(m *testing.M) {
@@ -166,3 +169,135 @@ func createFile(lines []string, pth string, created time.Time) {
 	// set created date
 	os.Chtimes(pth, created, created)
 }
+
+func TestWorker_Batch(t *testing.T) {
+	type output struct {
+		Msg     string
+		Files   map[string]string // destination file contents
+		Skipped []string
+		Read    int64 // bytes in the read_stats meta
+		Written int64 // bytes in the write_stats meta
+	}
+	fn := func(in string) (output, error) {
+		dir := t.TempDir()
+		os.MkdirAll(dir+"/src", 0755)
+		os.MkdirAll(dir+"/dest", 0755)
+		os.WriteFile(dir+"/src/a.json", []byte("a1\na2\n"), 0644)
+		os.WriteFile(dir+"/src/b.json", []byte("b1\n"), 0644)
+		os.WriteFile(dir+"/src/c.txt", []byte("c1\n"), 0644)
+		os.WriteFile(dir+"/dest/a.json", []byte("a1\na2\n"), 0644) // identical
+		os.WriteFile(dir+"/dest/b.json", []byte("old\n"), 0644)    // different
+
+		producer, _ = bus.NewProducer(bus.NewOptions("nop"))
+		wkr := (&options{}).newWorker(strings.ReplaceAll(in, "{dir}", dir))
+		if invalid, msg := task.IsInvalidWorker(wkr); invalid {
+			return output{}, errors.New(msg)
+		}
+		result, msg := wkr.DoTask(context.Background())
+		if result != task.CompleteResult {
+			return output{}, errors.New(msg)
+		}
+		out := output{Msg: strings.ReplaceAll(msg, dir, "{dir}"), Files: map[string]string{}}
+		entries, _ := os.ReadDir(dir + "/dest")
+		for _, e := range entries {
+			b, _ := os.ReadFile(dir + "/dest/" + e.Name())
+			out.Files[e.Name()] = string(b)
+		}
+		meta := wkr.(*worker).GetMeta()
+		for _, s := range meta["skipped"] {
+			out.Skipped = append(out.Skipped, strings.TrimPrefix(s, dir+"/src/"))
+		}
+		for _, s := range meta[bootstrap.MetaRead] {
+			out.Read += stat.NewFromBytes([]byte(s)).ByteCnt
+		}
+		for _, s := range meta[bootstrap.MetaWrite] {
+			out.Written += stat.NewFromBytes([]byte(s)).ByteCnt
+		}
+		return out, nil
+	}
+	cases := trial.Cases[string, output]{
+		"directory": {
+			Input: "{dir}/src/?dest-template={dir}/dest/{SRC_FILE}&threads=2",
+			Expected: output{
+				Msg:     "copied 3 of 3 files (0 skipped)",
+				Files:   map[string]string{"a.json": "a1\na2\n", "b.json": "b1\n", "c.txt": "c1\n"},
+				Read:    12,
+				Written: 12,
+			},
+		},
+		"glob": {
+			Input: "{dir}/src/*.txt?dest-template={dir}/dest/{SRC_FILE}",
+			Expected: output{
+				Msg:     "Completed, wrote file " + "{dir}/dest/c.txt",
+				Files:   map[string]string{"a.json": "a1\na2\n", "b.json": "old\n", "c.txt": "c1\n"},
+				Read:    3,
+				Written: 3,
+			},
+		},
+		"skip identical": {
+			Input: "{dir}/src/*.json?dest-template={dir}/dest/{SRC_FILE}&skip-identical=true",
+			Expected: output{
+				Msg:     "copied 1 of 2 files (1 skipped)",
+				Files:   map[string]string{"a.json": "a1\na2\n", "b.json": "b1\n"},
+				Skipped: []string{"a.json"},
+				Read:    3,
+				Written: 3,
+			},
+		},
+		"resume": { // b.json has a different size and is copied again
+			Input: "{dir}/src/?dest-template={dir}/dest/{SRC_FILE}&resume=true&threads=3",
+			Expected: output{
+				Msg:     "copied 2 of 3 files (1 skipped)",
+				Files:   map[string]string{"a.json": "a1\na2\n", "b.json": "b1\n", "c.txt": "c1\n"},
+				Skipped: []string{"a.json"},
+				Read:    6,
+				Written: 6,
+			},
+		},
+		"same destination": {
+			Input:     "{dir}/src/?dest-template={dir}/dest/out.json",
+			ShouldErr: true,
+		},
+		"no files": {
+			Input:     "{dir}/src/*.csv?dest-template={dir}/dest/{SRC_FILE}",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWorker_Verify(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/out.json", []byte("a1\n"), 0644)
+	sum := "1c6d31d5ac2e6e6d5f0e42bd0f1b1b4a" // md5 of something else
+	type input struct {
+		src     string
+		read    string // source checksum
+		written string // checksum of the written bytes
+	}
+	fn := func(in input) (any, error) {
+		w := &worker{}
+		sts, _ := file.Stat(dir+"/out.json", nil)
+		if in.written == "" {
+			in.written = sts.Checksum
+		}
+		return nil, w.verify(in.src, stat.Stats{Checksum: in.read}, stat.Stats{Path: sts.Path, Checksum: in.written})
+	}
+	cases := trial.Cases[input, any]{
+		"match": {
+			Input: input{src: "a.json", read: ""},
+		},
+		"write mismatch": {
+			Input:     input{src: "a.json", written: sum},
+			ShouldErr: true,
+		},
+		"source mismatch": {
+			Input:     input{src: "a.json", read: sum},
+			ShouldErr: true,
+		},
+		"compressed source": {
+			Input: input{src: "a.json.gz", read: sum},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// auth types
+const (
+	authBearer = "bearer"
+	authBasic  = "basic"
+	authHeader = "header"
+)
+
+const defaultAuthHeader = "X-API-Key"
+
+// Auth is the credentials of an api. Tokens and passwords
+// are expanded with environment variables (${API_TOKEN})
+// so secrets are not stored in the config file.
+type Auth struct {
+	Type     string `toml:"type" comment:"bearer, basic or header"`
+	Token    string `toml:"token"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Header   string `toml:"header" comment:"header name for the header type (default X-API-Key)"`
+}
+
+func (a Auth) validate() error {
+	switch a.Type {
+	case authBearer, authHeader:
+		if a.Token == "" {
+			return errors.New("token required")
+		}
+	case authBasic:
+		if a.Username == "" {
+			return errors.New("username required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", a.Type)
+	}
+	return nil
+}
+
+// apply sets the credentials on the request
+func (a Auth) apply(req *http.Request) {
+	switch a.Type {
+	case authBearer:
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(a.Token))
+	case authBasic:
+		req.SetBasicAuth(os.ExpandEnv(a.Username), os.ExpandEnv(a.Password))
+	case authHeader:
+		h := a.Header
+		if h == "" {
+			h = defaultAuthHeader
+		}
+		req.Header.Set(h, os.ExpandEnv(a.Token))
+	}
+}
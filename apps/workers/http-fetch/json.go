@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookup returns the raw json value at the path (data.items or data.0.id)
+// or nil if it does not exist. An empty path is the whole body.
+func lookup(body []byte, path string) (json.RawMessage, error) {
+	v := json.RawMessage(bytes.TrimSpace(body))
+	if path == "" {
+		return v, nil
+	}
+	for _, p := range strings.Split(path, ".") {
+		switch {
+		case len(v) == 0:
+			return nil, nil
+		case v[0] == '{':
+			var m map[string]json.RawMessage
+			if err := json.Unmarshal(v, &m); err != nil {
+				return nil, fmt.Errorf("invalid json: %w", err)
+			}
+			v = m[p]
+		case v[0] == '[':
+			var a []json.RawMessage
+			if err := json.Unmarshal(v, &a); err != nil {
+				return nil, fmt.Errorf("invalid json: %w", err)
+			}
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(a) {
+				return nil, nil
+			}
+			v = a[i]
+		default:
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+// extractRecords returns each record at the path as a compact json line.
+// An array is a record for each element, other values are a single record.
+func extractRecords(body []byte, path string) ([][]byte, error) {
+	raw, err := lookup(body, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	values := []json.RawMessage{raw}
+	if raw[0] == '[' {
+		values = nil
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+	}
+	records := make([][]byte, 0, len(values))
+	for _, v := range values {
+		var b bytes.Buffer
+		if err := json.Compact(&b, v); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+		records = append(records, b.Bytes())
+	}
+	return records, nil
+}
+
+// jsonString returns the value of a json string or the
+// raw value of other types. null is an empty string.
+func jsonString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// nextLink returns the rel="next" url from Link headers
+//
+//	Link: <https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=5>; rel="last"
+func nextLink(links []string) string {
+	for _, h := range links {
+		for _, link := range strings.Split(h, ",") {
+			parts := strings.Split(link, ";")
+			u := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(u, "<") || !strings.HasSuffix(u, ">") {
+				continue
+			}
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(k, "rel") {
+					for _, rel := range strings.Fields(strings.Trim(v, `"`)) {
+						if rel == "next" {
+							return u[1 : len(u)-1]
+						}
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
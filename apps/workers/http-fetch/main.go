@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	tools "github.com/pcelvng/task-tools"
+	"github.com/pcelvng/task-tools/bootstrap"
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task/bus"
+)
+
+const (
+	taskType = "http-fetch"
+	desc     = `http-fetch requests pages from a http api and writes the records of each page as json lines to a file
+
+info params
+ - origin: (required) url to request, supports time templating {YYYY}/{MM}/{DD}/{HH}
+ - dest: (required) file path to write records to, supports time templating
+ - query: query params added to the url (key:value|key2:value2), supports time templating
+ - header: request headers (key:value|key2:value2)
+ - auth: name of an auth defined in the config
+ - records: json path of the records array in the response (data.items). The response
+	is the records when empty, an array is a record per element and an object is a single record
+ - paginate: link, cursor or page (default: a single request)
+	link - follow the rel="next" url in the Link header (a link to a fetched page fails)
+	cursor - set the cursor_param to the value at cursor_field until it is empty (a repeated cursor fails)
+	page - increment the page_param until a page has no records
+ - cursor_field: json path of the next cursor in the response (default: next_cursor)
+ - cursor_param: query param the cursor is sent as (default: cursor)
+ - page_param: query param of the page number (default: page)
+ - page_start: first page number (default: 1)
+ - max_pages: stop after this many pages (default: no limit)
+ - rate: max requests per second (default: no limit)
+ - retries: number of retries for connection errors, 429 and 5xx responses (default: 3)
+ - retry_wait: wait before the first retry, doubled for each retry unless the
+	response has a Retry-After header, up to 5m (default: 1s)
+ - timeout: timeout of each request (default: 30s)
+
+The template time is taken from the info string (day=2006-01-02, hour=2006-01-02T15
+or time=2006-01-02T15:04:05Z) and defaults to the current time.
+
+The pages, rows and file written are set in the task meta.
+
+config auth
+[auth.name]
+type = "bearer"     # bearer, basic or header
+token = "${TOKEN}"  # token for bearer or header, environment variables are expanded
+username = "user"   # basic auth
+password = "${PASS}"
+header = "X-API-Key" # header name for the header type (default X-API-Key)
+
+Example:
+{"type":"http-fetch","info":"https://api.example.com/v1/orders?dest=gs://bucket/orders/{YYYY}/{MM}/{DD}.json.gz&day=2020-01-02&query=date:{YYYY}-{MM}-{DD}&auth=example&records=data&paginate=cursor&cursor_field=meta.next"}
+{"type":"http-fetch","info":"https://api.github.com/repos/org/repo/issues?dest=/data/issues.json&paginate=link&rate=1&header=Accept:application/json"}`
+)
+
+var producer, _ = bus.NewProducer(bus.NewOptions("nop"))
+
+type options struct {
+	File      *file.Options   `toml:"file"`
+	FileTopic string          `toml:"file_topic" comment:"topic to publish written file stats"`
+	Auth      map[string]Auth `toml:"auth"`
+}
+
+func (o *options) Validate() error {
+	for name, a := range o.Auth {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("auth %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	opts := &options{
+		File:      file.NewOptions(),
+		FileTopic: "files",
+	}
+	app := bootstrap.NewWorkerApp(taskType, opts.NewWorker, opts).
+		Description(desc).
+		Version(tools.String()).
+		Initialize()
+	if opts.FileTopic != "-" {
+		producer = app.NewProducer()
+	}
+	app.Run()
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jbsmith7741/uri"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
+)
+
+// pagination styles
+const (
+	pageNone   = ""
+	pageLink   = "link"
+	pageCursor = "cursor"
+	pageNumber = "page"
+)
+
+type worker struct {
+	task.Meta
+
+	URL      string            `uri:"origin" required:"true"`
+	Dest     string            `uri:"dest" required:"true"`
+	Query    map[string]string `uri:"query"`
+	Headers  map[string]string `uri:"header"`
+	AuthName string            `uri:"auth"`
+	Records  string            `uri:"records"` // json path to the records in the response
+
+	Paginate    string `uri:"paginate"`
+	CursorField string `uri:"cursor_field" default:"next_cursor"`
+	CursorParam string `uri:"cursor_param" default:"cursor"`
+	PageParam   string `uri:"page_param" default:"page"`
+	PageStart   int    `uri:"page_start" default:"1"`
+	MaxPages    int    `uri:"max_pages"`
+
+	Rate      float64       `uri:"rate"` // requests per second
+	Retries   int           `uri:"retries" default:"3"`
+	RetryWait time.Duration `uri:"retry_wait" default:"1s"`
+	Timeout   time.Duration `uri:"timeout" default:"30s"`
+
+	auth      *Auth
+	client    *http.Client
+	limit     *limiter
+	writer    file.Writer
+	fOpts     *file.Options
+	fileTopic string
+	page      int             // current page number
+	seen      map[string]bool // fetched links or cursors, a repeat would fetch the same pages forever
+}
+
+func (o *options) NewWorker(info string) task.Worker {
+	w := &worker{
+		Meta:      task.NewMeta(),
+		fileTopic: o.FileTopic,
+		fOpts:     o.File,
+	}
+	if err := uri.Unmarshal(info, w); err != nil {
+		return task.InvalidWorker("uri %s", err)
+	}
+
+	switch w.Paginate {
+	case pageNone, pageLink, pageCursor, pageNumber:
+	default:
+		return task.InvalidWorker("unknown paginate %q (link, cursor or page)", w.Paginate)
+	}
+	if w.Rate < 0 {
+		return task.InvalidWorker("rate must be positive")
+	}
+	if w.AuthName != "" {
+		a, found := o.Auth[w.AuthName]
+		if !found {
+			return task.InvalidWorker("auth %q not found in config", w.AuthName)
+		}
+		w.auth = &a
+	}
+
+	tm := tmpl.InfoTime(info)
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	u, err := url.Parse(tmpl.Parse(w.URL, tm))
+	if err != nil {
+		return task.InvalidWorker("url %s", err)
+	}
+	q := u.Query()
+	for k, v := range w.Query {
+		q.Set(k, tmpl.Parse(v, tm))
+	}
+	w.page = w.PageStart
+	w.seen = make(map[string]bool)
+	if w.Paginate == pageNumber {
+		q.Set(w.PageParam, strconv.Itoa(w.page))
+	}
+	u.RawQuery = q.Encode()
+	w.URL = u.String()
+
+	w.Dest = tmpl.Parse(w.Dest, tm)
+	w.client = &http.Client{Timeout: w.Timeout}
+	w.limit = newLimiter(w.Rate)
+	return w
+}
+
+func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
+	// the writer is created in a span of the task's trace
+	var err error
+	if w.writer, err = tracing.NewWriter(ctx, w.Dest, w.fOpts); err != nil {
+		return task.Failf("writer %v", err)
+	}
+	var pages, rows int
+	for next := w.URL; next != ""; {
+		if w.MaxPages > 0 && pages >= w.MaxPages {
+			break
+		}
+		body, header, err := w.fetch(ctx, next)
+		if err != nil {
+			w.writer.Abort()
+			return task.Failf("page %d: %v", pages+1, err)
+		}
+		records, err := extractRecords(body, w.Records)
+		if err != nil {
+			w.writer.Abort()
+			return task.Failf("page %d: %v", pages+1, err)
+		}
+		for _, r := range records {
+			if err := w.writer.WriteLine(r); err != nil {
+				w.writer.Abort()
+				return task.Failf("write %v", err)
+			}
+		}
+		pages++
+		rows += len(records)
+
+		if next, err = w.next(next, body, header, len(records)); err != nil {
+			w.writer.Abort()
+			return task.Failf("page %d: %v", pages, err)
+		}
+	}
+
+	if err := w.writer.Close(); err != nil {
+		return task.Failf("close %v", err)
+	}
+	sts := w.writer.Stats()
+	if w.fileTopic != "" && sts.ByteCnt > 0 {
+		if err := producer.Send(w.fileTopic, sts.JSONBytes()); err != nil {
+			log.Println("file stats", err)
+		}
+	}
+	w.SetMeta("file", sts.Path)
+	w.SetMeta("pages", strconv.Itoa(pages))
+	w.SetMeta("rows", strconv.Itoa(rows))
+	return task.Completed("%d pages with %d rows fetched to %s", pages, rows, sts.Path)
+}
+
+// next returns the url of the next page or an empty string when done.
+func (w *worker) next(current string, body []byte, header http.Header, records int) (string, error) {
+	switch w.Paginate {
+	case pageLink:
+		link := nextLink(header.Values("Link"))
+		if link == "" {
+			return "", nil
+		}
+		w.seen[current] = true
+		next, err := resolve(current, link)
+		if err != nil {
+			return "", err
+		}
+		if w.seen[next] {
+			return "", fmt.Errorf("next link %s repeated", next)
+		}
+		return next, nil
+	case pageCursor:
+		raw, err := lookup(body, w.CursorField)
+		if err != nil {
+			return "", err
+		}
+		cursor := jsonString(raw)
+		if cursor == "" {
+			return "", nil
+		}
+		if w.seen[cursor] {
+			return "", fmt.Errorf("cursor %q repeated", cursor)
+		}
+		w.seen[cursor] = true
+		return setParam(current, w.CursorParam, cursor)
+	case pageNumber:
+		if records == 0 {
+			return "", nil
+		}
+		w.page++
+		return setParam(current, w.PageParam, strconv.Itoa(w.page))
+	}
+	return "", nil
+}
+
+// fetch requests the url and retries connection errors, 429 and 5xx responses.
+func (w *worker) fetch(ctx context.Context, u string) ([]byte, http.Header, error) {
+	wait := w.RetryWait
+	for i := 0; ; i++ {
+		if err := w.limit.wait(ctx); err != nil {
+			return nil, nil, err
+		}
+		body, header, retry, err := w.get(ctx, u)
+		if err == nil {
+			return body, header, nil
+		}
+		if !retry || i >= w.Retries {
+			return nil, nil, err
+		}
+		d := wait
+		if ra := retryAfter(header); ra > 0 {
+			d = ra
+		}
+		log.Printf("retry %d in %v: %v", i+1, d, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(d):
+		}
+		wait *= 2
+	}
+}
+
+// get makes a single request and reports if a failed request can be retried
+func (w *worker) get(ctx context.Context, u string) (body []byte, header http.Header, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.auth != nil {
+		w.auth.apply(req)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.Header, true, err
+	}
+	if resp.StatusCode >= 300 {
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, resp.Header, retry, fmt.Errorf("%s %s", resp.Status, truncate(body, 200))
+	}
+	return body, resp.Header, false, nil
+}
+
+// maxRetryAfter is the longest wait used from a Retry-After header
+const maxRetryAfter = 5 * time.Minute
+
+// retryAfter returns the wait in the Retry-After header (seconds or http date)
+// up to maxRetryAfter
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	return min(d, maxRetryAfter)
+}
+
+func truncate(b []byte, n int) []byte {
+	b = bytes.TrimSpace(b)
+	if len(b) > n {
+		return append(b[:n:n], "..."...)
+	}
+	return b
+}
+
+func resolve(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("next link %w", err)
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+func setParam(u, key, value string) (string, error) {
+	p, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	q := p.Query()
+	q.Set(key, value)
+	p.RawQuery = q.Encode()
+	return p.String(), nil
+}
+
+// limiter spaces requests so no more than rate requests are made per second
+type limiter struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	if d := time.Until(l.last.Add(l.interval)); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	l.last = time.Now()
+	return ctx.Err()
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydronica/trial"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+func TestNewWorker(t *testing.T) {
+	opts := &options{
+		File: file.NewOptions(),
+		Auth: map[string]Auth{"api": {Type: authBearer, Token: "abc"}},
+	}
+	type output struct {
+		URL  string
+		Dest string
+	}
+	fn := func(info string) (output, error) {
+		w := opts.NewWorker(info)
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		return output{URL: w.(*worker).URL, Dest: w.(*worker).Dest}, nil
+	}
+	cases := trial.Cases[string, output]{
+		"basic": {
+			Input:    "https://api.example.com/items?dest=nop://out.json",
+			Expected: output{URL: "https://api.example.com/items", Dest: "nop://out.json"},
+		},
+		"templates": {
+			Input: "https://api.example.com/{YYYY}/items?dest=nop://{YYYY}/{MM}/{DD}.json&day=2020-01-02&query=from:{YYYY}-{MM}-{DD}|limit:10",
+			Expected: output{
+				URL:  "https://api.example.com/2020/items?from=2020-01-02&limit=10",
+				Dest: "nop://2020/01/02.json",
+			},
+		},
+		"page start": {
+			Input:    "https://api.example.com/items?dest=nop://out.json&paginate=page&page_param=p&page_start=0",
+			Expected: output{URL: "https://api.example.com/items?p=0", Dest: "nop://out.json"},
+		},
+		"auth": {
+			Input:    "https://api.example.com/items?dest=nop://out.json&auth=api",
+			Expected: output{URL: "https://api.example.com/items", Dest: "nop://out.json"},
+		},
+		"missing dest": {
+			Input:       "https://api.example.com/items",
+			ExpectedErr: errors.New("dest is required"),
+		},
+		"unknown auth": {
+			Input:       "https://api.example.com/items?dest=nop://out.json&auth=other",
+			ExpectedErr: errors.New(`auth "other" not found`),
+		},
+		"unknown paginate": {
+			Input:       "https://api.example.com/items?dest=nop://out.json&paginate=offset",
+			ExpectedErr: errors.New(`unknown paginate "offset"`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWorker_DoTask(t *testing.T) {
+	items := []string{`{"id":1}`, `{"id":2}`, `{"id":3}`, `{"id":4}`, `{"id":5}`}
+	var retries int
+	mux := http.NewServeMux()
+	// link pages 2 items per page with a relative next link
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := strconv.Atoi(r.URL.Query().Get("p"))
+		end := min(p+2, len(items))
+		if end < len(items) {
+			w.Header().Add("Link", fmt.Sprintf(`</link?p=%d>; rel="next", </link?p=4>; rel="last"`, end))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(items[p:end], ","))
+	})
+	// cursor is the index of the next item
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		c, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		next := "null"
+		if c+2 < len(items) {
+			next = strconv.Itoa(c + 2)
+		}
+		fmt.Fprintf(w, `{"data":{"items":[%s]},"meta":{"next":%s}}`, strings.Join(items[c:min(c+2, len(items))], ","), next)
+	})
+	// always returns the same cursor
+	mux.HandleFunc("/cursor-repeat", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"items":[%s]},"meta":{"next":"a"}}`, items[0])
+	})
+	// cursors cycle a -> b -> a
+	mux.HandleFunc("/cursor-cycle", func(w http.ResponseWriter, r *http.Request) {
+		next := map[string]string{"": "a", "a": "b", "b": "a"}[r.URL.Query().Get("cursor")]
+		fmt.Fprintf(w, `{"data":{"items":[%s]},"meta":{"next":%q}}`, items[0], next)
+	})
+	// the second page links back to the first
+	mux.HandleFunc("/link-cycle", func(w http.ResponseWriter, r *http.Request) {
+		next := "/link-cycle?p=1"
+		if r.URL.Query().Get("p") == "1" {
+			next = "/link-cycle"
+		}
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+		fmt.Fprintf(w, "[%s]", items[0])
+	})
+	// page numbers start at 1 and 3 items per page
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min((p-1)*3, len(items))
+		fmt.Fprintf(w, `{"results": [%s]}`, strings.Join(items[start:min(start+3, len(items))], ",\n  "))
+	})
+	mux.HandleFunc("/object", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{\n  \"date\": \""+r.URL.Query().Get("date")+"\",\n  \"total\": 5\n}")
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/json" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	// fail the first 2 requests
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if retries++; retries <= 2 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[{"id":1}]`)
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	os.Setenv("HTTP_FETCH_TOKEN", "secret")
+	opts := &options{
+		File: file.NewOptions(),
+		Auth: map[string]Auth{"api": {Type: authBearer, Token: "${HTTP_FETCH_TOKEN}"}},
+	}
+	type output struct {
+		Msg   string
+		Lines []string
+		Meta  map[string][]string
+	}
+	fn := func(info string) (output, error) {
+		retries = 0
+		dir := t.TempDir()
+		info = strings.NewReplacer("{server}", srv.URL, "{dir}", dir).Replace(info)
+		w := opts.NewWorker(info)
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		result, msg := w.DoTask(context.Background())
+		if result != task.CompleteResult {
+			return output{}, errors.New(msg)
+		}
+		b, err := os.ReadFile(dir + "/out.json")
+		if err != nil && !os.IsNotExist(err) {
+			return output{}, err
+		}
+		meta := w.(*worker).GetMeta()
+		meta["file"] = []string{strings.ReplaceAll(meta.Get("file"), dir, "{dir}")}
+		return output{
+			Msg:   strings.ReplaceAll(msg, dir, "{dir}"),
+			Lines: strings.Fields(string(b)),
+			Meta:  meta,
+		}, nil
+	}
+	meta := func(pages, rows int) map[string][]string {
+		return map[string][]string{"file": {"{dir}/out.json"}, "pages": {strconv.Itoa(pages)}, "rows": {strconv.Itoa(rows)}}
+	}
+	cases := trial.Cases[string, output]{
+		"single request": {
+			Input: "{server}/link?dest={dir}/out.json",
+			Expected: output{
+				Msg:   "1 pages with 2 rows fetched to {dir}/out.json",
+				Lines: items[:2],
+				Meta:  meta(1, 2),
+			},
+		},
+		"link": {
+			Input: "{server}/link?dest={dir}/out.json&paginate=link",
+			Expected: output{
+				Msg:   "3 pages with 5 rows fetched to {dir}/out.json",
+				Lines: items,
+				Meta:  meta(3, 5),
+			},
+		},
+		"max pages": {
+			Input: "{server}/link?dest={dir}/out.json&paginate=link&max_pages=2",
+			Expected: output{
+				Msg:   "2 pages with 4 rows fetched to {dir}/out.json",
+				Lines: items[:4],
+				Meta:  meta(2, 4),
+			},
+		},
+		"cursor": {
+			Input: "{server}/cursor?dest={dir}/out.json&paginate=cursor&records=data.items&cursor_field=meta.next&cursor_param=after&rate=100",
+			Expected: output{
+				Msg:   "3 pages with 5 rows fetched to {dir}/out.json",
+				Lines: items,
+				Meta:  meta(3, 5),
+			},
+		},
+		"repeated cursor": {
+			Input:       "{server}/cursor-repeat?dest={dir}/out.json&paginate=cursor&records=data.items&cursor_field=meta.next&rate=100",
+			ExpectedErr: errors.New(`page 2: cursor "a" repeated`),
+		},
+		"cursor cycle": {
+			Input:       "{server}/cursor-cycle?dest={dir}/out.json&paginate=cursor&records=data.items&cursor_field=meta.next&rate=100",
+			ExpectedErr: errors.New(`page 3: cursor "a" repeated`),
+		},
+		"link cycle": {
+			Input:       "{server}/link-cycle?dest={dir}/out.json&paginate=link",
+			ExpectedErr: errors.New("page 2: next link " + srv.URL + "/link-cycle repeated"),
+		},
+		"page": {
+			Input: "{server}/page?dest={dir}/out.json&paginate=page&records=results",
+			Expected: output{
+				Msg:   "3 pages with 5 rows fetched to {dir}/out.json",
+				Lines: items,
+				Meta:  meta(3, 5),
+			},
+		},
+		"object record": {
+			Input: "{server}/object?dest={dir}/out.json&day=2020-01-02&query=date:{YYYY}-{MM}-{DD}",
+			Expected: output{
+				Msg:   "1 pages with 1 rows fetched to {dir}/out.json",
+				Lines: []string{`{"date":"2020-01-02","total":5}`},
+				Meta:  meta(1, 1),
+			},
+		},
+		"no records": {
+			Input: "{server}/object?dest={dir}/out.json&records=items",
+			Expected: output{
+				Msg:  "1 pages with 0 rows fetched to {dir}/out.json",
+				Meta: meta(1, 0),
+			},
+		},
+		"auth": {
+			Input: "{server}/auth?dest={dir}/out.json&auth=api&header=Accept:application/json",
+			Expected: output{
+				Msg:   "1 pages with 1 rows fetched to {dir}/out.json",
+				Lines: []string{`{"ok":true}`},
+				Meta:  meta(1, 1),
+			},
+		},
+		"unauthorized": {
+			Input:       "{server}/auth?dest={dir}/out.json",
+			ExpectedErr: errors.New("page 1: 401 Unauthorized unauthorized"),
+		},
+		"retry": {
+			Input: "{server}/flaky?dest={dir}/out.json&retry_wait=1ms",
+			Expected: output{
+				Msg:   "1 pages with 1 rows fetched to {dir}/out.json",
+				Lines: []string{`{"id":1}`},
+				Meta:  meta(1, 1),
+			},
+		},
+		"retries exceeded": {
+			Input:       "{server}/flaky?dest={dir}/out.json&retries=1&retry_wait=1ms",
+			ExpectedErr: errors.New("page 1: 503 Service Unavailable try again"),
+		},
+		"server error": {
+			Input:       "{server}/down?dest={dir}/out.json&retries=2&retry_wait=1ms",
+			ExpectedErr: errors.New("page 1: 502 Bad Gateway down"),
+		},
+		"invalid writer": {
+			Input:       "{server}/link?dest=nop://init_err",
+			ExpectedErr: errors.New("writer"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestRetryAfter(t *testing.T) {
+	fn := func(v string) (time.Duration, error) {
+		h := http.Header{}
+		if v != "" {
+			h.Set("Retry-After", v)
+		}
+		return retryAfter(h), nil
+	}
+	cases := trial.Cases[string, time.Duration]{
+		"none": {
+			Input:    "",
+			Expected: 0,
+		},
+		"seconds": {
+			Input:    "10",
+			Expected: 10 * time.Second,
+		},
+		"capped": {
+			Input:    "86400",
+			Expected: maxRetryAfter,
+		},
+		"capped date": {
+			Input:    time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			Expected: maxRetryAfter,
+		},
+		"invalid": {
+			Input:    "soon",
+			Expected: 0,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestNextLink(t *testing.T) {
+	fn := func(links []string) (string, error) {
+		return nextLink(links), nil
+	}
+	cases := trial.Cases[[]string, string]{
+		"next and last": {
+			Input:    []string{`<https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=5>; rel="last"`},
+			Expected: "https://api.example.com/items?page=2",
+		},
+		"multiple headers": {
+			Input:    []string{`</items?page=1>; rel="prev"`, `</items?page=3>; rel=next`},
+			Expected: "/items?page=3",
+		},
+		"multiple rels": {
+			Input:    []string{`</items?page=3>; title="more"; rel="next last"`},
+			Expected: "/items?page=3",
+		},
+		"no next": {
+			Input:    []string{`</items?page=1>; rel="prev"`},
+			Expected: "",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestExtractRecords(t *testing.T) {
+	type input struct {
+		body string
+		path string
+	}
+	fn := func(in input) ([]string, error) {
+		records, err := extractRecords([]byte(in.body), in.path)
+		var s []string
+		for _, r := range records {
+			s = append(s, string(r))
+		}
+		return s, err
+	}
+	cases := trial.Cases[input, []string]{
+		"array": {
+			Input:    input{body: `[{"a": 1}, {"a": 2}]`},
+			Expected: []string{`{"a":1}`, `{"a":2}`},
+		},
+		"nested": {
+			Input:    input{body: `{"data": {"items": [{"a": 1}]}}`, path: "data.items"},
+			Expected: []string{`{"a":1}`},
+		},
+		"array index": {
+			Input:    input{body: `{"data": [{"items": [1, 2]}]}`, path: "data.0.items"},
+			Expected: []string{"1", "2"},
+		},
+		"missing": {
+			Input: input{body: `{"data": {}}`, path: "data.items"},
+		},
+		"null": {
+			Input: input{body: `{"data": null}`, path: "data"},
+		},
+		"invalid": {
+			Input:     input{body: `{"data": [`, path: "data"},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
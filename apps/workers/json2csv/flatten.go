@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parsePath splits a field selector into its path segments.
+//
+//	user.address.zip     -> [user address zip]
+//	$.items[0].sku       -> [items 0 sku]
+func parsePath(field string) []string {
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+	field = strings.NewReplacer("[", ".", "]", "").Replace(field)
+	return strings.Split(field, ".")
+}
+
+// lookup returns the value at the path in the record
+// or nil if it does not exist.
+func lookup(v interface{}, path []string) interface{} {
+	for _, p := range path {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			v = x[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil
+			}
+			v = x[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// leaves adds the path of every non-object value in the record to fields.
+// Arrays are a single value unless they are exploded.
+func leaves(prefix string, m map[string]interface{}, fields map[string]bool) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			leaves(key, sub, fields)
+			continue
+		}
+		fields[key] = true
+	}
+}
+
+// explode creates a record for each value of the array at path.
+// Records without values in the array are returned unchanged.
+func explode(m map[string]interface{}, path []string) []map[string]interface{} {
+	arr, ok := lookup(m, path).([]interface{})
+	if !ok || len(arr) == 0 {
+		return []map[string]interface{}{m}
+	}
+	recs := make([]map[string]interface{}, len(arr))
+	for i, v := range arr {
+		recs[i] = replace(m, path, v)
+	}
+	return recs
+}
+
+// replace returns a copy of m with the value at path set to v.
+// Only the maps along the path are copied.
+func replace(m map[string]interface{}, path []string, v interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, x := range m {
+		c[k] = x
+	}
+	if len(path) == 1 {
+		c[path[0]] = v
+		return c
+	}
+	sub, _ := m[path[0]].(map[string]interface{})
+	c[path[0]] = replace(sub, path[1:], v)
+	return c
+}
+
+// format converts a json value to a csv cell. Arrays are joined with
+// joinSep when set, arrays and objects are otherwise written as json.
+func format(v interface{}, joinSep string) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []interface{}:
+		if joinSep != "" {
+			s := make([]string, len(x))
+			for i, e := range x {
+				s[i] = format(e, joinSep)
+			}
+			return strings.Join(s, joinSep)
+		}
+		b, _ := json.Marshal(x)
+		return string(b)
+	case map[string]interface{}:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]bool) (s []string) {
+	for k := range m {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
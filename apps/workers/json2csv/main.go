@@ -20,8 +20,19 @@ func (o options) Validate() error {
 
 const desc = `json2csv convert a json file to csv with a header 
 
+info params
+ - output: (required) - file path to write the csv file
+ - field: list of fields to write. Nested fields use a path (user.address.zip, $.items[0].sku).
+	When no fields are given the header is discovered from the sample records with nested objects flattened. 
+ - sep: csv delimiter (default ,)
+ - flatten_sep: separator used for nested field names in the header (default .)
+ - sample: number of records used to discover the header (default 100)
+ - explode: array field to write as one row per value, its values are referenced as explode.field
+ - join: join array values with a separator in a single cell. Arrays are written as json by default
+
 Example: 
 {"type":"json2csv","info":"gs://path/to/file.json.gz?output=gs://write/to/path/file.csv&field=f1,f2,f3"}
+{"type":"json2csv","info":"gs://path/to/events.json.gz?output=gs://path/items.csv&field=id,user.address.zip,items.sku&explode=items&join=|"}
 `
 
 func main() {
@@ -6,13 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"sort"
+	"strings"
 
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 func (o *options) NewWorker(info string) task.Worker {
@@ -26,17 +28,24 @@ func (o *options) NewWorker(info string) task.Worker {
 		return task.InvalidWorker("uri %s", err)
 	}
 
-	w.reader, err = file.NewReader(w.File, w.fOpts)
-	if err != nil {
-		return task.InvalidWorker("new reader %s", err)
+	w.Output = tmpl.Parse(w.Output, tmpl.PathTime(w.File))
+	return w
+}
+
+// open creates the reader and writer in spans of the task's trace
+func (w *worker) open(ctx context.Context) (err error) {
+	if w.reader == nil {
+		if w.reader, err = tracing.NewReader(ctx, w.File, w.fOpts); err != nil {
+			return fmt.Errorf("new reader %w", err)
+		}
 	}
-	tm := tmpl.PathTime(w.File)
-	w.Output = tmpl.Parse(w.Output, tm)
-	w.writer, err = file.NewWriter(w.Output, w.fOpts)
-	if err != nil {
-		return task.InvalidWorker("new writer %s", err)
+	if w.writer == nil {
+		if w.writer, err = tracing.NewWriter(ctx, w.Output, w.fOpts); err != nil {
+			w.reader.Close()
+			return fmt.Errorf("new writer %w", err)
+		}
 	}
-	return w
+	return nil
 }
 
 type worker struct {
@@ -46,6 +55,11 @@ type worker struct {
 	Fields []string `uri:"field"`
 	Sep    string   `uri:"sep" default:","`
 
+	FlattenSep string `uri:"flatten_sep"` // separator for nested field names in the header (default .)
+	Explode    string `uri:"explode"`     // array field to write as a row for each value
+	Join       string `uri:"join"`        // join array values with this separator instead of writing json
+	Sample     int    `uri:"sample"`      // records used to discover the header when no fields are given (default 100)
+
 	reader    file.Reader
 	writer    file.Writer
 	fOpts     *file.Options
@@ -53,9 +67,45 @@ type worker struct {
 }
 
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
+	if err := w.open(ctx); err != nil {
+		return task.Failed(err)
+	}
 	writer := csv.NewWriter(w.writer)
 	writer.Comma = rune(w.Sep[0])
 	scanner := file.NewScanner(w.reader)
+
+	sampleSize := w.Sample
+	if sampleSize <= 0 {
+		sampleSize = 100
+	}
+	var explodePath []string
+	if w.Explode != "" {
+		explodePath = parsePath(w.Explode)
+	}
+
+	var sample []map[string]interface{}
+	header := false
+	writeHeader := func() error {
+		if len(w.Fields) == 0 {
+			fields := make(map[string]bool)
+			for _, rec := range sample {
+				leaves("", rec, fields)
+			}
+			w.Fields = sortedKeys(fields)
+		}
+		header = true
+		if err := writer.Write(w.header()); err != nil {
+			return fmt.Errorf("header write %w", err)
+		}
+		for _, rec := range sample {
+			if err := writer.Write(getValues(w.Fields, rec, w.Join)); err != nil {
+				return err
+			}
+		}
+		sample = nil
+		return nil
+	}
+
 	for i := 0; scanner.Scan(); i++ {
 		if task.IsDone(ctx) {
 			return task.Failf("context canceled")
@@ -64,23 +114,35 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
 			return task.Failf("invalid json: %s %s", err, scanner.Text())
 		}
-		// first time
-		if i == 0 {
-			if len(w.Fields) == 0 {
-				w.Fields = getFields(data)
+		recs := []map[string]interface{}{data}
+		if explodePath != nil {
+			recs = explode(data, explodePath)
+		}
+		for _, rec := range recs {
+			// buffer records until the header is known
+			if !header {
+				sample = append(sample, rec)
+				if len(w.Fields) == 0 && len(sample) < sampleSize {
+					continue
+				}
+				if err := writeHeader(); err != nil {
+					return task.Failf("line %d: %s", i, err)
+				}
+				continue
 			}
-			//write header
-			if err := writer.Write(w.Fields); err != nil {
-				return task.Failf("header write %s", err)
+			if err := writer.Write(getValues(w.Fields, rec, w.Join)); err != nil {
+				return task.Failf("line %d: %s", i, err)
 			}
 		}
-		if err := writer.Write(getValues(w.Fields, data)); err != nil {
-			return task.Failf("line %d: %s", i, err)
-		}
 	}
 	if scanner.Err() != nil {
 		return task.Failed(scanner.Err())
 	}
+	if len(sample) > 0 {
+		if err := writeHeader(); err != nil {
+			return task.Failed(err)
+		}
+	}
 	writer.Flush()
 	if err := w.writer.Close(); err != nil {
 		return task.Failf("write close %v", err)
@@ -92,21 +154,37 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 		}
 	}
 	w.SetMeta("file", sts.Path)
+	w.SetMeta(bootstrap.MetaRead, w.reader.Stats().JSONString())
+	w.SetMeta(bootstrap.MetaWrite, sts.JSONString())
 	return task.Completed("%d bytes writen to %s", sts.ByteCnt, sts.Path)
 }
 
-// getFields returns a sorted list of the header found in the map
-func getFields(m map[string]interface{}) (s []string) {
-	for key := range m {
-		s = append(s, key)
+// header returns the column names of the fields with nested
+// names joined by the flatten separator.
+func (w *worker) header() []string {
+	sep := w.FlattenSep
+	if sep == "" {
+		sep = "."
+	}
+	s := make([]string, len(w.Fields))
+	for i, f := range w.Fields {
+		s[i] = strings.Join(parsePath(f), sep)
 	}
-	sort.Strings(s)
 	return s
 }
 
-func getValues(keys []string, m map[string]interface{}) (s []string) {
+// getFields returns a sorted list of the header found in the map,
+// nested objects are flattened into their dot separated paths.
+func getFields(m map[string]interface{}) []string {
+	fields := make(map[string]bool)
+	leaves("", m, fields)
+	return sortedKeys(fields)
+}
+
+// getValues returns the value of each field path in the map
+func getValues(keys []string, m map[string]interface{}, joinSep string) (s []string) {
 	for _, k := range keys {
-		s = append(s, fmt.Sprintf("%v", m[k]))
+		s = append(s, format(lookup(m, parsePath(k)), joinSep))
 	}
 	return s
 }
@@ -52,6 +52,26 @@ func TestOptions_NewWorker(t *testing.T) {
 			Input:       "nop://file.txt",
 			ExpectedErr: errors.New("output is required"),
 		},
+	}
+	cmpfn := func(act, exp interface{}) (bool, string) {
+		v := act.(*worker)
+		v.writer = nil
+		v.reader = nil
+		return trial.Equal(act, exp)
+	}
+	trial.New(fn, cases).Comparer(cmpfn).SubTest(t)
+}
+
+func TestWorker_open(t *testing.T) {
+	fn := func(in string) (string, error) {
+		w := (&options{}).NewWorker(in)
+		r, s := w.DoTask(context.Background())
+		if r == task.ErrResult {
+			return "", errors.New(s)
+		}
+		return s, nil
+	}
+	cases := trial.Cases[string, string]{
 		"invalid input": {
 			Input:       "nop://init_err?output=nop://file.txt",
 			ExpectedErr: errors.New("new reader"),
@@ -61,13 +81,7 @@ func TestOptions_NewWorker(t *testing.T) {
 			ExpectedErr: errors.New("new writer"),
 		},
 	}
-	cmpfn := func(act, exp interface{}) (bool, string) {
-		v := act.(*worker)
-		v.writer = nil
-		v.reader = nil
-		return trial.Equal(act, exp)
-	}
-	trial.New(fn, cases).Comparer(cmpfn).SubTest(t)
+	trial.New(fn, cases).SubTest(t)
 }
 
 func TestWorker_DoTask(t *testing.T) {
@@ -179,8 +193,69 @@ func TestGetFields(t *testing.T) {
 }
 
 func TestGetValues(t *testing.T) {
-	v := getValues([]string{"a", "j", "z"}, map[string]interface{}{"z": 123, "j": "apple", "a": "bcd"})
+	v := getValues([]string{"a", "j", "z"}, map[string]interface{}{"z": 123, "j": "apple", "a": "bcd"}, "")
 	if eq, diff := trial.Equal([]string{"bcd", "apple", "123"}, v); !eq {
 		t.Error(diff)
 	}
 }
+
+func TestWorker_Nested(t *testing.T) {
+	type input struct {
+		lines  []string
+		worker worker
+	}
+	fn := func(in input) ([]string, error) {
+		w := mock.NewWriter("")
+		wkr := in.worker
+		wkr.reader = mock.NewReader("").AddLines(in.lines...)
+		wkr.writer = w
+		wkr.Meta = task.NewMeta()
+		if wkr.Sep == "" {
+			wkr.Sep = ","
+		}
+		if r, s := wkr.DoTask(context.Background()); r == task.ErrResult {
+			return nil, errors.New(s)
+		}
+		return w.GetLines(), nil
+	}
+	event := `{"id":1,"user":{"name":"bob","address":{"zip":"01234"}},"tags":["a","b"],"items":[{"sku":"x1","qty":2},{"sku":"y2","qty":1}]}`
+	cases := trial.Cases[input, []string]{
+		"flatten": {
+			Input: input{lines: []string{event}},
+			Expected: []string{
+				"id,items,tags,user.address.zip,user.name",
+				`1,"[{""qty"":2,""sku"":""x1""},{""qty"":1,""sku"":""y2""}]","[""a"",""b""]",01234,bob`,
+				"",
+			},
+		},
+		"field selectors": {
+			Input: input{
+				lines:  []string{event, `{"id":2}`},
+				worker: worker{Fields: []string{"id", "user.address.zip", "$.items[1].sku", "tags[0]"}, FlattenSep: "_"},
+			},
+			Expected: []string{"id,user_address_zip,items_1_sku,tags_0", "1,01234,y2,a", "2,,,", ""},
+		},
+		"join arrays": {
+			Input: input{
+				lines:  []string{event},
+				worker: worker{Fields: []string{"id", "tags"}, Join: "|"},
+			},
+			Expected: []string{"id,tags", "1,a|b", ""},
+		},
+		"explode": {
+			Input: input{
+				lines:  []string{event, `{"id":2,"items":[]}`},
+				worker: worker{Fields: []string{"id", "items.sku", "items.qty"}, Explode: "items"},
+			},
+			Expected: []string{"id,items.sku,items.qty", "1,x1,2", "1,y2,1", "2,,", ""},
+		},
+		"discover from sample": {
+			Input: input{
+				lines:  []string{`{"a":1}`, `{"b":{"c":2}}`, `{"d":3}`},
+				worker: worker{Sample: 2},
+			},
+			Expected: []string{"a,b.c", "1,", ",2", ",", ""},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -14,8 +14,11 @@ import (
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/rejects"
 	"github.com/pcelvng/task-tools/file/stat"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 func newInfoOptions(info string) (*infoOptions, error) {
@@ -27,30 +30,56 @@ func newInfoOptions(info string) (*infoOptions, error) {
 // infoOptions contains the parsed info values
 // of a task.
 type infoOptions struct {
-	SrcPath       string `uri:"origin"`          // source file path
-	DateField     string `uri:"date-field"`      // json date field, unless sep is provided then must be integer and expecting csv style format records.
-	DateFormat    string `uri:"date-format"`     // expected date format (go time.Time format)
-	Sep           string `uri:"sep"`             // csv separator - must be provided to indicate csv style records
-	DestTemplate  string `uri:"dest-template"`   // template for destination files
-	Discard       bool   `uri:"discard"`         // discard the record on error or end the task with an error
-	UseFileBuffer bool   `uri:"use-file-buffer"` // directs the writer to use a file buffer instead of in-memory
+	SrcPath        string `uri:"origin"`          // source file path
+	DateField      string `uri:"date-field"`      // json date field, unless sep is provided then must be integer and expecting csv style format records.
+	DateFormat     string `uri:"date-format"`     // expected date format (go time.Time format)
+	Granularity    string `uri:"granularity"`     // truncate the record date to the minute, hour, day or month
+	PartitionField string `uri:"partition-field"` // json field (or csv field index) to partition records by
+	MaxOpen        int    `uri:"max-open"`        // max number of files written at once, records for other files are spilled to disk
+	Sep            string `uri:"sep"`             // csv separator - must be provided to indicate csv style records
+	DestTemplate   string `uri:"dest-template"`   // template for destination files
+	Discard        bool   `uri:"discard"`         // discard the record on error or end the task with an error
+	Rejects        string `uri:"rejects"`         // write records that do not parse to this file and continue processing
+	UseFileBuffer  bool   `uri:"use-file-buffer"` // directs the writer to use a file buffer instead of in-memory
 }
 
+// date granularity values
+const (
+	granMinute = "minute"
+	granHour   = "hour"
+	granDay    = "day"
+	granMonth  = "month"
+)
+
 // validate populated info options
 func (i *infoOptions) validate() error {
-	// date-field required
-	if len(i.DateField) == 0 {
-		return errors.New(`date-field required`)
+	// date-field or partition-field required
+	if len(i.DateField) == 0 && len(i.PartitionField) == 0 {
+		return errors.New(`date-field or partition-field required`)
 	}
 
-	// date-field index value if sep is present
+	// field index values if sep is present
 	if len(i.Sep) > 0 {
 		// attempt to convert DateField to int
-		var err error
-		_, err = strconv.Atoi(i.DateField)
-		if err != nil {
+		if _, err := strconv.Atoi(i.DateField); i.DateField != "" && err != nil {
 			return errors.New(`date-field must be an integer when using a csv field separator`)
 		}
+		if _, err := strconv.Atoi(i.PartitionField); i.PartitionField != "" && err != nil {
+			return errors.New(`partition-field must be an integer when using a csv field separator`)
+		}
+	}
+
+	switch i.Granularity {
+	case "", granMinute, granHour, granDay, granMonth:
+	default:
+		return fmt.Errorf(`invalid granularity %q (minute, hour, day or month)`, i.Granularity)
+	}
+	if i.Granularity != "" && i.DateField == "" {
+		return errors.New(`granularity requires a date-field`)
+	}
+
+	if i.MaxOpen < 0 {
+		return errors.New(`max-open must not be negative`)
 	}
 
 	// dest-template required
@@ -70,21 +99,33 @@ func (o *options) newWorker(info string) task.Worker {
 		return task.InvalidWorker("%v", err)
 	}
 
-	// date extractor
+	// date and partition key extractors
 	var extractor file.DateExtractor
+	var keyExtractor file.KeyExtractor
 	if len(iOpt.Sep) > 0 { // if using sep then record type is csv
 		dateIndex, _ := strconv.Atoi(iOpt.DateField) // should not return error since validation already figured that out.
-
-		extractor = file.CSVDateExtractor(
-			iOpt.Sep,
-			iOpt.DateFormat,
-			dateIndex,
-		)
+		keyIndex, _ := strconv.Atoi(iOpt.PartitionField)
+
+		if iOpt.DateField != "" {
+			extractor = file.CSVDateExtractor(
+				iOpt.Sep,
+				iOpt.DateFormat,
+				dateIndex,
+			)
+		}
+		if iOpt.PartitionField != "" {
+			keyExtractor = file.CSVKeyExtractor(iOpt.Sep, keyIndex)
+		}
 	} else { // no sep then assume json
-		extractor = file.JSONDateExtractor(
-			iOpt.DateField,
-			iOpt.DateFormat,
-		)
+		if iOpt.DateField != "" {
+			extractor = file.JSONDateExtractor(
+				iOpt.DateField,
+				iOpt.DateFormat,
+			)
+		}
+		if iOpt.PartitionField != "" {
+			keyExtractor = file.JSONKeyExtractor(iOpt.PartitionField)
+		}
 	}
 
 	// all paths (if pth is directory)
@@ -97,54 +138,76 @@ func (o *options) newWorker(info string) task.Worker {
 		fSts = append(fSts, sts)
 	}
 
-	// reader(s)
+	// reader(s) are opened by DoTask
 	stsRdrs := make([]*statsReader, 0)
 	for _, sts := range fSts {
 		if sts.IsDir {
 			continue
 		}
-		sr := &statsReader{sts: &sts}
-		sr.r, err = file.NewReader(sts.Path, &o.Fopt)
-		if err != nil {
-			return task.InvalidWorker("%v", err)
-		}
-		stsRdrs = append(stsRdrs, sr)
+		stsRdrs = append(stsRdrs, &statsReader{sts: &sts})
 	}
 
 	// destination template
 	destTempl := parseTmpl(iOpt.SrcPath, iOpt.DestTemplate)
 
 	// writer
-	w := file.NewWriteByHour(destTempl, &o.Fopt)
+	w := file.NewWriteByKey(destTempl, &o.Fopt, iOpt.MaxOpen)
+
+	// rejects
+	var rw *rejects.Writer
+	if iOpt.Rejects != "" {
+		rw, err = rejects.New(parseTmpl(iOpt.SrcPath, iOpt.Rejects), &o.Fopt)
+		if err != nil {
+			return task.InvalidWorker("rejects: %v", err)
+		}
+	}
 
 	return &worker{
+		Meta:        task.NewMeta(),
+		rejects:     rw,
 		iOpt:        *iOpt,
 		options:     *o,
 		stsRdrs:     stsRdrs,
 		w:           w,
 		extractDate: extractor,
+		extractKey:  keyExtractor,
 	}
 }
 
 type statsReader struct {
 	sts *stat.Stats
-	r   file.Reader
+	r   file.Reader // nil until the file is read
 }
 
 type worker struct {
+	task.Meta
 	iOpt         infoOptions
 	stsRdrs      []*statsReader
-	w            *file.WriteByHour
-	extractDate  file.DateExtractor
-	discardedCnt int64 // number of records discarded
+	w            *file.WriteByKey
+	rejects      *rejects.Writer
+	extractDate  file.DateExtractor // nil when not partitioning by date
+	extractKey   file.KeyExtractor  // nil when not partitioning by field
+	discardedCnt int64              // number of records discarded
 	options
 }
 
 func (wkr *worker) DoTask(ctx context.Context) (task.Result, string) {
+	// files are read and written in spans of the task's trace
+	wkr.w.NewWriter = func(pth string, opt *file.Options) (file.Writer, error) {
+		return tracing.NewWriter(ctx, pth, opt)
+	}
+
 	// read/write loop
 	for _, rdr := range wkr.stsRdrs { // loop through all readers
+		if ctx.Err() != nil {
+			break
+		}
 		sts := rdr.sts
-		r := rdr.r
+		r, err := tracing.NewReader(ctx, sts.Path, &wkr.Fopt)
+		if err != nil {
+			return wkr.abort(err.Error())
+		}
+		rdr.r = r
 
 		for ctx.Err() == nil {
 			ln, err := r.ReadLine()
@@ -152,7 +215,7 @@ func (wkr *worker) DoTask(ctx context.Context) (task.Result, string) {
 				return wkr.abort(fmt.Sprintf("issue at line %v: %v (%v)", r.Stats().LineCnt+1, err.Error(), sts.Path))
 			}
 
-			wErr := wkr.writeLine(ln)
+			wErr := wkr.writeLine(ln, r.Stats().LineCnt, sts.Path)
 			if wErr != nil {
 				return wkr.abort(fmt.Sprintf("issue at line %v: %v (%v)", r.Stats().LineCnt, wErr.Error(), sts.Path))
 			}
@@ -171,21 +234,25 @@ func (wkr *worker) DoTask(ctx context.Context) (task.Result, string) {
 }
 
 // writeLine
-// -extracts date from ln
-// -handles discarding
-// -does WriteByHour write
-func (wkr *worker) writeLine(ln []byte) error {
+// -extracts date and partition key from ln
+// -handles discarding and rejects
+// -does WriteByKey write
+func (wkr *worker) writeLine(ln []byte, lineNum int64, src string) error {
 	if len(ln) == 0 {
 		return nil
 	}
 
-	// extract date
-	t, err := wkr.extractDate(ln)
+	// extract date and key
+	t, key, err := wkr.extract(ln)
 
 	// handle err
+	// Rejects: write to rejects and continue processing
 	// Discard == true: continue processing
 	// Discard == false: halt processing, error
 	if err != nil {
+		if wkr.rejects != nil {
+			return wkr.rejects.Write(lineNum, src, ln, err)
+		}
 		if wkr.iOpt.Discard {
 			wkr.discardedCnt += 1
 			// TODO: add with central logging
@@ -196,16 +263,51 @@ func (wkr *worker) writeLine(ln []byte) error {
 		}
 	}
 
-	return wkr.w.WriteLine(ln, t)
+	return wkr.w.WriteLine(ln, t, key)
+}
+
+// extract returns the truncated record date and
+// partition key of the record.
+func (wkr *worker) extract(ln []byte) (t time.Time, key string, err error) {
+	if wkr.extractDate != nil {
+		if t, err = wkr.extractDate(ln); err != nil {
+			return t, key, err
+		}
+		t = truncate(t, wkr.iOpt.Granularity)
+	}
+	if wkr.extractKey != nil {
+		key, err = wkr.extractKey(ln)
+	}
+	return t, key, err
+}
+
+// truncate rounds t down to the start of the minute, hour, day or month.
+// The time is unchanged without a granularity.
+func truncate(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case granMinute:
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case granHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case granDay:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case granMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
 }
 
 // abort will abort processing by closing the
 // reading and then cleaning up written records.
 func (wkr *worker) abort(msg string) (task.Result, string) {
 	for _, rdr := range wkr.stsRdrs {
-		rdr.r.Close()
+		if rdr.r != nil {
+			rdr.r.Close()
+		}
 	}
 	wkr.w.Abort() // cleanup writes to this point
+	wkr.rejects.Abort()
 
 	return task.ErrResult, msg
 }
@@ -216,25 +318,38 @@ func (wkr *worker) abort(msg string) (task.Result, string) {
 // producer.
 func (wkr *worker) done(ctx context.Context) (task.Result, string) {
 	// close
+	var read, written []string
 	for _, rdr := range wkr.stsRdrs {
 		rdr.r.Close()
+		read = append(read, rdr.r.Stats().JSONString())
 	}
 	err := wkr.w.CloseWithContext(ctx)
 	if err != nil {
 		return task.ErrResult, fmt.Sprint(err.Error())
 	}
+	if err := wkr.rejects.Close(); err != nil {
+		return task.ErrResult, fmt.Sprintf("rejects: %v", err)
+	}
+	wkr.rejects.SetMeta(wkr)
 
 	// publish files stats
 	allSts := wkr.w.Stats()
 	for _, sts := range allSts {
 		if sts.Size > 0 { // only successful files
 			wkr.Producer.Send(wkr.FileTopic, sts.JSONBytes())
+			written = append(written, sts.JSONString())
 		}
 	}
+	wkr.SetMeta(bootstrap.MetaRead, read...)
+	if len(written) > 0 {
+		wkr.SetMeta(bootstrap.MetaWrite, written...)
+	}
 
 	// msg
 	var msg string
-	if wkr.iOpt.Discard {
+	if wkr.rejects != nil {
+		msg = fmt.Sprintf("wrote %v lines over %v files (%v rejected)", wkr.w.LineCnt(), len(allSts), wkr.rejects.Count())
+	} else if wkr.iOpt.Discard {
 		msg = fmt.Sprintf("wrote %v lines over %v files (%v discarded)", wkr.w.LineCnt(), len(allSts), wkr.discardedCnt)
 	} else {
 		msg = fmt.Sprintf("wrote %v lines over %v files", wkr.w.LineCnt(), len(allSts))
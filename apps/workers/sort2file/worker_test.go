@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/hydronica/trial"
+	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
 )
 
@@ -116,6 +121,49 @@ func TestDoTaskJSON(t *testing.T) {
 		os.Remove("./test/18.json")
 		os.Remove("./test")
 	})
+
+	t.Run("bad record rejects mode", func(t *testing.T) {
+		opts := options{}
+		opts.Producer, _ = bus.NewProducer(bus.NewOptions("nop"))
+
+		// write file
+		w, _ := file.NewWriter("./test/test.json", nil)
+		w.WriteLine([]byte(`{"dateField":"2007-02-03T16:05:06Z"}`))
+		w.WriteLine([]byte(`{"badField":"2007-02-03T18:05:06Z"}`))
+		w.WriteLine([]byte(`{"dateField":"2007-02-03T17:05:06Z"}`))
+		w.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		info := `./test/test.json?date-field=dateField&dest-template=./test/{HH}.json&rejects=./test/rejects.json`
+		wkr := opts.newWorker(info)
+		result, msg := wkr.DoTask(ctx)
+
+		if result != "complete" {
+			t.Errorf("expected result 'complete', got '%s'", result)
+		}
+		if msg != "wrote 2 lines over 2 files (1 rejected)" {
+			t.Errorf("expected msg 'wrote 2 lines over 2 files (1 rejected)', got '%s'", msg)
+		}
+		meta := wkr.(*worker).GetMeta()
+		if cnt := meta.Get("rejects_count"); cnt != "1" {
+			t.Errorf("expected rejects_count 1, got '%s'", cnt)
+		}
+		if len(meta[bootstrap.MetaRead]) != 1 || len(meta[bootstrap.MetaWrite]) != 2 {
+			t.Errorf("expected 1 read and 2 written stats, got %v %v", meta[bootstrap.MetaRead], meta[bootstrap.MetaWrite])
+		}
+		b, _ := os.ReadFile("./test/rejects.json")
+		if !strings.HasPrefix(string(b), `{"line":2,"source":"./test/test.json","error":`) {
+			t.Errorf("unexpected rejects file %s", b)
+		}
+
+		// cleanup
+		os.Remove("./test/test.json")
+		os.Remove("./test/rejects.json")
+		os.Remove("./test/16.json")
+		os.Remove("./test/17.json")
+		os.Remove("./test")
+	})
 }
 
 func TestDoTaskCSV(t *testing.T) {
@@ -298,3 +346,91 @@ func TestParseTmpl(t *testing.T) {
 		}
 	}
 }
+
+func TestDoTaskPartition(t *testing.T) {
+	data := `{"customer":"a","date":"2007-02-03T16:05:06Z"}
+{"customer":"b","date":"2007-02-03T17:05:06Z"}
+{"customer":"a","date":"2007-02-14T16:15:06Z"}
+{"customer":"c","date":"2007-03-01T00:00:00Z"}
+{"customer":"b","date":"2007-02-03T16:25:06Z"}`
+	fn := func(params string) (map[string]string, error) {
+		dir := t.TempDir()
+		if err := os.WriteFile(dir+"/data.json", []byte(data+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		opts := options{}
+		opts.Fopt.FileBufDir = dir
+		opts.Producer, _ = bus.NewProducer(bus.NewOptions("nop"))
+		wkr := opts.newWorker(dir + "/data.json?" + params + "&dest-template=" + dir + "/out/{PARTITION}/{TS}.json")
+		if invalid, msg := task.IsInvalidWorker(wkr); invalid {
+			return nil, errors.New(msg)
+		}
+		if result, msg := wkr.DoTask(context.Background()); result != task.CompleteResult {
+			return nil, errors.New(msg)
+		}
+		files := map[string]string{}
+		for _, sts := range wkr.(*worker).w.Stats() {
+			b, err := os.ReadFile(sts.Path)
+			if err != nil {
+				return nil, err
+			}
+			files[strings.TrimPrefix(sts.Path, dir+"/out/")] = string(b)
+		}
+		return files, nil
+	}
+	lines := strings.Split(data, "\n")
+	cases := trial.Cases[string, map[string]string]{
+		"field": {
+			Input: "partition-field=customer",
+			Expected: map[string]string{
+				"a/{TS}.json": lines[0] + "\n" + lines[2] + "\n",
+				"b/{TS}.json": lines[1] + "\n" + lines[4] + "\n",
+				"c/{TS}.json": lines[3] + "\n",
+			},
+		},
+		"field and month": {
+			Input: "partition-field=customer&date-field=date&granularity=month",
+			Expected: map[string]string{
+				"a/20070201T000000.json": lines[0] + "\n" + lines[2] + "\n",
+				"b/20070201T000000.json": lines[1] + "\n" + lines[4] + "\n",
+				"c/20070301T000000.json": lines[3] + "\n",
+			},
+		},
+		"day": {
+			Input: "date-field=date&granularity=day&partition-field=customer&max-open=1",
+			Expected: map[string]string{
+				"a/20070203T000000.json": lines[0] + "\n",
+				"a/20070214T000000.json": lines[2] + "\n",
+				"b/20070203T000000.json": lines[1] + "\n" + lines[4] + "\n",
+				"c/20070301T000000.json": lines[3] + "\n",
+			},
+		},
+		"minute": {
+			Input: "date-field=date&granularity=minute&partition-field=customer&max-open=2",
+			Expected: map[string]string{
+				"a/20070203T160500.json": lines[0] + "\n",
+				"a/20070214T161500.json": lines[2] + "\n",
+				"b/20070203T170500.json": lines[1] + "\n",
+				"b/20070203T162500.json": lines[4] + "\n",
+				"c/20070301T000000.json": lines[3] + "\n",
+			},
+		},
+		"missing field": {
+			Input:     "partition-field=id",
+			ShouldErr: true,
+		},
+		"invalid granularity": {
+			Input:     "date-field=date&granularity=week",
+			ShouldErr: true,
+		},
+		"granularity without date": {
+			Input:     "partition-field=customer&granularity=day",
+			ShouldErr: true,
+		},
+		"no fields": {
+			Input:     "max-open=2",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -14,19 +14,21 @@ import (
 )
 
 type options struct {
-	Postgres bootstrap.DBOptions `toml:"postgres"`
-	MySQL    bootstrap.DBOptions `toml:"mysql"`
+	Postgres   bootstrap.DBOptions `toml:"postgres"`
+	MySQL      bootstrap.DBOptions `toml:"mysql"`
+	SQLite     bootstrap.DBOptions `toml:"sqlite" comment:"dbname is the path to the sqlite database file"`
+	ClickHouse bootstrap.DBOptions `toml:"clickhouse"`
 
 	sqlDB *sql.DB
 
 	FOpts    *file.Options `toml:"file"`
-	dbDriver string        // postgres, mysql - for the batchloader
+	dbDriver string        // postgres, mysql, sqlite, clickhouse - for the batchloader
 }
 
 var (
 	taskType    = "sql_load"
 	description = `is a generic worker to load a newline delimited json into a sql databse. 
-Initially only postgresql will be supported, but later support can be added for mysql, etc...
+Supports postgresql, mysql, sqlite and clickhouse databases.
 
 info query params:
 file_type: (default:json) a file path i.e., /folder/filename.csv will default to parse delimited data
@@ -41,11 +43,15 @@ delete_sql : allows a place to provide custom where clause to build a custom del
 		- for example unencoded: "t1 >= '2023-01-02T00:00:00' and t1 <= '2023-01-02T23:00:00' and id = 123456"
 		- then url encoded: "?delete_sql=t1%20%3E%3D%20%272023-01-02T00%3A00%3A00%27%20and%20t1%20%3C%3D%20%272023-01-02T23%3A00%3A00%27%20and%20id%20%3D%20123456%20"
 truncate: allows insert into pre-existing table by truncating before insertion
+unsafe_delete: allow delete, delete_sql and truncate with clickhouse. They run before the insert
+    and are not rolled back, so a failed load leaves the rows removed.
 fields : allows mapping different json key values to different database column names
     - provide a list of field name mapping {DB column name}:{json key name} to be mapped 
     - ?fields=dbColumnName:jsonkey
-cached_insert: improves insert times by caching data into a temp table
+cached_insert: improves insert times by caching data into a temp table (postgres only)
 batch_size: (default:10000) number of rows to insert at a time (higher number increases memory usage) 
+skip_err: skip bad records instead of failing the task
+rejects: file path to write bad records to (with the line number and error), the records are skipped
 Example task:
  
 {"type":"sql_load","info":"gs://bucket/path/to/file.json?table=schema.table_name&delete=date:2020-07-01|id:7"}
@@ -77,6 +83,24 @@ func main() {
 		}
 	}
 
+	if opts.SQLite.DBName != "" {
+		opts.dbDriver = "sqlite"
+		opts.sqlDB, err = db.SQLite(opts.SQLite.DBName)
+		if err != nil {
+			log.Fatalf("cannot open SQLite database %s (%s)", opts.SQLite.DBName, err.Error())
+		}
+	}
+
+	if opts.ClickHouse.Host != "" {
+		opts.dbDriver = "clickhouse"
+		o := opts.ClickHouse
+		opts.sqlDB, err = db.ClickHouse(o.Username, o.Password, o.Host, o.DBName)
+		if err != nil {
+			opts.ClickHouse.Password = "secret"
+			log.Fatalf("cannot connect to ClickHouse Instance %+v (%s)", opts.ClickHouse, err.Error())
+		}
+	}
+
 	if opts.Postgres.Host != "" {
 		opts.dbDriver = "postgres"
 		o := opts.Postgres
@@ -95,8 +119,8 @@ func main() {
 }
 
 func (o *options) Validate() error {
-	if o.MySQL.Host == "" && o.Postgres.Host == "" {
-		return errors.New("host is required for at least one DB connection (mysql or postgresql)")
+	if o.MySQL.Host == "" && o.Postgres.Host == "" && o.ClickHouse.Host == "" && o.SQLite.DBName == "" {
+		return errors.New("host is required for at least one DB connection (mysql, postgresql or clickhouse) or a sqlite dbname")
 	}
 	return nil
 }
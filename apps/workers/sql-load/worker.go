@@ -21,18 +21,24 @@ import (
 	"github.com/jbsmith7741/uri"
 	"github.com/pcelvng/task"
 
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/db"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/rejects"
+	"github.com/pcelvng/task-tools/tmpl"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 type InfoURI struct {
 	FilePath     string            `uri:"origin"`                     // file path to load one file or a list of files in that path (not recursive)
 	Table        string            `uri:"table" required:"true"`      // insert table name i.e., "schema.table_name"
 	SkipErr      bool              `uri:"skip_err"`                   // if bad records are found they are skipped and logged instead of throwing an error
+	Rejects      string            `uri:"rejects"`                    // file path to write bad records to, the records are skipped instead of throwing an error
 	DeleteMap    map[string]string `uri:"delete"`                     // map used to build the delete query statement
 	DeleteSql    string            `uri:"delete_sql"`                 // delete params statement is provided as a string value
 	FieldsMap    map[string]string `uri:"fields"`                     // map json key values to different db names
 	Truncate     bool              `uri:"truncate"`                   // truncate the table rather than delete
+	UnsafeDelete bool              `uri:"unsafe_delete"`              // allow clickhouse deletes that are not rolled back with a failed insert
 	CachedInsert bool              `uri:"cached_insert"`              // this will attempt to load the query data though a temp table (postgres only)
 	BatchSize    int               `uri:"batch_size" default:"10000"` // number of rows to insert at once
 	FileType     string            `uri:"file_type" default:"json"`   // parse csv delimited data instead of json data
@@ -63,6 +69,7 @@ type TableMeta struct {
 	colTypes  []string   // the actual db column types
 	rowCount  int32
 	skipCount int
+	rejects   *rejects.Writer // bad records are written here and skipped when set
 
 	csv       bool // is the dataset for csv data (not json)
 	delimiter rune // csv delimiter value default is comma
@@ -103,32 +110,54 @@ func (o *options) newWorker(info string) task.Worker {
 	}
 
 	w.ds = NewTableMeta(w.Params.FileType == "csv", []rune(w.Params.Delimiter)[0])
-
-	r, err := file.NewGlobReader(w.Params.FilePath, w.FOpts)
-	if err != nil {
-		return task.InvalidWorker("%v", err)
+	if w.Params.Rejects != "" {
+		w.Params.Rejects = tmpl.Parse(w.Params.Rejects, tmpl.PathTime(w.Params.FilePath))
+		rw, err := rejects.New(w.Params.Rejects, w.FOpts)
+		if err != nil {
+			return task.InvalidWorker("rejects: %v", err)
+		}
+		w.ds.rejects = rw
 	}
-	w.fReader = r
 
-	if w.Params.Truncate {
+	switch {
+	case w.Params.Truncate:
 		if len(w.Params.DeleteMap) > 0 || len(w.Params.DeleteSql) > 0 {
 			return task.InvalidWorker("truncate can not be used with delete fields")
 		}
 		w.delQuery = fmt.Sprintf("delete from %s", w.Params.Table)
-		return w
-	}
-
-	if w.Params.DeleteSql != "" {
+		if w.dbDriver == "clickhouse" { // clickhouse deletes require a where clause
+			w.delQuery = fmt.Sprintf("truncate table %s", w.Params.Table)
+		}
+	case w.Params.DeleteSql != "":
 		w.delQuery = CustomDelete(w.Params.DeleteSql, w.Params.Table)
-		return w
+	default:
+		w.delQuery = DeleteQuery(w.Params.DeleteMap, w.Params.Table)
 	}
 
-	w.delQuery = DeleteQuery(w.Params.DeleteMap, w.Params.Table)
+	// clickhouse runs the delete before and outside of the insert transaction
+	// so a failed load leaves the rows removed. It must be accepted with unsafe_delete.
+	if w.delQuery != "" && w.dbDriver == "clickhouse" && !w.Params.UnsafeDelete {
+		return task.InvalidWorker("clickhouse delete and truncate are not rolled back if the load fails (set unsafe_delete to allow)")
+	}
 
 	return w
 }
 
+// open creates the file reader in a span of the task's trace
+func (w *worker) open(ctx context.Context) (err error) {
+	w.fReader, err = tracing.NewGlobReader(ctx, w.Params.FilePath, w.FOpts)
+	return err
+}
+
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
+	// the rejects file is only kept when the load is successful
+	var loaded bool
+	defer func() {
+		if !loaded {
+			w.ds.rejects.Abort()
+		}
+	}()
+
 	// read the table schema to know the types for each column
 	err := w.QuerySchema()
 	if err != nil {
@@ -139,6 +168,9 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 	// read the files for loading, verify columns types
 
 	w.ds.PrepareMeta(w.Params.FieldsMap)
+	if err := w.open(ctx); err != nil {
+		return task.Failed(err)
+	}
 
 	rowChan := make(chan Row, 100)
 	go w.ds.ReadFiles(ctx, w.fReader, rowChan, w.Params.SkipErr)
@@ -182,6 +214,12 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 		}
 
 		if !tableCreated {
+			loaded = true
+			if err := w.ds.rejects.Close(); err != nil {
+				return task.Failf("rejects: %v", err)
+			}
+			w.ds.rejects.SetMeta(w)
+			w.SetMeta(bootstrap.MetaRead, w.fReader.Stats().JSONString())
 			return task.Completed("no data to load for %s", w.Params.Table)
 		}
 
@@ -230,6 +268,9 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 			b.AddRow(row)
 		}
 
+		if w.delQuery != "" && w.dbDriver == "clickhouse" {
+			log.Printf("unsafe_delete: %q is not rolled back if the insert fails", w.delQuery)
+		}
 		b.Delete(w.delQuery)
 		start := time.Now()
 		stats, err := b.Commit(ctx, w.Params.Table, w.ds.colNames...)
@@ -245,9 +286,19 @@ func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
 	w.SetMeta("insert_records", fmt.Sprintf("%d", w.ds.rowCount))
 	w.SetMeta("query_run_time", fmt.Sprintf("%v", gtools.PrintDuration(w.queryRunTime)))
 	w.SetMeta("transaction_attempt", strconv.Itoa(retry))
+	w.SetMeta(bootstrap.MetaRead, w.fReader.Stats().JSONString())
 	if w.Params.SkipErr {
 		w.SetMeta("skipped_rows", strconv.Itoa(w.ds.skipCount))
 	}
+	loaded = true
+	if err := w.ds.rejects.Close(); err != nil {
+		return task.Failf("rejects: %v", err)
+	}
+	w.ds.rejects.SetMeta(w)
+	if n := w.ds.rejects.Count(); n > 0 {
+		return task.Completed("database load completed %s table: %s records: %d rejected: %d",
+			w.dbDriver, w.Params.Table, w.ds.rowCount, n)
+	}
 
 	return task.Completed("database load completed %s table: %s records: %d",
 		w.dbDriver, w.Params.Table, w.ds.rowCount)
@@ -262,7 +313,8 @@ func (w *worker) QuerySchema() (err error) {
  FROM information_schema.columns WHERE table_schema = '%s' AND table_name = '%s'`
 
 	// split the table name into the schema and table name seperately
-	if w.dbDriver == "postgres" {
+	switch w.dbDriver {
+	case "postgres":
 		n := strings.Split(w.Params.Table, ".")
 		if len(n) == 1 {
 			s = "public"
@@ -273,12 +325,27 @@ func (w *worker) QuerySchema() (err error) {
 		} else {
 			return fmt.Errorf("query_schema: cannot parse table name")
 		}
-	}
 
 	// the "schema" is actually the database name in mysql
-	if w.dbDriver == "mysql" {
+	case "mysql":
 		s = w.MySQL.DBName
 		t = w.Params.Table
+
+	// sqlite has no information_schema, the table_info pragma has the same columns
+	case "sqlite":
+		q = `SELECT name, CASE WHEN "notnull" = 1 THEN 'NO' ELSE 'YES' END, lower(type), dflt_value
+ FROM pragma_table_info('%[2]s')`
+		t = w.Params.Table
+
+	// the table may be prefixed with the database name
+	case "clickhouse":
+		q = `SELECT name, if(startsWith(type, 'Nullable'), 'YES', 'NO'), lower(type), nullIf(default_expression, '')
+ FROM system.columns WHERE database = '%s' AND table = '%s' ORDER BY position`
+		s = w.ClickHouse.DBName
+		t = w.Params.Table
+		if n := strings.Split(w.Params.Table, "."); len(n) == 2 {
+			s, t = n[0], n[1]
+		}
 	}
 
 	query := fmt.Sprintf(q, s, t)
@@ -313,7 +380,8 @@ func (w *worker) QuerySchema() (err error) {
 
 	for idx, c := range w.ds.dbSchema {
 		if c.TypeName == "" {
-			if strings.Contains(c.DataType, "char") || strings.Contains(c.DataType, "text") {
+			if strings.Contains(c.DataType, "char") || strings.Contains(c.DataType, "text") ||
+				strings.Contains(c.DataType, "string") {
 				w.ds.dbSchema[idx].TypeName = "string"
 			}
 			if strings.Contains(c.DataType, "int") || strings.Contains(c.DataType, "serial") {
@@ -325,7 +393,7 @@ func (w *worker) QuerySchema() (err error) {
 				strings.Contains(c.DataType, "fixed") || strings.Contains(c.DataType, "float") {
 				w.ds.dbSchema[idx].TypeName = "float"
 			}
-			if strings.Contains(c.DataType, "ARRAY") {
+			if strings.Contains(c.DataType, "ARRAY") || strings.HasPrefix(c.DataType, "array(") {
 				w.ds.dbSchema[idx].TypeName = "array"
 			}
 			if strings.Contains(c.DataType, "json") {
@@ -340,7 +408,7 @@ func (w *worker) QuerySchema() (err error) {
 // it will build the cols and rows for each file
 func (ds *TableMeta) ReadFiles(ctx context.Context, files file.Reader, rowChan chan Row, skipErrors bool) {
 	errChan := make(chan error, 2)
-	dataIn := make(chan []byte, 20)
+	dataIn := make(chan line, 20)
 	var header []string
 	var hBytes []byte
 	var activeThreads int32
@@ -349,14 +417,15 @@ func (ds *TableMeta) ReadFiles(ctx context.Context, files file.Reader, rowChan c
 		activeThreads++
 		go func() { // process row function
 			defer func() { atomic.AddInt32(&activeThreads, -1) }()
-			for b := range dataIn {
+			for ln := range dataIn {
+				b := ln.data
 				if ds.csv { // csv data parsing
 					if bytes.Equal(b, hBytes) {
 						continue // another header row was found skip
 					}
 
 					if row, e := MakeCsvRow(ds.dbSchema, b, header, ds.delimiter); e != nil {
-						errChan <- fmt.Errorf("csv read error %w", e)
+						ds.reject(ln, fmt.Errorf("csv read error %w", e), errChan)
 					} else if row != nil {
 						atomic.AddInt32(&ds.rowCount, 1)
 						rowChan <- row
@@ -364,12 +433,16 @@ func (ds *TableMeta) ReadFiles(ctx context.Context, files file.Reader, rowChan c
 				} else { // json data parsing
 					var j JsonData
 					if e := json.Unmarshal(b, &j); e != nil {
+						if ds.rejects != nil {
+							ds.reject(ln, fmt.Errorf("json unmarshal error %w", e), errChan)
+							continue
+						}
 						errChan <- fmt.Errorf("json unmarshal error %w %q", e, string(b))
 						return
 					}
 
 					if row, err := MakeRow(ds.dbSchema, j); err != nil {
-						errChan <- fmt.Errorf("%w", err)
+						ds.reject(ln, err, errChan)
 					} else if row != nil {
 						atomic.AddInt32(&ds.rowCount, 1)
 						rowChan <- row
@@ -383,8 +456,16 @@ func (ds *TableMeta) ReadFiles(ctx context.Context, files file.Reader, rowChan c
 	// read the lines of the file
 	csvHeader := true // csv header data needs to be set
 	scanner := file.NewScanner(files)
+	// a glob reader reports the file and line number of each line,
+	// other readers count every line read including blank lines
+	glob, _ := files.(interface{ Line() (string, int64) })
 loop:
 	for scanner.Scan() {
+		sts := files.Stats()
+		source, num := sts.Path, sts.LineCnt
+		if glob != nil {
+			source, num = glob.Line()
+		}
 		// read the first data bytes to capture the header for csv data
 		if ds.csv && csvHeader {
 			hBytes = scanner.Bytes()
@@ -411,7 +492,7 @@ loop:
 				break loop
 			}
 		default:
-			dataIn <- scanner.Bytes()
+			dataIn <- line{source: source, num: num, data: scanner.Bytes()}
 		}
 	}
 	if scanner.Err() != nil {
@@ -442,6 +523,25 @@ loop:
 	close(errChan)
 }
 
+// line is a line of data with the file it was read from and its line number in that file
+type line struct {
+	source string
+	num    int64
+	data   []byte
+}
+
+// reject writes the bad line to the rejects file when configured,
+// otherwise the error is sent to errChan.
+func (ds *TableMeta) reject(ln line, err error, errChan chan error) {
+	if ds.rejects == nil {
+		errChan <- err
+		return
+	}
+	if e := ds.rejects.Write(ln.num, ln.source, ln.data, err); e != nil {
+		errChan <- fmt.Errorf("rejects write %w", e)
+	}
+}
+
 func NewTableMeta(csv bool, delim rune) *TableMeta {
 	return &TableMeta{
 		dbSchema:  make([]DbColumn, 0),
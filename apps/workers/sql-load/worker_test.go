@@ -6,13 +6,18 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hydronica/trial"
 	"github.com/pcelvng/task"
+	"github.com/pcelvng/task-tools/bootstrap"
+	"github.com/pcelvng/task-tools/db"
 	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/file/mock"
+	"github.com/pcelvng/task-tools/file/rejects"
+	"github.com/pcelvng/task-tools/file/stat"
 )
 
 func TestDeleteCustom(t *testing.T) {
@@ -347,10 +352,12 @@ func TestNewWorker(t *testing.T) {
 
 		// if the test isn't for a invalid worker set count and params
 		myw := wrkr.(*worker)
-		o.Params = myw.Params
-		if myw.fReader != nil {
-			o.Count = int(myw.fReader.Stats().Files)
+		if err := myw.open(context.Background()); err != nil {
+			return o, err
 		}
+		defer myw.fReader.Close()
+		o.Params = myw.Params
+		o.Count = int(myw.fReader.Stats().Files)
 		o.DeleteStmt = myw.delQuery
 
 		return o, nil
@@ -401,6 +408,26 @@ func TestNewWorker(t *testing.T) {
 				Count:      2,
 			},
 		},
+		"clickhouse_delete": {
+			Input:       input{options: &options{dbDriver: "clickhouse"}, Info: d1 + "?table=db.table_name&delete=id:1"},
+			ExpectedErr: errors.New("set unsafe_delete to allow"),
+		},
+		"clickhouse_unsafe_truncate": {
+			Input: input{options: &options{dbDriver: "clickhouse"}, Info: d1 + "?table=db.table_name&truncate&unsafe_delete"},
+			Expected: output{
+				Params: InfoURI{
+					FilePath:     d1,
+					FileType:     "json",
+					Table:        "db.table_name",
+					Truncate:     true,
+					UnsafeDelete: true,
+					BatchSize:    10000,
+					Delimiter:    ",",
+				},
+				DeleteStmt: "truncate table db.table_name",
+				Count:      2,
+			},
+		},
 	}
 
 	trial.New(fn, cases).Test(t)
@@ -757,3 +784,148 @@ func TestCSVReadFiles(t *testing.T) {
 	}
 	trial.New(fn, cases).Timeout(6 * time.Second).SubTest(t)
 }
+
+func TestDoTask_SQLite(t *testing.T) {
+	dir := t.TempDir()
+	sqlDB, err := db.SQLite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	_, err = sqlDB.Exec(`create table events (id integer not null, name text, amount real)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := filepath.Join(dir, "data.json")
+	fw, _ := file.NewWriter(f, &file.Options{})
+	fw.WriteLine([]byte(`{"id":1,"name":"a","amount":1.5}`))
+	fw.WriteLine([]byte(`{"id":"2","amount":"2.25"}`))
+	fw.WriteLine([]byte(`{"id":3,"name":"c"}`))
+	fw.Close()
+
+	type output struct {
+		Result task.Result
+		Count  int
+		Sum    float64
+	}
+	fn := func(info string) (output, error) {
+		// reset the table with a single existing record
+		if _, err := sqlDB.Exec(`delete from events; insert into events values (9, 'old', 10)`); err != nil {
+			return output{}, err
+		}
+		o := &options{sqlDB: sqlDB, dbDriver: "sqlite"}
+		wrkr := o.newWorker(info)
+		if invalid, msg := task.IsInvalidWorker(wrkr); invalid {
+			return output{}, errors.New(msg)
+		}
+		r, msg := wrkr.DoTask(context.Background())
+		if r == task.ErrResult {
+			return output{}, errors.New(msg)
+		}
+		out := output{Result: r}
+		err := sqlDB.QueryRow("select count(*), coalesce(sum(amount), 0) from events").Scan(&out.Count, &out.Sum)
+		return out, err
+	}
+	cases := trial.Cases[string, output]{
+		"load": {
+			Input:    f + "?table=events",
+			Expected: output{Result: task.CompleteResult, Count: 4, Sum: 13.75},
+		},
+		"truncate": {
+			Input:    f + "?table=events&truncate",
+			Expected: output{Result: task.CompleteResult, Count: 3, Sum: 3.75},
+		},
+		"delete": {
+			Input:    f + "?table=events&delete=name:old",
+			Expected: output{Result: task.CompleteResult, Count: 3, Sum: 3.75},
+		},
+		"missing table": {
+			Input:     f + "?table=missing",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestDoTask_Rejects(t *testing.T) {
+	dir := t.TempDir()
+	sqlDB, err := db.SQLite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	if _, err = sqlDB.Exec(`create table events (id integer not null, name text)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// rejects record the file and line number within that file
+	f1, f2 := filepath.Join(dir, "data-1.json"), filepath.Join(dir, "data-2.json")
+	fw, _ := file.NewWriter(f1, &file.Options{})
+	fw.WriteLine([]byte(`{"id":1,"name":"a"}`))
+	fw.WriteLine([]byte(`{"id":2,"name":`))
+	fw.Close()
+	fw, _ = file.NewWriter(f2, &file.Options{})
+	fw.WriteLine([]byte(`{"name":"c"}`))
+	fw.Close()
+
+	rejectsPath := filepath.Join(dir, "rejects.json")
+	o := &options{sqlDB: sqlDB, dbDriver: "sqlite"}
+	wrkr := o.newWorker(filepath.Join(dir, "data-*.json") + "?table=events&rejects=" + rejectsPath)
+	if invalid, msg := task.IsInvalidWorker(wrkr); invalid {
+		t.Fatal(msg)
+	}
+	if r, msg := wrkr.DoTask(context.Background()); r != task.CompleteResult {
+		t.Fatal(msg)
+	}
+
+	var count int
+	if err := sqlDB.QueryRow("select count(*) from events").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 record loaded got %d", count)
+	}
+	meta := wrkr.(*worker).GetMeta()
+	if meta.Get("rejects_count") != "2" || meta.Get("rejects") != rejectsPath {
+		t.Errorf("unexpected rejects meta %v", meta)
+	}
+	if sts := stat.NewFromBytes([]byte(meta.Get(bootstrap.MetaRead))); sts.ByteCnt != 49 || sts.Files != 2 {
+		t.Errorf("unexpected read stats %v", sts.JSONString())
+	}
+	b, err := os.ReadFile(rejectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	sort.Strings(lines) // lines are processed concurrently
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"line":1,"source":"`+f2+`",`) ||
+		!strings.HasPrefix(lines[1], `{"line":2,"source":"`+f1+`",`) {
+		t.Errorf("unexpected rejects %q", lines)
+	}
+}
+
+func TestReadFiles_rejectLines(t *testing.T) {
+	rejectsPath := filepath.Join(t.TempDir(), "rejects.json")
+	rw, err := rejects.New(rejectsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := TableMeta{
+		dbSchema: []DbColumn{{Name: "id", FieldKey: "id"}},
+		rejects:  rw,
+	}
+	// blank lines are counted in the line number
+	reader := mock.NewReader("nop").AddLines(`{"id":1}`, "", `{bad`)
+	rowChan := make(chan Row)
+	go ds.ReadFiles(context.Background(), reader, rowChan, false)
+	for range rowChan {
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(rejectsPath)
+	if !strings.HasPrefix(string(b), `{"line":3,"error":`) {
+		t.Errorf("unexpected rejects %s", b)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// rowScanner is the subset of sql.Rows used to read query results
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...any) error
+	ColumnTypes() ([]*sql.ColumnType, error)
+	Err() error
+	Close() error
+}
+
+const cursorName = "readx_cursor"
+
+// cursor reads query results from a postgres server-side cursor
+// in batches of size rows so the full result set is never held
+// by the client or the server connection buffers.
+type cursor struct {
+	ctx  context.Context
+	tx   *sql.Tx
+	size int
+
+	rows  *sql.Rows // current batch
+	count int       // rows read from the current batch
+	err   error
+}
+
+// openCursor declares a cursor for query inside of a read only
+// transaction and fetches the first batch of rows.
+func openCursor(ctx context.Context, db *sql.DB, query string, size int, args ...any) (*cursor, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	if _, err := tx.ExecContext(ctx, "DECLARE "+cursorName+" NO SCROLL CURSOR FOR "+query, args...); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("declare cursor: %w", err)
+	}
+	c := &cursor{ctx: ctx, tx: tx, size: size}
+	if err := c.fetch(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cursor) fetch() error {
+	rows, err := c.tx.QueryContext(c.ctx, fmt.Sprintf("FETCH %d FROM %s", c.size, cursorName))
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	c.rows, c.count = rows, 0
+	return nil
+}
+
+// Next advances to the next row fetching the next batch
+// from the cursor when the current one is read.
+func (c *cursor) Next() bool {
+	for c.err == nil {
+		if c.rows.Next() {
+			c.count++
+			return true
+		}
+		if c.err = c.rows.Err(); c.err != nil {
+			return false
+		}
+		c.rows.Close()
+		if c.count < c.size { // last batch
+			return false
+		}
+		c.err = c.fetch()
+	}
+	return false
+}
+
+func (c *cursor) Scan(dest ...any) error { return c.rows.Scan(dest...) }
+
+func (c *cursor) ColumnTypes() ([]*sql.ColumnType, error) { return c.rows.ColumnTypes() }
+
+func (c *cursor) Err() error { return c.err }
+
+// Close closes the current batch and ends the transaction
+// which also closes the cursor.
+func (c *cursor) Close() error {
+	if c.rows != nil {
+		c.rows.Close()
+	}
+	return c.tx.Rollback()
+}
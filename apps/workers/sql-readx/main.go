@@ -6,6 +6,7 @@ import (
 
 	"github.com/jbsmith7741/go-tools/appenderr"
 	"github.com/jmoiron/sqlx"
+	"github.com/pcelvng/task/bus"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -18,13 +19,22 @@ import (
 
 const (
 	taskType = "sql_readx"
-	desc     = `extract data from a mysql or postgres table or execute a command
+	desc     = `extract data from a mysql, postgres, sqlite or clickhouse table or execute a command
 
 info params
  - origin: (alternative to query) - path to a file containing a sql statement
  - query: (instead of file) - statement to execute
  - exec: execute statement instead of running as a query
  - dest: (required for query) - file path to where the file should be written 
+	the file format is based on the extension: .csv, .parquet or json lines (default)
+	{part} is replaced with the file number (001, 002, ...) when the output is split
+ - max_rows: start a new file after max_rows rows (dest must contain {part})
+ - max_bytes: start a new file once a file reaches max_bytes (ie 500MB, dest must contain {part})
+ - fetch_size: read postgres results through a server-side cursor fetch_size rows at a time
+ - watermark: column used for incremental extracts, only rows with a greater value than the 
+	last run are read. The new high-water mark is set in the task meta as watermark ({meta:watermark}).
+	The column must be strictly increasing, later rows with a value equal to the last mark are skipped
+ - state: (required with watermark) - file path where the high-water mark is stored between runs
  - table: (required with field) - table (schema.table) to read from 
  - field: - map of columns of fields. 
 	Query: list of columns to read from and the json field that should be used to write the values. 
@@ -33,6 +43,8 @@ info params
 example 
 {"task":"sql_readx","info":"?dest=./data.json&table=report.impressions&field=id:my_id|date:date"}
 {"task":"sql_readx","info":"./query.sql?dest=./data.json"}
+{"task":"sql_readx","info":"./query.sql?dest=./data/{part}.csv.gz&max_rows=1000000&fetch_size=10000"}
+{"task":"sql_readx","info":"./query.sql?dest=./data.json&watermark=updated_at&state=s3://bucket/state/orders.wm"}
 {"task":"sql_readx","info":"./query.sql?exec&field=date:2020-01-01"}
 `
 )
@@ -40,16 +52,19 @@ example
 type options struct {
 	DBOptions `toml:"sql"`
 
-	FOpts *file.Options `toml:"file"`
-	db    *sqlx.DB
+	FileTopic string        `toml:"file_topic" commented:"true" comment:"topic to publish written file stats (none by default)"`
+	FOpts     *file.Options `toml:"file"`
+	db        *sqlx.DB
 }
 
+var producer, _ = bus.NewProducer(bus.NewOptions("nop"))
+
 type DBOptions struct {
-	Type        string `toml:"type" commented:"true"`
+	Type        string `toml:"type" comment:"mysql, postgres, sqlite or clickhouse" commented:"true"`
 	Username    string `toml:"username" commented:"true"`
 	Password    string `toml:"password" commented:"true"`
 	Host        string `toml:"host" comment:"host can be 'host:port', 'host', 'host:' or ':port'"`
-	DBName      string `toml:"dbname"`
+	DBName      string `toml:"dbname" comment:"database name or file path for sqlite"`
 	SSLMode     string `toml:"sslmode" comment:"default is disable"`
 	SSLCert     string `toml:"sslcert"`
 	SSLKey      string `toml:"sslkey"`
@@ -58,7 +73,7 @@ type DBOptions struct {
 
 func (o *options) Validate() error {
 	errs := appenderr.New()
-	if o.Host == "" {
+	if o.Host == "" && o.Type != "sqlite" {
 		errs.Addf("missing db host")
 	}
 	if o.DBName == "" {
@@ -83,6 +98,14 @@ func (o *options) connectDB() (err error) {
 		} else {
 			o.db, err = db.PGxSSL(o.Username, o.Password, o.Host, o.DBName, o.SSLMode, o.SSLCert, o.SSLKey, o.SSLRootCert)
 		}
+	case "sqlite", "clickhouse":
+		dbOpts := db.Options{
+			Username: o.Username,
+			Password: o.Password,
+			Host:     o.Host,
+			DBName:   o.DBName,
+		}
+		o.db, err = dbOpts.Openx(o.Type)
 	default:
 		return fmt.Errorf("unknown db type %s", o.Type)
 	}
@@ -106,6 +129,9 @@ func main() {
 		Version(tools.Version)
 
 	app.Initialize()
+	if opts.FileTopic != "" {
+		producer = app.NewProducer()
+	}
 
 	// setup database connection
 	if err := opts.connectDB(); err != nil {
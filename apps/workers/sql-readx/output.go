@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// output file formats chosen by the dest file extension
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatParquet = "parquet"
+)
+
+// parquetBatch is the number of rows buffered before they
+// are written as a row group
+const parquetBatch = 10000
+
+// outputFormat returns the file format based on the extension of
+// the destination path, ignoring any compression extension.
+func outputFormat(pth string) string {
+	pth = strings.TrimSuffix(strings.TrimSuffix(pth, ".gz"), ".gzip")
+	switch {
+	case strings.HasSuffix(pth, ".csv"):
+		return formatCSV
+	case strings.HasSuffix(pth, ".parquet"):
+		return formatParquet
+	default:
+		return formatJSON
+	}
+}
+
+// column describes a result column
+type column struct {
+	Name     string       // output field name
+	ScanType reflect.Type // go type reported by the driver (may be nil)
+}
+
+// rowWriter writes query rows to a single file in a specific format
+type rowWriter interface {
+	// WriteRow writes the row values in column order
+	WriteRow(vals []any) error
+
+	// Size is the number of uncompressed bytes written so far
+	// including any buffered rows.
+	Size() int64
+
+	Close() error
+	Abort() error
+	Stats() stat.Stats
+}
+
+// newRowWriter creates a rowWriter for the format on top of a file writer
+func newRowWriter(format string, w file.Writer, cols []column) (rowWriter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVWriter(w, cols)
+	case formatParquet:
+		return newParquetWriter(w, cols)
+	default:
+		return &jsonWriter{Writer: w, cols: cols}, nil
+	}
+}
+
+// jsonWriter writes each row as a json object on its own line
+type jsonWriter struct {
+	file.Writer
+	cols []column
+}
+
+// WriteRow keeps the fields in the column order of the query
+func (w *jsonWriter) WriteRow(vals []any) error {
+	b := []byte{'{'}
+	for i, v := range vals {
+		if s, ok := v.([]byte); ok {
+			v = string(s)
+		}
+		if i > 0 {
+			b = append(b, ',')
+		}
+		k, _ := j.Marshal(w.cols[i].Name)
+		val, err := j.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b = append(append(append(b, k...), ':'), val...)
+	}
+	return w.WriteLine(append(b, '}'))
+}
+
+func (w *jsonWriter) Size() int64 { return w.Stats().ByteCnt }
+
+// csvWriter writes rows as comma separated values after a header line
+type csvWriter struct {
+	file.Writer
+	buf bytes.Buffer
+	csv *csv.Writer
+	rec []string
+}
+
+func newCSVWriter(w file.Writer, cols []column) (*csvWriter, error) {
+	c := &csvWriter{Writer: w, rec: make([]string, len(cols))}
+	c.csv = csv.NewWriter(&c.buf)
+	for i, col := range cols {
+		c.rec[i] = col.Name
+	}
+	return c, c.writeRecord()
+}
+
+func (w *csvWriter) WriteRow(vals []any) error {
+	for i, v := range vals {
+		w.rec[i] = csvValue(v)
+	}
+	return w.writeRecord()
+}
+
+func (w *csvWriter) writeRecord() error {
+	w.buf.Reset()
+	if err := w.csv.Write(w.rec); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.WriteLine(bytes.TrimSuffix(w.buf.Bytes(), []byte("\n")))
+}
+
+func (w *csvWriter) Size() int64 { return w.Stats().ByteCnt }
+
+func csvValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(x)
+	case string:
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// parquetWriter buffers rows into arrow records that are written
+// as parquet row groups.
+type parquetWriter struct {
+	file.Writer
+	fw      *pqarrow.FileWriter
+	builder *array.RecordBuilder
+	rows    int
+	pending int64 // estimated bytes of buffered rows
+}
+
+// allocator is used for the buffered parquet rows
+var allocator memory.Allocator = memory.DefaultAllocator
+
+// noClose prevents the parquet writer from closing the file writer
+// so the file can be closed (or aborted) separately.
+type noClose struct{ io.Writer }
+
+func newParquetWriter(w file.Writer, cols []column) (*parquetWriter, error) {
+	fields := make([]arrow.Field, len(cols))
+	for i, c := range cols {
+		fields[i] = arrow.Field{Name: c.Name, Type: arrowType(c.ScanType), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	fw, err := pqarrow.NewFileWriter(schema, noClose{w}, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, fmt.Errorf("parquet writer %w", err)
+	}
+	return &parquetWriter{
+		Writer:  w,
+		fw:      fw,
+		builder: array.NewRecordBuilder(allocator, schema),
+	}, nil
+}
+
+// arrowType maps the driver scan type to an arrow type,
+// unknown types are written as strings.
+func arrowType(t reflect.Type) arrow.DataType {
+	if t == nil {
+		return arrow.BinaryTypes.String
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}), reflect.TypeOf(sql.NullInt16{}):
+		return arrow.PrimitiveTypes.Int64
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return arrow.PrimitiveTypes.Float64
+	case reflect.TypeOf(sql.NullBool{}):
+		return arrow.FixedWidthTypes.Boolean
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(sql.NullTime{}):
+		return arrow.FixedWidthTypes.Timestamp_us
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return arrow.PrimitiveTypes.Int64
+	case reflect.Float32, reflect.Float64:
+		return arrow.PrimitiveTypes.Float64
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean
+	}
+	return arrow.BinaryTypes.String
+}
+
+func (w *parquetWriter) WriteRow(vals []any) error {
+	for i, v := range vals {
+		if err := appendValue(w.builder.Field(i), v); err != nil {
+			return fmt.Errorf("column %s: %w", w.builder.Schema().Field(i).Name, err)
+		}
+		w.pending += int64(len(csvValue(v)))
+	}
+	w.rows++
+	if w.rows >= parquetBatch {
+		return w.flush()
+	}
+	return nil
+}
+
+// appendValue adds v to the column builder converting it to the column type
+func appendValue(b array.Builder, v any) error {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	switch bldr := b.(type) {
+	case *array.Int64Builder:
+		switch x := v.(type) {
+		case int64:
+			bldr.Append(x)
+		case int:
+			bldr.Append(int64(x))
+		case int32:
+			bldr.Append(int64(x))
+		case int16:
+			bldr.Append(int64(x))
+		case int8:
+			bldr.Append(int64(x))
+		case uint32:
+			bldr.Append(int64(x))
+		case uint16:
+			bldr.Append(int64(x))
+		case uint8:
+			bldr.Append(int64(x))
+		case string:
+			i, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return err
+			}
+			bldr.Append(i)
+		default:
+			return fmt.Errorf("unsupported int value %T", v)
+		}
+	case *array.Float64Builder:
+		switch x := v.(type) {
+		case float64:
+			bldr.Append(x)
+		case float32:
+			bldr.Append(float64(x))
+		case int64:
+			bldr.Append(float64(x))
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return err
+			}
+			bldr.Append(f)
+		default:
+			return fmt.Errorf("unsupported float value %T", v)
+		}
+	case *array.BooleanBuilder:
+		switch x := v.(type) {
+		case bool:
+			bldr.Append(x)
+		case int64:
+			bldr.Append(x != 0)
+		default:
+			return fmt.Errorf("unsupported bool value %T", v)
+		}
+	case *array.TimestampBuilder:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("unsupported timestamp value %T", v)
+		}
+		bldr.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.StringBuilder:
+		bldr.Append(csvValue(v))
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (w *parquetWriter) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+	w.rows, w.pending = 0, 0
+	return w.fw.Write(rec)
+}
+
+func (w *parquetWriter) Size() int64 { return w.Stats().ByteCnt + w.pending }
+
+func (w *parquetWriter) Close() error {
+	defer w.builder.Release()
+	if err := w.flush(); err != nil {
+		w.fw.Close()
+		w.Writer.Abort()
+		return err
+	}
+	if err := w.fw.Close(); err != nil {
+		w.Writer.Abort()
+		return err
+	}
+	return w.Writer.Close()
+}
+
+// Abort releases the buffered rows and closes the parquet
+// writer before the file is aborted.
+func (w *parquetWriter) Abort() error {
+	w.builder.Release()
+	w.fw.Close()
+	return w.Writer.Abort()
+}
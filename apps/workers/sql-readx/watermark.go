@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pcelvng/task-tools/file"
+)
+
+var columnRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// watermark tracks the high-water mark of an incremental extract.
+// The last mark is stored in a state file so the next run only
+// selects rows with a greater value in the watermark column. The
+// column must be strictly increasing, a row added after a run with
+// a value equal to the mark is not read.
+type watermark struct {
+	Column string
+	Path   string // state file path
+	Start  string // high-water mark of the previous run
+
+	max any // highest value read this run
+}
+
+// loadWatermark reads the previous high-water mark from the state file.
+// A missing state file starts a full extract.
+func loadWatermark(column, pth string, opts *file.Options) (*watermark, error) {
+	if !columnRe.MatchString(column) {
+		return nil, fmt.Errorf("invalid watermark column %q", column)
+	}
+	if pth == "" {
+		return nil, fmt.Errorf("state file required for watermark")
+	}
+	wm := &watermark{Column: column, Path: pth}
+	if _, err := file.Stat(pth, opts); err != nil {
+		log.Printf("no watermark state at %s, reading all rows", pth)
+		return wm, nil
+	}
+	r, err := file.NewReader(pth, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	wm.Start = string(bytes.TrimSpace(b))
+	return wm, nil
+}
+
+// Query wraps the query to only select rows after the previous
+// high-water mark. The returned query uses '?' placeholders.
+func (wm *watermark) Query(query string) (string, []any) {
+	if wm.Start == "" {
+		return query, nil
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	q := fmt.Sprintf("select * from (%s) readx_wm where %s > ?", query, wm.Column)
+
+	// bind timestamps as time values so the comparison
+	// does not depend on the database's string format
+	if t, err := time.Parse(time.RFC3339Nano, wm.Start); err == nil {
+		return q, []any{t}
+	}
+	return q, []any{wm.Start}
+}
+
+// Check records v if it is greater than the current high-water mark
+func (wm *watermark) Check(v any) {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if v == nil {
+		return
+	}
+	if wm.max == nil || greater(v, wm.max) {
+		wm.max = v
+	}
+}
+
+// Value is the new high-water mark or the previous mark
+// if no rows were read.
+func (wm *watermark) Value() string {
+	if wm.max == nil {
+		return wm.Start
+	}
+	if t, ok := wm.max.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(wm.max)
+}
+
+// Save writes the new high-water mark to the state file.
+// Nothing is written when no rows were read.
+func (wm *watermark) Save(opts *file.Options) error {
+	if wm.max == nil {
+		return nil
+	}
+	w, err := file.NewWriter(wm.Path, opts)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteLine([]byte(wm.Value())); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// greater compares values of the same type, numbers (including numeric
+// strings from drivers that return numbers as text) and falls back
+// to comparing the string values.
+func greater(a, b any) bool {
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return x > y
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x > y
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.After(y)
+		}
+	}
+	if x, ok := numeric(a); ok {
+		if y, ok := numeric(b); ok {
+			return x.Cmp(y) > 0
+		}
+	}
+	return fmt.Sprint(a) > fmt.Sprint(b)
+}
+
+// numeric converts number and numeric string values
+func numeric(v any) (*big.Float, bool) {
+	f := new(big.Float).SetPrec(128)
+	switch x := v.(type) {
+	case string:
+		if _, ok := f.SetString(strings.TrimSpace(x)); !ok {
+			return nil, false
+		}
+		return f, true
+	case float32, float64:
+		return f.SetFloat64(reflect.ValueOf(x).Float()), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f.SetUint64(rv.Uint()), true
+	}
+	return nil, false
+}
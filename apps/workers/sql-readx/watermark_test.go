@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydronica/trial"
+	"github.com/jmoiron/sqlx"
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/db"
+	"github.com/pcelvng/task-tools/file"
+)
+
+func TestWatermark_Query(t *testing.T) {
+	type output struct {
+		Query string
+		Args  []any
+	}
+	fn := func(start string) (output, error) {
+		wm := &watermark{Column: "updated", Start: start}
+		q, args := wm.Query("select * from t;")
+		return output{Query: q, Args: args}, nil
+	}
+	cases := trial.Cases[string, output]{
+		"first run": {
+			Input:    "",
+			Expected: output{Query: "select * from t;"},
+		},
+		"number": {
+			Input:    "10",
+			Expected: output{Query: "select * from (select * from t) readx_wm where updated > ?", Args: []any{"10"}},
+		},
+		"timestamp": {
+			Input: "2023-05-24T01:00:00Z",
+			Expected: output{Query: "select * from (select * from t) readx_wm where updated > ?",
+				Args: []any{trial.TimeDay("2023-05-24").Add(time.Hour)}},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWatermark_Check(t *testing.T) {
+	fn := func(vals []any) (string, error) {
+		wm := &watermark{Start: "0"}
+		for _, v := range vals {
+			wm.Check(v)
+		}
+		return wm.Value(), nil
+	}
+	cases := trial.Cases[[]any, string]{
+		"no rows": {
+			Input:    []any{},
+			Expected: "0",
+		},
+		"int64": {
+			Input:    []any{int64(9), int64(10), nil, int64(2)},
+			Expected: "10",
+		},
+		"numeric bytes": {
+			Input:    []any{[]byte("9"), []byte("10"), []byte("100"), []byte("99")},
+			Expected: "100",
+		},
+		"decimal bytes": {
+			Input:    []any{[]byte("9.5"), []byte("10.25"), []byte("10.3")},
+			Expected: "10.3",
+		},
+		"text": {
+			Input:    []any{"b", "ab", "c"},
+			Expected: "c",
+		},
+		"timestamp": {
+			Input:    []any{trial.TimeDay("2024-01-02"), trial.TimeDay("2024-01-10"), trial.TimeDay("2024-01-09")},
+			Expected: "2024-01-10T00:00:00Z",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWorker_Watermark(t *testing.T) {
+	conn, err := db.SQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`create table events (id integer, name text);
+insert into events values (1, 'a'), (3, 'b'), (2, 'c');`); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	state := filepath.Join(dir, "events.wm")
+	opts := &options{db: sqlx.NewDb(conn, "sqlite"), FOpts: file.NewOptions()}
+	info := "?query=select id, name from events&watermark=id&state=" + state + "&dest=" + dir + "/data.json"
+
+	run := func() (string, []string, error) {
+		w := opts.NewWorker(info)
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return "", nil, errors.New(s)
+		}
+		if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+			return "", nil, errors.New(s)
+		}
+		b, err := os.ReadFile(dir + "/data.json")
+		if err != nil {
+			return "", nil, err
+		}
+		return w.(*worker).GetMeta().Get("watermark"), strings.Fields(string(b)), nil
+	}
+	steps := []struct {
+		insert    string
+		watermark string
+		lines     []string
+	}{
+		{ // first run without state reads all rows
+			watermark: "3",
+			lines:     []string{`{"id":1,"name":"a"}`, `{"id":3,"name":"b"}`, `{"id":2,"name":"c"}`},
+		},
+		{
+			insert:    "insert into events values (4, 'd'), (5, 'e')",
+			watermark: "5",
+			lines:     []string{`{"id":4,"name":"d"}`, `{"id":5,"name":"e"}`},
+		},
+		{ // no new rows keeps the previous mark
+			watermark: "5",
+		},
+	}
+	for i, s := range steps {
+		if s.insert != "" {
+			if _, err := conn.Exec(s.insert); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wm, lines, err := run()
+		if err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		if wm != s.watermark {
+			t.Errorf("step %d: watermark %q != %q", i, wm, s.watermark)
+		}
+		if eq, diff := trial.Equal(lines, s.lines); !eq && len(lines)+len(s.lines) > 0 {
+			t.Errorf("step %d: %s", i, diff)
+		}
+		if b, _ := os.ReadFile(state); strings.TrimSpace(string(b)) != s.watermark {
+			t.Errorf("step %d: state %q != %q", i, b, s.watermark)
+		}
+	}
+}
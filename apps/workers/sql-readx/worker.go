@@ -11,21 +11,35 @@ import (
 
 	"github.com/dustin/go-humanize"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/inhies/go-bytesize"
 	"github.com/jbsmith7741/uri"
 	"github.com/jmoiron/sqlx"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pcelvng/task"
+	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 type worker struct {
 	task.Meta
 
 	db     *sqlx.DB
-	writer file.Writer
+	writer file.Writer // first output file
+	fOpts  *file.Options
+
+	dest      string // destination path that may contain a {part} token
+	format    string
+	fetchSize int
+	maxRows   int64
+	maxBytes  int64
+	fileTopic string
+	wm        *watermark // incremental extract state
 
 	Fields FieldMap
 	Query  string
+	args   []any
 }
 
 type executer struct {
@@ -47,6 +61,11 @@ func (o *options) NewWorker(info string) task.Worker {
 		Query       string            `uri:"query"`
 		Fields      map[string]string `uri:"field"`
 		Destination string            `uri:"dest"`
+		FetchSize   int               `uri:"fetch_size"`
+		MaxRows     int64             `uri:"max_rows"`
+		MaxBytes    string            `uri:"max_bytes"`
+		Watermark   string            `uri:"watermark"` // column used for incremental extracts
+		State       string            `uri:"state"`     // path to the watermark state file
 	}{}
 	if err := uri.Unmarshal(info, &iOpts); err != nil {
 		return task.InvalidWorker("%v", err)
@@ -75,6 +94,9 @@ func (o *options) NewWorker(info string) task.Worker {
 	}
 
 	if iOpts.Exec {
+		if iOpts.Watermark != "" {
+			return task.InvalidWorker("watermark not supported with exec")
+		}
 		if query == "" {
 			return task.InvalidWorker("query in url or path required")
 		}
@@ -106,20 +128,55 @@ func (o *options) NewWorker(info string) task.Worker {
 		return task.InvalidWorker("query path or field params required")
 	}
 
-	w, err := file.NewWriter(iOpts.Destination, o.FOpts)
-	if err != nil {
-		return task.InvalidWorker("writer: %s", err)
+	var wm *watermark
+	var args []any
+	if iOpts.Watermark != "" {
+		var err error
+		if wm, err = loadWatermark(iOpts.Watermark, iOpts.State, o.FOpts); err != nil {
+			return task.InvalidWorker("watermark: %s", err)
+		}
+		query, args = wm.Query(query)
+		query = o.db.Rebind(query)
+	}
+
+	var maxBytes int64
+	if iOpts.MaxBytes != "" {
+		b, err := bytesize.Parse(iOpts.MaxBytes)
+		if err != nil {
+			return task.InvalidWorker("max_bytes: %s", err)
+		}
+		maxBytes = int64(b)
+	}
+	if (iOpts.MaxRows > 0 || maxBytes > 0) && !strings.Contains(iOpts.Destination, partToken) {
+		return task.InvalidWorker("dest requires %s when max_rows or max_bytes is set", partToken)
 	}
 
 	return &worker{
-		Meta:   task.NewMeta(),
-		db:     o.db,
-		Fields: iOpts.Fields,
-		Query:  query,
-		writer: w,
+		Meta:      task.NewMeta(),
+		db:        o.db,
+		Fields:    iOpts.Fields,
+		Query:     query,
+		fOpts:     o.FOpts,
+		dest:      iOpts.Destination,
+		format:    outputFormat(iOpts.Destination),
+		fetchSize: iOpts.FetchSize,
+		maxRows:   iOpts.MaxRows,
+		maxBytes:  maxBytes,
+		fileTopic: o.FileTopic,
+		wm:        wm,
+		args:      args,
 	}
 }
 
+// partToken is replaced with the file number in the destination path
+// when the output is split into multiple files.
+const partToken = "{part}"
+
+// partPath returns the destination path for the nth output file
+func partPath(dest string, n int) string {
+	return strings.Replace(dest, partToken, fmt.Sprintf("%03d", n), -1)
+}
+
 func (w *executer) DoTask(ctx context.Context) (task.Result, string) {
 	log.Println(w.Query)
 	tx, err := w.db.BeginTx(ctx, nil)
@@ -149,60 +206,161 @@ func (w *executer) DoTask(ctx context.Context) (task.Result, string) {
 }
 
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
-	// pull Data from mysql database
-	rows, err := w.db.QueryxContext(ctx, w.Query)
+	// output files are written in spans of the task's trace
+	if w.writer == nil {
+		var err error
+		if w.writer, err = tracing.NewWriter(ctx, partPath(w.dest, 1), w.fOpts); err != nil {
+			return task.Failf("writer: %s", err)
+		}
+	}
+	rows, err := w.query(ctx)
+	if err != nil {
+		w.writer.Abort()
+		return task.Failed(err)
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		w.writer.Abort()
+		return task.Failf("columns %s", err)
+	}
+	cols := make([]column, len(colTypes))
+	wmIdx := -1
+	for i, c := range colTypes {
+		cols[i] = column{Name: w.Fields.name(c.Name()), ScanType: c.ScanType()}
+		if w.wm != nil && c.Name() == w.wm.Column {
+			wmIdx = i
+		}
+	}
+	if w.wm != nil && wmIdx == -1 {
+		w.writer.Abort()
+		return task.Failf("watermark column %s not in query results", w.wm.Column)
+	}
+
+	out, err := newRowWriter(w.format, w.writer, cols)
 	if err != nil {
+		w.writer.Abort()
 		return task.Failed(err)
 	}
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	var files []stat.Stats
+	var total, partRows int64
 	for rows.Next() {
 		if task.IsDone(ctx) {
-			w.writer.Abort()
+			out.Abort()
 			return task.Interrupted()
 		}
-		row := make(map[string]interface{})
-		if err := rows.MapScan(row); err != nil {
-			return task.Failf("mapscan %s", err)
+		if err := rows.Scan(ptrs...); err != nil {
+			out.Abort()
+			return task.Failf("scan %s", err)
 		}
 
-		r := w.Fields.convertRow(row)
-		b, err := j.Marshal(r)
-		if err != nil {
-			return task.Failed(err)
+		// start a new file once the current one is full
+		if (w.maxRows > 0 && partRows >= w.maxRows) || (w.maxBytes > 0 && out.Size() >= w.maxBytes) {
+			sts, err := w.closePart(out)
+			if err != nil {
+				return task.Failed(err)
+			}
+			files = append(files, sts)
+
+			fw, err := tracing.NewWriter(ctx, partPath(w.dest, len(files)+1), w.fOpts)
+			if err != nil {
+				return task.Failf("writer: %s", err)
+			}
+			if out, err = newRowWriter(w.format, fw, cols); err != nil {
+				fw.Abort()
+				return task.Failed(err)
+			}
+			partRows = 0
 		}
-		if err := w.writer.WriteLine(b); err != nil {
+
+		if err := out.WriteRow(vals); err != nil {
+			out.Abort()
 			return task.Failed(err)
 		}
+		if wmIdx >= 0 {
+			w.wm.Check(vals[wmIdx])
+		}
+		partRows++
+		total++
 	}
-	if err := rows.Close(); err != nil {
+	if err := rows.Err(); err != nil {
+		out.Abort()
 		return task.Failed(err)
 	}
 
-	// write to file
-	if err := w.writer.Close(); err != nil {
+	sts, err := w.closePart(out)
+	if err != nil {
 		return task.Failed(err)
 	}
+	files = append(files, sts)
+
+	var size int64
+	paths := make([]string, len(files))
+	written := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+		written[i] = f.JSONString()
+		size += f.ByteCnt
+	}
+	w.SetMeta("file", paths...)
+	w.SetMeta(bootstrap.MetaWrite, written...)
 
-	sts := w.writer.Stats()
-	w.SetMeta("file", sts.Path)
+	if w.wm != nil {
+		if err := w.wm.Save(w.fOpts); err != nil {
+			return task.Failf("watermark state: %s", err)
+		}
+		w.SetMeta("watermark", w.wm.Value())
+	}
+	w.publish(files)
 
-	return task.Completed("%d rows written to %s (%s)", sts.LineCnt, sts.Path, humanize.Bytes(uint64(sts.ByteCnt)))
+	if len(files) == 1 {
+		return task.Completed("%d rows written to %s (%s)", total, sts.Path, humanize.Bytes(uint64(size)))
+	}
+	return task.Completed("%d rows written to %d files (%s)", total, len(files), humanize.Bytes(uint64(size)))
 }
 
-func (m FieldMap) convertRow(data map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for key, value := range data {
-		name := m[key]
-		if name == "" {
-			name = key
-		}
-		switch v := value.(type) {
-		case []byte:
-			s := string(v)
-			result[name] = s
-		default:
-			result[name] = value
+// closePart closes the output file and returns its stats
+func (w *worker) closePart(out rowWriter) (stat.Stats, error) {
+	if err := out.Close(); err != nil {
+		return stat.Stats{}, err
+	}
+	return out.Stats(), nil
+}
+
+// publish sends the stats of the written files to the file topic,
+// files are only published once the task is successful.
+func (w *worker) publish(files []stat.Stats) {
+	if w.fileTopic == "" {
+		return
+	}
+	for _, sts := range files {
+		if err := producer.Send(w.fileTopic, sts.JSONBytes()); err != nil {
+			log.Printf("publish %s: %s", sts.Path, err)
 		}
 	}
+}
 
-	return result
+// query runs the worker query. Postgres results are read through a
+// server-side cursor when a fetch size is set, other drivers stream
+// the results as they are read.
+func (w *worker) query(ctx context.Context) (rowScanner, error) {
+	if w.fetchSize > 0 && w.db.DriverName() == "postgres" {
+		return openCursor(ctx, w.db.DB, w.Query, w.fetchSize, w.args...)
+	}
+	return w.db.QueryContext(ctx, w.Query, w.args...)
+}
+
+// name returns the output name of the column key
+func (m FieldMap) name(key string) string {
+	if name := m[key]; name != "" {
+		return name
+	}
+	return key
 }
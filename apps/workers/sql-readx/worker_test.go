@@ -5,15 +5,28 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	pqfile "github.com/apache/arrow/go/v15/parquet/file"
 	"github.com/hydronica/trial"
 	"github.com/jbsmith7741/go-tools/sqlh"
 	"github.com/jmoiron/sqlx"
 	"github.com/pcelvng/task"
+	"github.com/pcelvng/task/bus"
+	"github.com/pcelvng/task/bus/nop"
 
+	"github.com/pcelvng/task-tools/bootstrap"
+	"github.com/pcelvng/task-tools/db"
+	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/file/mock"
 )
 
@@ -60,10 +73,6 @@ func TestNewWorker(t *testing.T) {
 			Input:     "",
 			ShouldErr: true,
 		},
-		"writer err": {
-			Input:       tFile + "?table=schema.table&dest=nop://init_err",
-			ExpectedErr: errors.New("writer: "),
-		},
 		"exec statement": {
 			Input:    "?exec&query=my query",
 			Expected: "my query",
@@ -80,6 +89,18 @@ func TestNewWorker(t *testing.T) {
 			Input:     "?exec",
 			ShouldErr: true,
 		},
+		"watermark with exec": {
+			Input:       "?exec&query=my query&watermark=id&state=nop://",
+			ExpectedErr: errors.New("watermark not supported"),
+		},
+		"invalid watermark": {
+			Input:       "?query=select 1&dest=nop://&watermark=id;drop&state=nop://",
+			ExpectedErr: errors.New("invalid watermark column"),
+		},
+		"watermark without state": {
+			Input:       "?query=select 1&dest=nop://&watermark=id",
+			ExpectedErr: errors.New("state file required"),
+		},
 	}
 	trial.New(fn, cases).Timeout(3 * time.Second).SubTest(t)
 }
@@ -173,3 +194,226 @@ func TestWorker_DoTask(t *testing.T) {
 	trial.New(fn, cases).Test(t)
 
 }
+
+func TestWorker_Parts(t *testing.T) {
+	conn, err := db.SQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`create table fruit (id integer, name text, price real);
+insert into fruit values (1, 'apple', 1.5), (2, 'banana', 0.25), (3, 'cherry', 4), (4, 'date', null), (5, 'fig', 2);`); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options{db: sqlx.NewDb(conn, "sqlite"), FOpts: file.NewOptions()}
+
+	type output struct {
+		Files []string // file name: contents
+		Meta  string
+	}
+	fn := func(info string) (output, error) {
+		dir := t.TempDir()
+		w := opts.NewWorker(strings.Replace(info, "{dir}", dir, -1))
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+			return output{}, errors.New(s)
+		}
+		var out output
+		meta := w.(*worker).GetMeta()
+		files := meta["file"]
+		if len(meta[bootstrap.MetaWrite]) != len(files) {
+			t.Errorf("expected write stats for %d files got %v", len(files), meta[bootstrap.MetaWrite])
+		}
+		for _, f := range files {
+			if strings.HasSuffix(f, ".parquet") {
+				rdr, err := pqfile.OpenParquetFile(f, false)
+				if err != nil {
+					return output{}, err
+				}
+				out.Files = append(out.Files, fmt.Sprintf("%s: %d rows", filepath.Base(f), rdr.NumRows()))
+				rdr.Close()
+				continue
+			}
+			r, err := file.NewReader(f, nil)
+			if err != nil {
+				return output{}, err
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return output{}, err
+			}
+			out.Files = append(out.Files, fmt.Sprintf("%s: %s", filepath.Base(f), b))
+		}
+		out.Meta = strings.Replace(strings.Join(files, ","), dir+"/", "", -1)
+		return out, nil
+	}
+	cases := trial.Cases[string, output]{
+		"single json": {
+			Input: "?query=select id, name from fruit where id < 3&dest={dir}/data.json",
+			Expected: output{
+				Files: []string{`data.json: {"id":1,"name":"apple"}` + "\n" + `{"id":2,"name":"banana"}` + "\n"},
+				Meta:  "data.json",
+			},
+		},
+		"max rows": {
+			Input: "?table=main.fruit&field=id:num&dest={dir}/{part}.json&max_rows=2",
+			Expected: output{
+				Files: []string{
+					"001.json: {\"num\":1}\n{\"num\":2}\n",
+					"002.json: {\"num\":3}\n{\"num\":4}\n",
+					"003.json: {\"num\":5}\n",
+				},
+				Meta: "001.json,002.json,003.json",
+			},
+		},
+		"max bytes csv": {
+			Input: "?query=select id, name, price from fruit&dest={dir}/fruit_{part}.csv&max_bytes=30B",
+			Expected: output{
+				Files: []string{
+					"fruit_001.csv: id,name,price\n1,apple,1.5\n2,banana,0.25\n",
+					"fruit_002.csv: id,name,price\n3,cherry,4\n4,date,\n",
+					"fruit_003.csv: id,name,price\n5,fig,2\n",
+				},
+				Meta: "fruit_001.csv,fruit_002.csv,fruit_003.csv",
+			},
+		},
+		"parquet": {
+			Input: "?query=select * from fruit&dest={dir}/fruit_{part}.parquet&max_rows=3",
+			Expected: output{
+				Files: []string{"fruit_001.parquet: 3 rows", "fruit_002.parquet: 2 rows"},
+				Meta:  "fruit_001.parquet,fruit_002.parquet",
+			},
+		},
+		"no part token": {
+			Input:     "?query=select * from fruit&dest={dir}/data.json&max_rows=2",
+			ShouldErr: true,
+		},
+		"bad max_bytes": {
+			Input:     "?query=select * from fruit&dest={dir}/{part}.json&max_bytes=lots",
+			ShouldErr: true,
+		},
+		"writer err": {
+			Input:       "?query=select * from fruit&dest=nop://init_err",
+			ExpectedErr: errors.New("writer: "),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestCursor(t *testing.T) {
+	conn, mDB, _ := sqlmock.New()
+	mDB.ExpectBegin()
+	mDB.ExpectExec("DECLARE readx_cursor NO SCROLL CURSOR FOR select id from fruit$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mDB.ExpectQuery("FETCH 2 FROM readx_cursor").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mDB.ExpectQuery("FETCH 2 FROM readx_cursor").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mDB.ExpectRollback()
+
+	writer := mock.NewWriter("nop://")
+	w := &worker{
+		Meta:      task.NewMeta(),
+		writer:    writer,
+		db:        sqlx.NewDb(conn, "postgres"),
+		fetchSize: 2,
+		Query:     "select id from fruit;",
+	}
+	if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+		t.Fatal(s)
+	}
+	if eq, diff := trial.Equal(writer.GetLines(), []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}); !eq {
+		t.Error(diff)
+	}
+	if err := mDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWorker_Publish(t *testing.T) {
+	type output struct {
+		Result    task.Result
+		Published int
+	}
+	fn := func(rowErr bool) (output, error) {
+		conn, mDB, _ := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3)
+		if rowErr {
+			rows.RowError(2, errors.New("connection lost"))
+		}
+		mDB.ExpectQuery("select id").WillReturnRows(rows)
+
+		p, _ := bus.NewProducer(bus.NewOptions("nop"))
+		producer = p
+		w := &worker{
+			Meta:      task.NewMeta(),
+			db:        sqlx.NewDb(conn, "sql"),
+			Query:     "select id",
+			dest:      "nop://{part}.json",
+			maxRows:   1,
+			fileTopic: "files",
+		}
+		r, _ := w.DoTask(context.Background())
+		return output{Result: r, Published: len(p.(*nop.Producer).Messages["files"])}, nil
+	}
+	cases := trial.Cases[bool, output]{
+		"success": {
+			Input:    false,
+			Expected: output{Result: task.CompleteResult, Published: 3},
+		},
+		"failed": { // parts that were written are not published
+			Input:    true,
+			Expected: output{Result: task.ErrResult},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestParquetWriter_Abort(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	allocator = mem
+	defer func() { allocator = memory.DefaultAllocator }()
+
+	pth := t.TempDir() + "/data.parquet"
+	fw, err := file.NewWriter(pth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := []column{{Name: "id", ScanType: reflect.TypeOf(int64(0))}, {Name: "name", ScanType: reflect.TypeOf("")}}
+	w, err := newParquetWriter(fw, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow([]any{int64(1), "apple"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	mem.AssertSize(t, 0)
+	if _, err := os.Stat(pth); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed: %v", pth, err)
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	fn := func(v any) (int64, error) {
+		b := array.NewInt64Builder(memory.DefaultAllocator)
+		defer b.Release()
+		if err := appendValue(b, v); err != nil {
+			return 0, err
+		}
+		arr := b.NewInt64Array()
+		defer arr.Release()
+		return arr.Value(0), nil
+	}
+	cases := trial.Cases[any, int64]{
+		"int64":  {Input: int64(-5), Expected: -5},
+		"int8":   {Input: int8(-8), Expected: -8},
+		"uint8":  {Input: uint8(200), Expected: 200},
+		"uint16": {Input: uint16(60000), Expected: 60000},
+		"uint32": {Input: uint32(4000000000), Expected: 4000000000},
+		"bytes":  {Input: []byte("12"), Expected: 12},
+		"float":  {Input: 1.5, ShouldErr: true},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	//	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dustin/go-humanize"
 	"github.com/itchyny/gojq"
@@ -16,6 +20,8 @@ import (
 	tools "github.com/pcelvng/task-tools"
 	"github.com/pcelvng/task-tools/bootstrap"
 	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/rejects"
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 const (
@@ -24,12 +30,23 @@ const (
 
 	info params:
  - origin: (required) - glob path to a file(s) to transform (extract) 
- - dest:   (required) - file path to where the resulting data will be written 
+ - dest:   (required unless output is used) - file path to where the resulting data will be written 
+ - output: - named destinations (name:path|name2:path2) for records routed with a __dest key
  - jq:     (required) - file path to a jq definition file
  - threads: - number of threads to process files (default: 2)
+ - rejects: - file path to write lines that fail to transform (with the line number and error) instead of failing the task
+ - ordered: - write results in the same order as the input lines (default: false)
+
+jq results
+ - every result of the jq program is written, a program that yields nothing drops the record
+ - a result object with a __dest key is written to the output of that name (the key is removed)
+
+errors
+ - the first error stops all threads, the task fails with that error and the number of lines that failed
 
 example 
-{"task":"transform","info":"gs://path/to/file/*/*.gz?dest=gs://path/dest/output.gz&jq=./conf.jq"}`
+{"task":"transform","info":"gs://path/to/file/*/*.gz?dest=gs://path/dest/output.gz&jq=./conf.jq"}
+{"task":"transform","info":"gs://path/to/file/*/*.gz?dest=gs://path/dest/valid.gz&output=invalid:gs://path/dest/invalid.gz&jq=./route.jq"}`
 )
 
 type options struct {
@@ -57,6 +74,10 @@ func (o *options) newWorker(info string) task.Worker {
 		return task.InvalidWorker("uri error: %s", err)
 	}
 
+	if w.Dest == "" && len(w.Outputs) == 0 {
+		return task.InvalidWorker("dest is required")
+	}
+
 	if w.Threads < 1 {
 		return task.InvalidWorker("invalid threads %d (min: 1)", w.Threads)
 	}
@@ -70,12 +91,10 @@ func (o *options) newWorker(info string) task.Worker {
 		return task.InvalidWorker("jq config read: %s", err)
 	}
 
-	if w.reader, err = file.NewGlobReader(w.Path, &o.File); err != nil {
-		return task.InvalidWorker("reader error: %s", err)
-	}
-
-	if w.writer, err = file.NewWriter(w.Dest, &o.File); err != nil {
-		return task.InvalidWorker("writer error: %s", err)
+	if w.Rejects != "" {
+		if w.rejects, err = rejects.New(w.Rejects, &o.File); err != nil {
+			return task.InvalidWorker("rejects error: %s", err)
+		}
 	}
 
 	query, err := gojq.Parse(string(jqlogic))
@@ -96,81 +115,346 @@ func (o options) Validate() error {
 type worker struct {
 	task.Meta
 
-	Path     string `uri:"origin" required:"true"`
-	Dest     string `uri:"dest" required:"true"`
-	JqConfig string `uri:"jq" required:"true"`
-	Threads  int    `uri:"threads" default:"2"`
+	Path     string            `uri:"origin" required:"true"`
+	Dest     string            `uri:"dest"`
+	Outputs  map[string]string `uri:"output"` // named destinations for records with a __dest key
+	JqConfig string            `uri:"jq" required:"true"`
+	Threads  int               `uri:"threads" default:"2"`
+	Rejects  string            `uri:"rejects"`
+	Ordered  bool              `uri:"ordered"`
 
-	reader file.Reader
-	writer file.Writer
-	code   *gojq.Code
+	reader  file.Reader
+	writer  file.Writer            // default destination
+	outputs map[string]file.Writer // named destinations
+	rejects *rejects.Writer
+	code    *gojq.Code
+	dropped int64 // records the jq program did not return a result for
 
 	options
 }
 
+// open creates the reader and writers in spans of the task's trace
+func (w *worker) open(ctx context.Context) (err error) {
+	if w.reader, err = tracing.NewGlobReader(ctx, w.Path, &w.File); err != nil {
+		return fmt.Errorf("reader error: %w", err)
+	}
+	if w.Dest != "" {
+		if w.writer, err = tracing.NewWriter(ctx, w.Dest, &w.File); err != nil {
+			return fmt.Errorf("writer error: %w", err)
+		}
+	}
+	w.outputs = make(map[string]file.Writer)
+	for name, pth := range w.Outputs {
+		wr, err := tracing.NewWriter(ctx, pth, &w.File)
+		if err != nil {
+			return fmt.Errorf("output %s writer error: %w", name, err)
+		}
+		w.outputs[name] = wr
+	}
+	return nil
+}
+
 func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
-	in := make(chan []byte, 200)
-	errChan := make(chan error)
+	if err := w.open(ctx); err != nil {
+		if w.reader != nil {
+			w.reader.Close()
+		}
+		w.abort()
+		return task.Failed(err)
+	}
 	log.Printf("threads: %d", w.Threads)
+	procCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the first error stops processing, lines already
+	// being processed may also fail and are counted
+	var mu sync.Mutex
+	var firstErr error
+	var failures int
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		failures++
+		cancel()
+	}
+
+	// ordered output sends results to a single writer that holds
+	// them until all earlier lines are written. The window limits
+	// the number of lines read ahead of the writer.
+	var results chan result
+	var window chan struct{}
+	writeDone := make(chan struct{})
+	if w.Ordered {
+		results = make(chan result, w.Threads*2)
+		window = make(chan struct{}, reorderWindow)
+		go func() {
+			defer close(writeDone)
+			w.writeOrdered(results, window, fail)
+		}()
+	} else {
+		close(writeDone)
+	}
 
+	in := make(chan line, 200)
 	var wg sync.WaitGroup
 	for i := 0; i < w.Threads; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for b := range in {
-				if err := w.process(b); err != nil {
-					errChan <- err
+			for ln := range in {
+				if procCtx.Err() != nil {
+					continue // drain remaining lines
+				}
+				recs, err := w.transform(ln.data)
+				if err != nil && w.rejects != nil {
+					err = w.rejects.Write(ln.fileLine, ln.source, ln.data, err)
+				}
+				if err != nil {
+					fail(err)
+					continue
+				}
+				if w.Ordered {
+					results <- result{num: ln.num, recs: recs}
+					continue
+				}
+				if err := writeRecords(recs); err != nil {
+					fail(err)
 				}
 			}
 		}()
 	}
 
 	scanner := file.NewScanner(w.reader)
+	// a glob reader reports the file and line number of each line,
+	// other readers count every line read including blank lines
+	glob, _ := w.reader.(interface{ Line() (string, int64) })
+	var lineNum int64
+loop:
 	for scanner.Scan() {
+		lineNum++
+		sts := w.reader.Stats()
+		ln := line{num: lineNum, source: sts.Path, fileLine: sts.LineCnt, data: scanner.Bytes()}
+		if glob != nil {
+			ln.source, ln.fileLine = glob.Line()
+		}
+		if window != nil {
+			select {
+			case window <- struct{}{}:
+			case <-procCtx.Done():
+				break loop
+			}
+		}
 		select {
-		case <-ctx.Done():
-			close(in)
-			return task.Interrupted()
-		case err := <-errChan:
-			return task.Failed(err)
-		default:
-			in <- scanner.Bytes()
+		case in <- ln:
+		case <-procCtx.Done():
+			break loop
 		}
 	}
 	close(in)
 	wg.Wait()
+	if results != nil {
+		close(results)
+	}
+	<-writeDone
+	w.reader.Close()
 
-	sts := w.writer.Stats()
-	if sts.ByteCnt == 0 {
-		w.writer.Abort()
-		return task.Completed("no data to write")
+	if firstErr != nil {
+		w.abort()
+		return task.Failf("%v (%d lines failed)", firstErr, failures)
 	}
-	if err := w.writer.Close(); err != nil {
+	if ctx.Err() != nil {
+		w.abort()
+		return task.Interrupted()
+	}
+	if err := scanner.Err(); err != nil {
+		w.abort()
+		return task.Failed(err)
+	}
+
+	if err := w.rejects.Close(); err != nil {
 		return task.Failed(err)
 	}
-	osts, _ := file.Stat(w.Dest, &w.File)
+	w.rejects.SetMeta(w)
+	w.SetMeta(bootstrap.MetaRead, w.reader.Stats().JSONString())
+	if w.dropped > 0 {
+		w.SetMeta("dropped_records", strconv.FormatInt(w.dropped, 10))
+	}
 
-	w.SetMeta("file", w.Dest)
-	return task.Completed("%d files processed with %d lines and %s", w.reader.Stats().Files, sts.LineCnt, humanize.IBytes(uint64(osts.Size)))
+	// close the outputs, nothing is written for empty outputs
+	writers := []file.Writer{w.writer}
+	for _, name := range sortedKeys(w.outputs) {
+		writers = append(writers, w.outputs[name])
+	}
+	var files, written []string
+	var lines, size int64
+	for _, wr := range writers {
+		if wr == nil {
+			continue
+		}
+		sts := wr.Stats()
+		if sts.ByteCnt == 0 {
+			wr.Abort()
+			continue
+		}
+		if err := wr.Close(); err != nil {
+			return task.Failed(err)
+		}
+		osts, _ := file.Stat(sts.Path, &w.File)
+		files = append(files, sts.Path)
+		written = append(written, wr.Stats().JSONString())
+		lines += sts.LineCnt
+		size += osts.Size
+	}
+	if len(files) == 0 {
+		return task.Completed("no data to write")
+	}
+
+	w.SetMeta("file", files...)
+	w.SetMeta(bootstrap.MetaWrite, written...)
+	return task.Completed("%d files processed with %d lines and %s", w.reader.Stats().Files, lines, humanize.IBytes(uint64(size)))
+}
+
+// line is a line of data and its line number in the read files.
+// source and fileLine are the file it was read from and its line number in that file.
+type line struct {
+	num      int64
+	source   string
+	fileLine int64
+	data     []byte
+}
+
+// reorderWindow is the max number of lines read ahead
+// of the ordered writer
+const reorderWindow = 1000
+
+// record is a jq result and its destination
+type record struct {
+	w    file.Writer
+	data []byte
+}
+
+// result is the records created from a line
+type result struct {
+	num  int64
+	recs []record
 }
 
+// process runs the jq program on the line and writes each result
+// to its destination. Records without a result are dropped.
 func (w *worker) process(line []byte) error {
+	recs, err := w.transform(line)
+	if err != nil {
+		return err
+	}
+	return writeRecords(recs)
+}
+
+// transform runs the jq program on the line and returns the
+// records to write.
+func (w *worker) transform(line []byte) ([]record, error) {
 	data := make(map[string]interface{})
 	if err := jsoniter.Unmarshal(line, &data); err != nil {
-		return err
+		return nil, err
 	}
-	result, ok := w.code.Run(data).Next()
-	if !ok {
-		return result.(error)
+	iter := w.code.Run(data)
+	var recs []record
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		writer, err := w.destination(v)
+		if err != nil {
+			return nil, err
+		}
+		b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, record{w: writer, data: b})
 	}
-
-	b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(result)
-	if err != nil {
-		return err
+	if len(recs) == 0 {
+		atomic.AddInt64(&w.dropped, 1)
 	}
-	if err := w.writer.WriteLine(b); err != nil {
-		return err
+	return recs, nil
+}
+
+func writeRecords(recs []record) error {
+	for _, r := range recs {
+		if err := r.w.WriteLine(r.data); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// writeOrdered writes the results in line order. Lines that failed
+// are never received, so writing stops at the first missing line
+// (processing has already been canceled).
+func (w *worker) writeOrdered(results <-chan result, window <-chan struct{}, fail func(error)) {
+	pending := make(map[int64][]record)
+	next := int64(1)
+	for r := range results {
+		pending[r.num] = r.recs
+		for {
+			recs, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err := writeRecords(recs); err != nil {
+				fail(err)
+			}
+			next++
+			<-window
+		}
+	}
+}
+
+// abort discards all output
+func (w *worker) abort() {
+	if w.writer != nil {
+		w.writer.Abort()
+	}
+	for _, wr := range w.outputs {
+		wr.Abort()
+	}
+	w.rejects.Abort()
+}
+
+// destination returns the writer for the result. Objects with a
+// __dest key are routed to the named output and the key is removed.
+func (w *worker) destination(result interface{}) (file.Writer, error) {
+	if m, ok := result.(map[string]interface{}); ok {
+		if d, found := m[destKey]; found {
+			delete(m, destKey)
+			name, _ := d.(string)
+			if wr := w.outputs[name]; wr != nil {
+				return wr, nil
+			}
+			return nil, fmt.Errorf("unknown output %q", d)
+		}
+	}
+	if w.writer == nil {
+		return nil, fmt.Errorf("no dest for record without %s", destKey)
+	}
+	return w.writer, nil
+}
+
+// destKey routes a result to a named output
+const destKey = "__dest"
+
+func sortedKeys(m map[string]file.Writer) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
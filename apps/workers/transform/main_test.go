@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,8 +14,11 @@ import (
 	"github.com/itchyny/gojq"
 	"github.com/pcelvng/task"
 
+	"github.com/pcelvng/task-tools/bootstrap"
+	"github.com/pcelvng/task-tools/file"
 	"github.com/pcelvng/task-tools/file/mock"
 	"github.com/pcelvng/task-tools/file/nop"
+	"github.com/pcelvng/task-tools/file/stat"
 )
 
 const examplejson = `{"a":1,"b":12.345678901,"c":"apple","d":"dog"}`
@@ -112,3 +120,198 @@ func TestWorker_Process(t *testing.T) {
 	}
 	trial.New(fn, cases).Test(t)
 }
+
+func TestWorker_Rejects(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/data-1.json", []byte(examplejson+"\n"), 0644)
+	os.WriteFile(dir+"/data-2.json", []byte(examplejson+"\n{bad json\n"), 0644)
+	os.WriteFile(dir+"/conf.jq", []byte("{a: .a}"), 0644)
+
+	o := &options{}
+	w := o.newWorker(dir + "/data-*.json?dest=" + dir + "/out.json&jq=" + dir + "/conf.jq&threads=1&rejects=" + dir + "/rejects.json")
+	if invalid, s := task.IsInvalidWorker(w); invalid {
+		t.Fatal(s)
+	}
+	if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+		t.Fatal(s)
+	}
+	if cnt := w.(*worker).GetMeta().Get("rejects_count"); cnt != "1" {
+		t.Errorf("expected 1 reject got %q", cnt)
+	}
+	b, _ := os.ReadFile(dir + "/rejects.json")
+	// the line number is within the file the record was read from
+	if !strings.HasPrefix(string(b), `{"line":2,"source":"`+dir+`/data-2.json","error":`) {
+		t.Errorf("unexpected rejects %s", b)
+	}
+	b, _ = os.ReadFile(dir + "/out.json")
+	if string(b) != "{\"a\":1}\n{\"a\":1}\n" {
+		t.Errorf("unexpected output %q", b)
+	}
+	meta := w.(*worker).GetMeta()
+	if sts := stat.NewFromBytes([]byte(meta.Get(bootstrap.MetaRead))); sts.ByteCnt != int64(2*len(examplejson)+12) {
+		t.Errorf("unexpected read stats %s", sts.JSONString())
+	}
+	if sts := stat.NewFromBytes([]byte(meta.Get(bootstrap.MetaWrite))); sts.ByteCnt != 16 {
+		t.Errorf("unexpected write stats %s", sts.JSONString())
+	}
+}
+
+func TestWorker_Outputs(t *testing.T) {
+	type output struct {
+		Files   map[string]string
+		Dropped string
+	}
+	fn := func(jq string) (output, error) {
+		dir := t.TempDir()
+		os.WriteFile(dir+"/data.json", []byte(`{"id":1,"tags":["a","b"]}`+"\n"+`{"id":2,"tags":[]}`+"\n"+`{"id":3}`+"\n"), 0644)
+		os.WriteFile(dir+"/conf.jq", []byte(jq), 0644)
+
+		o := &options{}
+		w := o.newWorker(dir + "/data.json?dest=" + dir + "/out.json&output=odd:" + dir + "/odd.json|even:" + dir + "/even.json&jq=" + dir + "/conf.jq&threads=1")
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return output{}, errors.New(s)
+		}
+		if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+			return output{}, errors.New(s)
+		}
+		out := output{Files: map[string]string{}, Dropped: w.(*worker).GetMeta().Get("dropped_records")}
+		for _, f := range w.(*worker).GetMeta()["file"] {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return output{}, err
+			}
+			out.Files[strings.TrimPrefix(f, dir+"/")] = string(b)
+		}
+		return out, nil
+	}
+	cases := trial.Cases[string, output]{
+		"multiple results": {
+			Input:    `{id: .id, tag: .tags[]?}`,
+			Expected: output{Files: map[string]string{"out.json": "{\"id\":1,\"tag\":\"a\"}\n{\"id\":1,\"tag\":\"b\"}\n"}, Dropped: "2"},
+		},
+		"filter": {
+			Input:    `select(.id > 1) | {id}`,
+			Expected: output{Files: map[string]string{"out.json": "{\"id\":2}\n{\"id\":3}\n"}, Dropped: "1"},
+		},
+		"route": {
+			Input: `{id, __dest: (if .id % 2 == 0 then "even" else "odd" end)}`,
+			Expected: output{Files: map[string]string{
+				"even.json": "{\"id\":2}\n",
+				"odd.json":  "{\"id\":1}\n{\"id\":3}\n",
+			}},
+		},
+		"route and default": {
+			Input: `if .id == 2 then {id, __dest: "even"} else {id} end`,
+			Expected: output{Files: map[string]string{
+				"out.json":  "{\"id\":1}\n{\"id\":3}\n",
+				"even.json": "{\"id\":2}\n",
+			}},
+		},
+	}
+	trial.New(fn, cases).Timeout(5 * time.Second).SubTest(t)
+}
+
+func TestWorker_Destination(t *testing.T) {
+	def, odd := mock.NewWriter("nop://out"), mock.NewWriter("nop://odd")
+	fn := func(in interface{}) (string, error) {
+		w := &worker{writer: def, outputs: map[string]file.Writer{"odd": odd}}
+		wr, err := w.destination(in)
+		if err != nil {
+			return "", err
+		}
+		return wr.Stats().Path, nil
+	}
+	cases := trial.Cases[interface{}, string]{
+		"default": {
+			Input:    map[string]interface{}{"id": 1},
+			Expected: "nop://out",
+		},
+		"not an object": {
+			Input:    "value",
+			Expected: "nop://out",
+		},
+		"named": {
+			Input:    map[string]interface{}{"id": 1, "__dest": "odd"},
+			Expected: "nop://odd",
+		},
+		"unknown": {
+			Input:       map[string]interface{}{"id": 1, "__dest": "other"},
+			ExpectedErr: errors.New(`unknown output "other"`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWorker_Ordered(t *testing.T) {
+	dir := t.TempDir()
+	var data, expected strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&data, "{\"id\":%d}\n", i)
+		fmt.Fprintf(&expected, "{\"n\":%d}\n", i)
+	}
+	os.WriteFile(dir+"/data.json", []byte(data.String()), 0644)
+	os.WriteFile(dir+"/conf.jq", []byte(`{n: .id}`), 0644)
+
+	o := &options{}
+	w := o.newWorker(dir + "/data.json?dest=" + dir + "/out.json&jq=" + dir + "/conf.jq&threads=8&ordered=true")
+	if invalid, s := task.IsInvalidWorker(w); invalid {
+		t.Fatal(s)
+	}
+	if r, s := w.DoTask(context.Background()); r != task.CompleteResult {
+		t.Fatal(s)
+	}
+	b, err := os.ReadFile(dir + "/out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(string(b), expected.String()); !eq {
+		t.Error(diff)
+	}
+}
+
+func TestWorker_Errors(t *testing.T) {
+	fn := func(in string) (string, error) {
+		dir := t.TempDir()
+		var data strings.Builder
+		for i := 1; i <= 1000; i++ {
+			fmt.Fprintf(&data, "{\"id\":%d}\n", i)
+		}
+		os.WriteFile(dir+"/data.json", []byte(data.String()), 0644)
+		os.WriteFile(dir+"/conf.jq", []byte(`{id, __dest: "unknown"}`), 0644)
+
+		o := &options{}
+		w := o.newWorker(dir + "/data.json?dest=" + dir + "/out.json&jq=" + dir + "/conf.jq&" + in)
+		if invalid, s := task.IsInvalidWorker(w); invalid {
+			return "", errors.New(s)
+		}
+		r, s := w.DoTask(context.Background())
+		if r != task.ErrResult {
+			return "", fmt.Errorf("expected error got %s: %s", r, s)
+		}
+		if _, err := os.Stat(dir + "/out.json"); err == nil {
+			return "", errors.New("output should not be written")
+		}
+		// the number of failed lines depends on how many threads
+		// were processing when the first error was found
+		return regexp.MustCompile(`\(\d+ lines failed\)`).ReplaceAllString(s, "(n lines failed)"), nil
+	}
+	cases := trial.Cases[string, string]{
+		"single thread": {
+			Input:    "threads=1",
+			Expected: `unknown output "unknown" (n lines failed)`,
+		},
+		"threads": {
+			Input:    "threads=8",
+			Expected: `unknown output "unknown" (n lines failed)`,
+		},
+		"ordered": {
+			Input:    "threads=8&ordered=true",
+			Expected: `unknown output "unknown" (n lines failed)`,
+		},
+		"invalid output": {
+			Input:    "output=bad:nop://init_err",
+			Expected: "output bad writer error: init_err",
+		},
+	}
+	trial.New(fn, cases).Timeout(5 * time.Second).SubTest(t)
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
+
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 var sigChan = make(chan os.Signal, 1) // signal handling
@@ -111,3 +113,16 @@ func genLauncherOptions(b *task.LauncherOptions) string {
 
 	return s
 }
+
+// genTraceOptions will generate a helpful options output
+func genTraceOptions(t tracing.Options) string {
+	s := `# optional OpenTelemetry tracing, spans are sent to an OTLP http collector
+# tracing is disabled if endpoint is blank
+[trace]
+`
+	s += fmt.Sprintf("  endpoint=\"%v\"\n", t.Endpoint)
+	s += fmt.Sprintf("  insecure=%v\n", t.Insecure)
+	s += fmt.Sprintf("  #%v=%v\n", "sample", 1)
+
+	return s
+}
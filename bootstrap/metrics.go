@@ -0,0 +1,137 @@
+package bootstrap
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pcelvng/task"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// Meta keys for the stat.Stats (json) of the files a worker read
+// and wrote. The byte counts are added to the bytes read and
+// written metrics.
+//
+//	w.SetMeta(bootstrap.MetaRead, reader.Stats().JSONString())
+const (
+	MetaRead  = "read_stats"
+	MetaWrite = "write_stats"
+)
+
+// durationBuckets in seconds from 0.1s to ~30m
+var durationBuckets = prometheus.ExponentialBuckets(0.1, 3, 10)
+
+// metrics of the tasks run by the launcher, served at /metrics
+// with the go runtime and process metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	running      prometheus.Gauge
+	tasks        *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	bytesRead    prometheus.Counter
+	bytesWritten prometheus.Counter
+}
+
+func newMetrics(name string) *metrics {
+	labels := prometheus.Labels{"app": name}
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "tasks_in_progress",
+			Help:        "The number of tasks currently running",
+			ConstLabels: labels,
+		}),
+		tasks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "tasks_total",
+			Help:        "The number of finished tasks by result",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "task_duration_seconds",
+			Help:        "The time for a worker to finish a task by result",
+			ConstLabels: labels,
+			Buckets:     durationBuckets,
+		}, []string{"result"}),
+		bytesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "task_bytes_read_total",
+			Help:        "The bytes read by tasks from the " + MetaRead + " meta",
+			ConstLabels: labels,
+		}),
+		bytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "task_bytes_written_total",
+			Help:        "The bytes written by tasks from the " + MetaWrite + " meta",
+			ConstLabels: labels,
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.running, m.tasks, m.duration, m.bytesRead, m.bytesWritten,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// record a finished task. The bytes read and written are
+// from the meta of workers that implement the meta interface.
+func (m *metrics) record(w task.Worker, result task.Result, d time.Duration) {
+	m.tasks.WithLabelValues(string(result)).Inc()
+	m.duration.WithLabelValues(string(result)).Observe(d.Seconds())
+	if mw, ok := w.(meta); ok {
+		values := mw.GetMeta()
+		m.bytesRead.Add(float64(byteCount(values[MetaRead])))
+		m.bytesWritten.Add(float64(byteCount(values[MetaWrite])))
+	}
+}
+
+// byteCount sums the bytes of stat.Stats json values.
+// The file size is used when the uncompressed byte count is not set.
+func byteCount(values []string) (n int64) {
+	for _, v := range values {
+		sts := stat.NewFromBytes([]byte(v))
+		if sts.ByteCnt > 0 {
+			n += sts.ByteCnt
+		} else {
+			n += sts.Size
+		}
+	}
+	return n
+}
+
+// healthz is the liveness probe, the app is alive if it can respond
+func (tm *Starter) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// readyz is the readiness probe, the app is ready once it is running
+// and until it starts shutting down.
+func (tm *Starter) readyz(w http.ResponseWriter, _ *http.Request) {
+	if !tm.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// Metrics is the registry served at /metrics. Apps can register
+// their own collectors to be served with the task metrics.
+func (tm *Starter) Metrics() prometheus.Registerer {
+	return tm.taskMetrics().registry
+}
+
+// taskMetrics creates the metrics of a Starter that was not
+// made with NewWorkerApp or NewTaskMaster
+func (tm *Starter) taskMetrics() *metrics {
+	if tm.metrics == nil {
+		tm.metrics = newMetrics(tm.name)
+	}
+	return tm.metrics
+}
@@ -0,0 +1,85 @@
+package bootstrap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pcelvng/task"
+
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+type statsWorker struct {
+	task.Meta
+	result task.Result
+}
+
+func (w *statsWorker) DoTask(_ context.Context) (task.Result, string) {
+	w.SetMeta(MetaRead, stat.Stats{Path: "in.gz", Size: 10, ByteCnt: 100}.JSONString())
+	w.SetMeta(MetaWrite, stat.Stats{Path: "out.json", Size: 40}.JSONString(), stat.Stats{Path: "out2.json", Size: 2}.JSONString())
+	return w.result, "done"
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics("test-worker")
+	tm := &Starter{
+		Utility: Utility{name: "test-worker"},
+		metrics: m,
+		newWkr: func(info string) task.Worker {
+			if info == "invalid" {
+				return task.InvalidWorker("bad info")
+			}
+			return &statsWorker{Meta: task.NewMeta(), result: task.Result(info)}
+		},
+	}
+	newWkr := tm.newWorker
+
+	w := newWkr(string(task.CompleteResult))
+	if _, ok := w.(meta); !ok {
+		t.Error("expected wrapped worker to implement meta")
+	}
+	w.DoTask(context.Background())
+	newWkr(string(task.ErrResult)).DoTask(context.Background())
+	if r, msg := newWkr("invalid").DoTask(context.Background()); r != task.ErrResult || msg != "bad info" {
+		t.Errorf("invalid worker got %v %q", r, msg)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, s := range []string{
+		`tasks_total{app="test-worker",result="complete"} 1`,
+		`tasks_total{app="test-worker",result="error"} 2`,
+		`task_duration_seconds_count{app="test-worker",result="complete"} 1`,
+		`tasks_in_progress{app="test-worker"} 0`,
+		`task_bytes_read_total{app="test-worker"} 200`,
+		`task_bytes_written_total{app="test-worker"} 84`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %q in metrics", s)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tm := &Starter{}
+	get := func(fn http.HandlerFunc) int {
+		rec := httptest.NewRecorder()
+		fn(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if code := get(tm.healthz); code != http.StatusOK {
+		t.Errorf("healthz: got %d", code)
+	}
+	if code := get(tm.readyz); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before start: got %d", code)
+	}
+	tm.ready.Store(true)
+	if code := get(tm.readyz); code != http.StatusOK {
+		t.Errorf("readyz: got %d", code)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/davecgh/go-spew/spew"
@@ -19,6 +20,8 @@ import (
 	"github.com/hydronica/toml"
 	"github.com/pcelvng/task"
 	"github.com/pcelvng/task/bus"
+
+	"github.com/pcelvng/task-tools/tracing"
 )
 
 type NewRunner func(*Starter) Runner
@@ -50,6 +53,7 @@ func NewTaskMaster(appName string, initFn NewRunner, options Validator) *Starter
 		},
 		bType:     "master",
 		newRunner: initFn,
+		metrics:   newMetrics(appName),
 	}
 	tm.infoFn = tm.HandleRequest
 	return tm
@@ -84,6 +88,7 @@ func NewWorkerApp(tskType string, newWkr task.NewWorker, options Validator) *Sta
 			InChannel: tskType,
 		},
 		LauncherOpt: task.NewLauncherOptions(tskType),
+		metrics:     newMetrics(tskType),
 	}
 	tm.SetHandler(
 		func(wr http.ResponseWriter, r *http.Request) {
@@ -126,10 +131,17 @@ type Starter struct {
 	// options
 	BusOpt      bus.Options           `toml:"bus"`
 	LauncherOpt *task.LauncherOptions `toml:"launcher"`
+	TraceOpt    tracing.Options       `toml:"trace"`
 
 	// Type of bootstrap: worker or Starter
-	bType  string `toml:"-" flag:"-" env:"-"`
-	infoFn func(w http.ResponseWriter, r *http.Request)
+	bType   string `toml:"-" flag:"-" env:"-"`
+	infoFn  func(w http.ResponseWriter, r *http.Request)
+	metrics *metrics
+	ready   atomic.Bool // readiness once running until shutdown
+
+	// tracing
+	traces   *traceConsumer
+	shutdown func(context.Context) error
 
 	// Starter vars
 	newRunner NewRunner
@@ -179,18 +191,29 @@ func (tm *Starter) Initialize() *Starter {
 		spew.Dump(tm.Validator)
 		spew.Dump(tm.BusOpt)
 		spew.Dump(tm.LauncherOpt)
+		spew.Dump(tm.TraceOpt)
 		os.Exit(0)
 	}
 	if validateErr != nil {
 		log.Fatal(validateErr)
 	}
+	var err error
+	if tm.shutdown, err = tracing.Init(context.Background(), tm.name, tm.TraceOpt); err != nil {
+		log.Fatal(err)
+	}
 	switch tm.bType {
 	case "worker":
-		var err error
-		tm.launcher, err = task.NewLauncher(tm.newWkr, tm.LauncherOpt, &tm.BusOpt)
+		c, err := task.NewConsumer(&tm.BusOpt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := task.NewProducer(&tm.BusOpt)
 		if err != nil {
 			log.Fatal(err)
 		}
+		// the consumer keeps the trace context of each task for its worker
+		tm.traces = newTraceConsumer(c)
+		tm.launcher = task.NewLauncherFromBus(tm.newWorker, tm.traces, p, tm.LauncherOpt)
 	case "master":
 		tm.runner = tm.newRunner(tm)
 	}
@@ -208,6 +231,8 @@ func (tm *Starter) genConfig() {
 	writer.WriteString(genBusOptions(tm.BusOpt))
 	writer.Write([]byte("\n"))
 	writer.WriteString(genLauncherOptions(tm.LauncherOpt))
+	writer.Write([]byte("\n"))
+	writer.WriteString(genTraceOptions(tm.TraceOpt))
 
 	os.Exit(0)
 }
@@ -241,9 +266,11 @@ func (tm *Starter) Run() {
 	go func() {
 		select {
 		case <-sigChan:
+			tm.ready.Store(false)
 			cancel()
 		}
 	}()
+	tm.ready.Store(true)
 
 	log.Printf("starting %v", tm.name)
 	switch tm.bType {
@@ -258,6 +285,11 @@ func (tm *Starter) Run() {
 	default:
 		log.Fatalf("unknown bootstrap type %q", tm.bType)
 	}
+	if tm.shutdown != nil {
+		if err := tm.shutdown(context.Background()); err != nil {
+			log.Println("trace shutdown:", err)
+		}
+	}
 	os.Exit(0)
 }
 
@@ -270,6 +302,9 @@ func (tm *Starter) start() {
 	log.Printf("starting http status server on port %d", tm.StatusPort)
 
 	http.HandleFunc("/", tm.infoFn)
+	http.Handle("/metrics", tm.taskMetrics().handler())
+	http.HandleFunc("/healthz", tm.healthz)
+	http.HandleFunc("/readyz", tm.readyz)
 	go func() {
 		err := http.ListenAndServe(":"+strconv.Itoa(tm.StatusPort), nil)
 		log.Fatal("http health service failed", err)
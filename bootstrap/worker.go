@@ -0,0 +1,152 @@
+package bootstrap
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pcelvng/task"
+	"github.com/pcelvng/task/bus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pcelvng/task-tools/tracing"
+)
+
+// meta is the interface used by the launcher to add worker meta to the task
+type meta interface {
+	SetMeta(key string, value ...string)
+	GetMeta() url.Values
+}
+
+// traceConsumer keeps the trace context from the meta of consumed tasks
+// so the launched worker can start its span as a child of flowlord's.
+// The launcher only passes the info to the worker, so contexts
+// are looked up by the task info. Contexts of tasks that are never
+// launched (rejected or ignored by the launcher) expire after traceTTL.
+type traceConsumer struct {
+	bus.Consumer
+
+	mu      sync.Mutex
+	pending map[string][]pendingTrace
+}
+
+// traceTTL is how long a trace context waits for its worker to be created
+const traceTTL = 5 * time.Minute
+
+type pendingTrace struct {
+	sc    trace.SpanContext
+	added time.Time
+}
+
+func newTraceConsumer(c bus.Consumer) *traceConsumer {
+	return &traceConsumer{Consumer: c, pending: make(map[string][]pendingTrace)}
+}
+
+func (c *traceConsumer) Msg() ([]byte, bool, error) {
+	b, done, err := c.Consumer.Msg()
+	if len(b) == 0 {
+		return b, done, err
+	}
+	if tsk, _ := task.NewFromBytes(b); tsk != nil {
+		sc := trace.SpanContextFromContext(tracing.ExtractMeta(context.Background(), tsk.Meta))
+		if sc.IsValid() {
+			now := time.Now()
+			c.mu.Lock()
+			c.expire(now)
+			c.pending[tsk.Info] = append(c.pending[tsk.Info], pendingTrace{sc: sc, added: now})
+			c.mu.Unlock()
+		}
+	}
+	return b, done, err
+}
+
+// expire removes the trace contexts older than traceTTL, contexts
+// are added in order so only the front of each list is checked.
+// c.mu must be held.
+func (c *traceConsumer) expire(now time.Time) {
+	for info, pts := range c.pending {
+		i := 0
+		for i < len(pts) && now.Sub(pts[i].added) > traceTTL {
+			i++
+		}
+		if i == len(pts) {
+			delete(c.pending, info)
+		} else if i > 0 {
+			c.pending[info] = pts[i:]
+		}
+	}
+}
+
+// parent removes and returns the trace context of the task info
+func (c *traceConsumer) parent(info string) trace.SpanContext {
+	if c == nil {
+		return trace.SpanContext{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pts := c.pending[info]
+	if len(pts) == 0 {
+		return trace.SpanContext{}
+	}
+	if len(pts) == 1 {
+		delete(c.pending, info)
+	} else {
+		c.pending[info] = pts[1:]
+	}
+	return pts[0].sc
+}
+
+// worker wraps the app's worker to record task metrics and
+// run DoTask in a span that is passed on to the worker with ctx.
+type worker struct {
+	task.Worker
+	info   string
+	parent trace.SpanContext
+	tm     *Starter
+}
+
+// metaWorker is a worker that still implements the meta interface
+type metaWorker struct {
+	*worker
+	meta
+}
+
+// newWorker is the task.NewWorker used by the launcher
+func (tm *Starter) newWorker(info string) task.Worker {
+	w := &worker{
+		Worker: tm.newWkr(info),
+		info:   info,
+		parent: tm.traces.parent(info),
+		tm:     tm,
+	}
+	if mw, ok := w.Worker.(meta); ok {
+		return &metaWorker{worker: w, meta: mw}
+	}
+	return w
+}
+
+func (w *worker) DoTask(ctx context.Context) (task.Result, string) {
+	m := w.tm.taskMetrics()
+	if w.parent.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, w.parent)
+	}
+	ctx, span := tracing.Start(ctx, w.tm.name,
+		attribute.String("task.type", w.tm.name),
+		attribute.String("task.info", w.info))
+	defer span.End()
+
+	m.running.Inc()
+	start := time.Now()
+	result, msg := w.Worker.DoTask(ctx)
+	m.running.Dec()
+	m.record(w.Worker, result, time.Since(start))
+
+	span.SetAttributes(attribute.String("task.result", string(result)))
+	if result == task.ErrResult || result == task.AlertResult {
+		span.SetStatus(codes.Error, msg)
+	}
+	return result, msg
+}
@@ -0,0 +1,116 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pcelvng/task"
+	"github.com/pcelvng/task/bus/info"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/pcelvng/task-tools/tracing"
+)
+
+// msgConsumer returns each message then done
+type msgConsumer struct {
+	msgs [][]byte
+}
+
+func (c *msgConsumer) Msg() ([]byte, bool, error) {
+	if len(c.msgs) == 0 {
+		return nil, true, nil
+	}
+	b := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return b, len(c.msgs) == 0, nil
+}
+
+func (c *msgConsumer) Stop() error         { return nil }
+func (c *msgConsumer) Info() info.Consumer { return info.Consumer{} }
+
+type spanWorker struct {
+	span trace.SpanContext
+}
+
+func (w *spanWorker) DoTask(ctx context.Context) (task.Result, string) {
+	w.span = trace.SpanContextFromContext(ctx)
+	return task.Failf("bad data")
+}
+
+func TestWorkerTrace(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	// the flowlord span
+	ctx, parent := tracing.Start(context.Background(), "flowlord")
+	parent.End()
+	tsk := task.New("test-worker", "?day=2024-01-01")
+	tsk.Meta = tracing.InjectMeta(ctx, "workflow=f.toml")
+	untraced := task.New("test-worker", "?day=2024-01-02")
+
+	c := newTraceConsumer(&msgConsumer{msgs: [][]byte{tsk.JSONBytes(), untraced.JSONBytes()}})
+	for done := false; !done; {
+		_, done, _ = c.Msg()
+	}
+	wkr := &spanWorker{}
+	tm := &Starter{
+		Utility: Utility{name: "test-worker"},
+		traces:  c,
+		newWkr:  func(string) task.Worker { return wkr },
+	}
+
+	tm.newWorker(tsk.Info).DoTask(context.Background())
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+	span := spans[1]
+	if span.Name() != "test-worker" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("span %q parent %v != %v", span.Name(), span.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if wkr.span.SpanID() != span.SpanContext().SpanID() {
+		t.Error("expected worker span in the DoTask context")
+	}
+	if span.Status().Description != "bad data" || span.Status().Code.String() != "Error" {
+		t.Errorf("unexpected status %v", span.Status())
+	}
+
+	// each trace context is only used once
+	if sc := c.parent(tsk.Info); sc.IsValid() {
+		t.Errorf("unexpected trace context %v", sc)
+	}
+	// tasks without a trace context start a new trace
+	tm.newWorker(untraced.Info).DoTask(context.Background())
+	if span := sr.Ended()[2]; span.Parent().IsValid() {
+		t.Errorf("unexpected parent %v", span.Parent())
+	}
+}
+
+func TestTraceConsumer_expire(t *testing.T) {
+	sc := func(b byte) trace.SpanContext {
+		return trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{b}, SpanID: trace.SpanID{b}})
+	}
+	now := time.Now()
+	c := newTraceConsumer(&msgConsumer{})
+	c.pending = map[string][]pendingTrace{
+		"rejected": {{sc: sc(1), added: now.Add(-traceTTL - time.Second)}},
+		"mixed":    {{sc: sc(2), added: now.Add(-traceTTL - time.Second)}, {sc: sc(3), added: now}},
+		"new":      {{sc: sc(4), added: now}},
+	}
+	c.expire(now)
+	if len(c.pending) != 2 || len(c.pending["rejected"]) != 0 {
+		t.Errorf("expected expired contexts to be removed %v", c.pending)
+	}
+	if got := c.parent("mixed"); !got.Equal(sc(3)) {
+		t.Errorf("expected the newest mixed context got %v", got)
+	}
+	if got := c.parent("new"); !got.Equal(sc(4)) {
+		t.Errorf("expected new context got %v", got)
+	}
+}
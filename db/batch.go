@@ -55,6 +55,8 @@ type BatchLoader interface {
 // dbType should be:
 // * "postgres" for Postgres loading
 // * "mysql" for MySQL loading
+// * "sqlite" for SQLite loading
+// * "clickhouse" for ClickHouse loading (deletes run first and are not rolled back)
 // * "nop" for using the nop batch loader "nop://", "nop://commit_err"
 //
 // Other adapters have not been tested but will likely work
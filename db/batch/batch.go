@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,13 +12,14 @@ import (
 
 var maxBatchSize = 200 // max number of rows in a single insert statement
 
-// NewBatchLoader will return an instance of a NopBatchLoader that is
-// tested to work with MySQL and Postgres. It will likely work with
-// most other sql adapters that support the same standard insert syntax
-// used in MySQL and Postgres and use '?' as the value placeholder.
+// NewBatchLoader will return an instance of a BatchLoader that is
+// tested to work with MySQL, Postgres, SQLite and ClickHouse. It will likely
+// work with most other sql adapters that support the same standard insert
+// syntax used in MySQL and Postgres and use '?' as the value placeholder.
 func NewBatchLoader(dbType string, sqlDB *sql.DB) *BatchLoader {
 	return &BatchLoader{
 		dbType:       dbType,
+		dialect:      getDialect(dbType),
 		sqlDB:        sqlDB,
 		maxBatchSize: maxBatchSize,
 		cols:         make([]string, 0),
@@ -48,7 +48,8 @@ func NewBatchLoader(dbType string, sqlDB *sql.DB) *BatchLoader {
 // - columns names and number of columns
 // - column values
 type BatchLoader struct {
-	dbType       string // identifier of underlying adapter - ie postgres, mysql
+	dbType       string  // identifier of underlying adapter - ie postgres, mysql
+	dialect      dialect // adapter specific insert and delete behavior
 	sqlDB        *sql.DB
 	maxBatchSize int // maximum size of a batch
 	delQuery     string
@@ -59,6 +60,9 @@ type BatchLoader struct {
 	mu sync.Mutex
 }
 
+// Delete sets the query run before the rows are inserted. ClickHouse
+// runs it before and outside of the insert transaction so the removed
+// rows are not restored when the insert fails.
 func (l *BatchLoader) Delete(query string, vals ...interface{}) {
 	l.delQuery = query
 	l.delVals = vals
@@ -87,7 +91,8 @@ func (l *BatchLoader) Commit(ctx context.Context, tableName string, cols ...stri
 	numRows := len(l.fRows) / len(l.cols)
 
 	// batches info
-	numBatches, batchSize, lastBatchSize := numBatches(l.maxBatchSize, numRows)
+	maxRows := l.dialect.batchSize(l.maxBatchSize, len(l.cols))
+	numBatches, batchSize, lastBatchSize := numBatches(maxRows, numRows)
 
 	// do transaction
 	return l.doTx(ctx, numRows, numBatches, batchSize, lastBatchSize, tableName)
@@ -95,7 +100,22 @@ func (l *BatchLoader) Commit(ctx context.Context, tableName string, cols ...stri
 
 // doTx will execute the transaction.
 func (l *BatchLoader) doTx(ctx context.Context, numRows, numBatches, batchSize, lastBatchSize int, tableName string) (Stats, error) {
+	// begin
+	started := time.Now()
+
+	// execute delete before the transaction (if provided)
+	// when the adapter does not allow it inside the batch.
+	var removed int64
+	if l.delQuery != "" && l.dialect.noTxDelete {
+		rslt, err := l.sqlDB.ExecContext(ctx, l.delQuery, l.delVals...)
+		if err != nil {
+			return NewStats(), err
+		}
+		removed, _ = rslt.RowsAffected()
+	}
+
 	sts := NewStats()
+	sts.Removed = removed
 
 	// standard batch bulk insert
 	insQ := l.genInsert(l.cols, batchSize, tableName)
@@ -106,9 +126,6 @@ func (l *BatchLoader) doTx(ctx context.Context, numRows, numBatches, batchSize,
 		lastInsQ = l.genInsert(l.cols, lastBatchSize, tableName)
 	}
 
-	// begin
-	started := time.Now()
-
 	// Serializable transaction level is required for idempotent batch loading.
 	tx, err := l.sqlDB.BeginTx(ctx, nil)
 	if err != nil {
@@ -132,7 +149,7 @@ func (l *BatchLoader) doTx(ctx context.Context, numRows, numBatches, batchSize,
 	}
 
 	// execute delete (if provided)
-	if l.delQuery != "" {
+	if l.delQuery != "" && !l.dialect.noTxDelete {
 		rslt, err := tx.ExecContext(ctx, l.delQuery, l.delVals...)
 		if err != nil {
 			tx.Rollback()
@@ -159,6 +176,10 @@ func (l *BatchLoader) doTx(ctx context.Context, numRows, numBatches, batchSize,
 			return sts, err
 		}
 		insertCnt, _ := rslt.RowsAffected()
+		if l.dialect.rowInsert {
+			// rows are buffered by the adapter until commit
+			insertCnt = int64(end-b*numVals) / int64(numCols)
+		}
 		sts.Inserted += insertCnt
 	}
 
@@ -180,6 +201,8 @@ func (l *BatchLoader) doTx(ctx context.Context, numRows, numBatches, batchSize,
 // number of placeholder values.
 // If dbType == "postgres" then the placeholder values are postgres '$' style.
 // Otherwise the generic '?' placeholder values are used.
+// Row insert dialects (clickhouse) have no VALUES list because the
+// adapter appends the values of each execution to the batch.
 func (l *BatchLoader) genInsert(cols []string, numRows int, tableName string) string {
 	// all three values required for a correctly formed
 	// insert statement.
@@ -187,10 +210,14 @@ func (l *BatchLoader) genInsert(cols []string, numRows int, tableName string) st
 		return ""
 	}
 
+	if l.dialect.rowInsert {
+		return fmt.Sprintf("INSERT INTO %s (%s)", tableName, strings.Join(cols, ","))
+	}
+
 	rows := make([]string, numRows)
 
 	// gen params
-	params := genParams(l.dbType, len(cols)*numRows)
+	params := l.dialect.params(len(cols) * numRows)
 
 	// gen rows
 	lCols := len(cols)
@@ -247,38 +274,3 @@ func numBatches(maxBatchSize, numRows int) (batches int, batchSize, lastBatchSiz
 
 	return batches, batchSize, lastBatchSize
 }
-
-// genParams will choose either postgres or generic '?'
-// parameter system. If dbType is "postgres" then the
-// postgres param format will be chosen, otherwise the
-// generic '?' is used.
-func genParams(dbType string, numParams int) []string {
-	if dbType == "postgres" {
-		return genPGParams(numParams)
-	}
-	return genGenericParams(numParams)
-}
-
-// genGenericParams provides a simple string slice with generic '?'
-// query params. If numParams == 3 then the result string
-// slice values would be:
-// {"?","?","?"}
-func genGenericParams(numParams int) []string {
-	params := make([]string, numParams)
-	for i := 0; i < numParams; i++ {
-		params[i] = "?"
-	}
-	return params
-}
-
-// genParams provides a simple string slice with Postgres
-// query params. If numParams == 3 then the result string
-// slice values would be:
-// {"$1","$2","$3"}
-func genPGParams(numParams int) []string {
-	params := make([]string, numParams)
-	for i := 0; i < numParams; i++ {
-		params[i] = "$" + strconv.Itoa(i+1)
-	}
-	return params
-}
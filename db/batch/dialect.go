@@ -0,0 +1,141 @@
+package batch
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect names understood by the BatchLoader. The dialect name
+// is also the registered database/sql driver name for the adapter.
+const (
+	Postgres   = "postgres"
+	MySQL      = "mysql"
+	SQLite     = "sqlite"
+	ClickHouse = "clickhouse"
+)
+
+// dialect describes how a sql adapter differs from the
+// standard multi-row insert used for postgres and mysql.
+type dialect struct {
+	name string
+
+	// pgParams uses postgres '$n' placeholders instead of '?'.
+	pgParams bool
+
+	// maxParams is the maximum number of placeholder values
+	// allowed in a single statement.
+	maxParams int
+
+	// rowInsert prepares a single insert without a VALUES list
+	// that is executed once for each row. The adapter buffers
+	// the rows and sends them as a single block on commit.
+	rowInsert bool
+
+	// noTxDelete executes the delete query before the insert
+	// transaction begins because the adapter cannot run other
+	// statements inside of a batch insert.
+	noTxDelete bool
+}
+
+var dialects = map[string]dialect{
+	Postgres: {
+		name:      Postgres,
+		pgParams:  true,
+		maxParams: 65535,
+	},
+	MySQL: {
+		name:      MySQL,
+		maxParams: 65535,
+	},
+	SQLite: {
+		name:      SQLite,
+		maxParams: 999, // SQLITE_MAX_VARIABLE_NUMBER of older sqlite builds
+	},
+	ClickHouse: {
+		name:       ClickHouse,
+		rowInsert:  true,
+		noTxDelete: true,
+	},
+}
+
+// aliases map common alternative driver names to a dialect.
+var aliases = map[string]string{
+	"postgresql": Postgres,
+	"pgx":        Postgres,
+	"sqlite3":    SQLite,
+	"ch":         ClickHouse,
+}
+
+// getDialect returns the dialect for dbType. Unknown types use
+// the generic '?' placeholder and no statement limits.
+func getDialect(dbType string) dialect {
+	name := strings.ToLower(dbType)
+	if a, ok := aliases[name]; ok {
+		name = a
+	}
+	if d, ok := dialects[name]; ok {
+		return d
+	}
+	return dialect{name: dbType}
+}
+
+// IsDialect returns true if dbType is a known dialect or alias.
+func IsDialect(dbType string) bool {
+	name := strings.ToLower(dbType)
+	if _, ok := aliases[name]; ok {
+		return true
+	}
+	_, ok := dialects[name]
+	return ok
+}
+
+// batchSize returns the number of rows that can be inserted in a
+// single statement without going over maxBatchSize rows or the
+// maxParams placeholder limit.
+func (d dialect) batchSize(maxBatchSize, numCols int) int {
+	if d.rowInsert {
+		return 1
+	}
+	if d.maxParams == 0 || numCols == 0 {
+		return maxBatchSize
+	}
+	if n := d.maxParams / numCols; n < maxBatchSize {
+		if n < 1 {
+			return 1
+		}
+		return n
+	}
+	return maxBatchSize
+}
+
+// params returns numParams placeholder values in the dialect's style.
+func (d dialect) params(numParams int) []string {
+	if d.pgParams {
+		return genPGParams(numParams)
+	}
+	return genGenericParams(numParams)
+}
+
+// genGenericParams provides a simple string slice with generic '?'
+// query params. If numParams == 3 then the result string
+// slice values would be:
+// {"?","?","?"}
+func genGenericParams(numParams int) []string {
+	params := make([]string, numParams)
+	for i := 0; i < numParams; i++ {
+		params[i] = "?"
+	}
+	return params
+}
+
+// genParams provides a simple string slice with Postgres
+// query params. If numParams == 3 then the result string
+// slice values would be:
+// {"$1","$2","$3"}
+func genPGParams(numParams int) []string {
+	params := make([]string, numParams)
+	for i := 0; i < numParams; i++ {
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+	return params
+}
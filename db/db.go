@@ -4,16 +4,28 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"os/user"
+	"strings"
 
+	_ "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+
+	"github.com/pcelvng/task-tools/db/batch"
 )
 
+func init() {
+	// sqlx does not know the driver names of the sqlite and clickhouse adapters
+	sqlx.BindDriver(batch.SQLite, sqlx.QUESTION)
+	sqlx.BindDriver(batch.ClickHouse, sqlx.QUESTION)
+}
+
 type Options struct {
 	Username     string `toml:"username" commented:"true"`
 	Password     string `toml:"password" commented:"true"`
 	Host         string `toml:"host" comment:"host can be 'host:port', 'host', 'host:' or ':port'"`
-	DBName       string `toml:"dbname"`
+	DBName       string `toml:"dbname" comment:"database name or file path for sqlite"`
 	Serializable bool   `toml:"serializable" comment:"set isolation level to serializable, required for proper writing to database" commented:"true"`
 	SSL          SSL    `toml:"SSL"`
 }
@@ -207,3 +219,104 @@ func MySQLTx(un, pass, host, dbName string, serializable bool) (*sql.DB, error)
 
 	return dbConn, nil
 }
+
+// Open connects to the database of the given dialect
+// (postgres, mysql, sqlite or clickhouse) described by the options.
+func (o *Options) Open(dialect string) (*sql.DB, error) {
+	switch dialect {
+	case batch.Postgres:
+		return o.PG()
+	case batch.MySQL:
+		return o.MySQL()
+	case batch.SQLite:
+		return o.SQLite()
+	case batch.ClickHouse:
+		return o.ClickHouse()
+	}
+	return nil, fmt.Errorf("unsupported db dialect %q", dialect)
+}
+
+// Openx connects to the database of the given dialect and
+// returns a sqlx.DB with the correct bind type for the dialect.
+func (o *Options) Openx(dialect string) (*sqlx.DB, error) {
+	dbConn, err := o.Open(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(dbConn, dialect), nil
+}
+
+func (o Options) SQLite() (*sql.DB, error) {
+	return SQLite(o.DBName)
+}
+
+// SQLite is a convenience initializer to obtain a SQLite DB connection.
+// path is the database file and is created if it doesn't exist,
+// use ":memory:" for a private in-memory database.
+//
+// Note that sqlite allows a single writer so a busy timeout is set
+// to wait on locks rather than fail immediately.
+func SQLite(path string) (*sql.DB, error) {
+	if path == "" {
+		return nil, errors.New("sqlite path is required")
+	}
+
+	connStr := path
+	if !strings.Contains(path, "?") {
+		connStr += "?_pragma=busy_timeout(5000)"
+	}
+	dbConn, err := sql.Open(batch.SQLite, connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// each connection to an in-memory database is a new database
+	if strings.Contains(path, ":memory:") {
+		dbConn.SetMaxOpenConns(1)
+	}
+
+	// ping
+	if err = dbConn.Ping(); err != nil {
+		return nil, err
+	}
+
+	return dbConn, nil
+}
+
+func (o Options) ClickHouse() (*sql.DB, error) {
+	return ClickHouse(o.Username, o.Password, o.Host, o.DBName)
+}
+
+// ClickHouse is a convenience initializer to obtain a ClickHouse DB connection
+// using the native protocol (default port 9000).
+//
+// Note that ClickHouse does not support transactions. Inserts are buffered
+// and sent as a single block on commit but deletes are applied immediately.
+func ClickHouse(un, pass, host, dbName string) (*sql.DB, error) {
+	dbConn, err := sql.Open(batch.ClickHouse, clickhouseDSN(un, pass, host, dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	// ping
+	if err = dbConn.Ping(); err != nil {
+		return nil, err
+	}
+
+	return dbConn, nil
+}
+
+// clickhouseDSN escapes the user and password in the connection url
+func clickhouseDSN(un, pass, host, dbName string) string {
+	if un == "" {
+		un = "default"
+	}
+	u := url.URL{
+		Scheme:   "clickhouse",
+		User:     url.UserPassword(un, pass),
+		Host:     host,
+		Path:     "/" + dbName,
+		RawQuery: "dial_timeout=5s",
+	}
+	return u.String()
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/hydronica/trial"
+)
+
+func TestSQLite_BatchLoader(t *testing.T) {
+	type input struct {
+		rows   int
+		delete string
+	}
+	type output struct {
+		Inserted int64
+		Removed  int64
+		Count    int
+	}
+	fn := func(in input) (output, error) {
+		conn, err := SQLite(":memory:")
+		if err != nil {
+			return output{}, err
+		}
+		defer conn.Close()
+		if _, err := conn.Exec("create table test (id integer, name text, value real)"); err != nil {
+			return output{}, err
+		}
+		if _, err := conn.Exec("insert into test values (-1, 'old', 0), (-2, 'old', 0)"); err != nil {
+			return output{}, err
+		}
+
+		bl := NewBatchLoader("sqlite", conn)
+		for i := 0; i < in.rows; i++ {
+			bl.AddRow([]interface{}{i, fmt.Sprintf("name%d", i), float64(i) / 2})
+		}
+		if in.delete != "" {
+			bl.Delete(in.delete)
+		}
+		sts, err := bl.Commit(context.Background(), "test", "id", "name", "value")
+		if err != nil {
+			return output{}, err
+		}
+
+		out := output{Inserted: sts.Inserted, Removed: sts.Removed}
+		err = conn.QueryRow("select count(*) from test").Scan(&out.Count)
+		return out, err
+	}
+	cases := trial.Cases[input, output]{
+		"single batch": {
+			Input:    input{rows: 10},
+			Expected: output{Inserted: 10, Count: 12},
+		},
+		"over param limit": { // 999 params / 3 cols = 333 rows per statement
+			Input:    input{rows: 1000},
+			Expected: output{Inserted: 1000, Count: 1002},
+		},
+		"with delete": {
+			Input:    input{rows: 5, delete: "delete from test where name = 'old'"},
+			Expected: output{Inserted: 5, Removed: 2, Count: 5},
+		},
+		"bad delete": {
+			Input:     input{rows: 5, delete: "delete from missing"},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestOptions_Open(t *testing.T) {
+	fn := func(dialect string) (bool, error) {
+		o := &Options{DBName: ":memory:"}
+		conn, err := o.Openx(dialect)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		var v int
+		err = conn.Get(&v, conn.Rebind("select ?"), 1)
+		return v == 1, err
+	}
+	cases := trial.Cases[string, bool]{
+		"sqlite": {
+			Input:    "sqlite",
+			Expected: true,
+		},
+		"unknown": {
+			Input:     "oracle",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestClickhouseDSN(t *testing.T) {
+	type input struct {
+		user, pass string
+	}
+	fn := func(in input) (string, error) {
+		dsn := clickhouseDSN(in.user, in.pass, "localhost:9000", "db1")
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		pass, _ := u.User.Password()
+		return u.User.Username() + " " + pass + " " + u.Host + u.Path, nil
+	}
+	cases := trial.Cases[input, string]{
+		"default user": {
+			Input:    input{pass: "secret"},
+			Expected: "default secret localhost:9000/db1",
+		},
+		"special characters": {
+			Input:    input{user: "etl", pass: "p@ss:w/rd?#%"},
+			Expected: "etl p@ss:w/rd?#% localhost:9000/db1",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
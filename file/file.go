@@ -348,6 +348,37 @@ func Stat(path string, opt *Options) (stat.Stats, error) {
 	return local.Stat(path)
 }
 
+// Remove deletes the file at path
+func Remove(path string, opt *Options) error {
+	if opt == nil {
+		opt = NewOptions()
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	mOpt := minio.Option{AccessKey: opt.AccessKey, SecretKey: opt.SecretKey, Secure: true}
+	switch u.Scheme {
+	case "s3":
+		mOpt.Host = minio.S3Host
+		return minio.Remove(path, mOpt)
+	case "gs":
+		mOpt.Host = minio.GSHost
+		return minio.Remove(path, mOpt)
+	case "mc", "minio":
+		mOpt.Host = u.Host
+		mOpt.Secure = false
+		return minio.Remove(path, mOpt)
+	case "mcs":
+		mOpt.Host = u.Host
+		mOpt.Secure = true
+		return minio.Remove(path, mOpt)
+	case "nop":
+		return nop.Remove(path)
+	}
+	return local.Remove(path)
+}
+
 // Glob will match to files and folder
 //
 // Supports the same globing patterns as provided in *nix
@@ -38,15 +38,14 @@ type GlobReader struct {
 	files     []stat.Stats
 	fileIndex int
 	reader    Reader
+
+	linePath string // file of the last line read
+	lineNum  int64  // line number of the last line read in its file
 }
 
 func (g *GlobReader) nextFile() (err error) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	if len(g.files) <= g.fileIndex {
-		g.reader = nil
-		return io.EOF
-	}
 	if g.reader != nil {
 		sts := g.reader.Stats()
 		g.sts.ByteCnt += sts.ByteCnt
@@ -54,6 +53,10 @@ func (g *GlobReader) nextFile() (err error) {
 		g.sts.Size += sts.Size
 		g.reader.Close()
 	}
+	if len(g.files) <= g.fileIndex {
+		g.reader = nil
+		return io.EOF
+	}
 	g.reader, err = NewReader(g.files[g.fileIndex].Path, &g.opts)
 	g.fileIndex++
 
@@ -87,9 +90,11 @@ func (g *GlobReader) ReadLine() (b []byte, err error) {
 		return b, io.EOF
 	}
 
-	g.mu.RLock()
+	g.mu.Lock()
 	b, err = g.reader.ReadLine()
-	g.mu.RUnlock()
+	g.linePath = g.files[g.fileIndex-1].Path
+	g.lineNum = g.reader.Stats().LineCnt
+	g.mu.Unlock()
 
 	if err == io.EOF {
 		err = g.nextFile()
@@ -97,6 +102,14 @@ func (g *GlobReader) ReadLine() (b []byte, err error) {
 	return b, err
 }
 
+// Line is the file path and line number within that file
+// of the last line returned by ReadLine.
+func (g *GlobReader) Line() (string, int64) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.linePath, g.lineNum
+}
+
 func (g *GlobReader) Stats() stat.Stats {
 	sts := g.sts
 	if g.reader != nil {
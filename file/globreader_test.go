@@ -0,0 +1,44 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobReader_Line(t *testing.T) {
+	dir := t.TempDir()
+	f1, f2 := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(f1, []byte("a1\n\na3\n"), 0644)
+	os.WriteFile(f2, []byte("b1\nb2"), 0644)
+
+	r, err := NewGlobReader(filepath.Join(dir, "*.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	type line struct {
+		data string
+		path string
+		num  int64
+	}
+	expected := []line{{"a1", f1, 1}, {"a3", f1, 3}, {"b1", f2, 1}, {"b2", f2, 2}}
+	var got []line
+	s := NewScanner(r)
+	for s.Scan() {
+		pth, num := r.(*GlobReader).Line()
+		got = append(got, line{s.Text(), pth, num})
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("line %d: expected %v got %v", i, expected[i], got[i])
+		}
+	}
+	// includes the last file
+	if sts := r.Stats(); sts.ByteCnt != 12 || sts.LineCnt != 5 || sts.Files != 2 {
+		t.Errorf("unexpected stats %v", sts.JSONString())
+	}
+}
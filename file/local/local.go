@@ -91,3 +91,8 @@ func ListFiles(pth string) ([]stat.Stats, error) {
 
 	return allSts, nil
 }
+
+// Remove deletes the file at pth
+func Remove(pth string) error {
+	return os.Remove(rmLocalPrefix(pth))
+}
@@ -75,3 +75,19 @@ func TestRmLocalPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	pth := t.TempDir() + "/file.txt"
+	if err := os.WriteFile(pth, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove("local://" + pth); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pth); !os.IsNotExist(err) {
+		t.Errorf("expected file removed %v", err)
+	}
+	if err := Remove(pth); err == nil {
+		t.Error("expected error on missing file")
+	}
+}
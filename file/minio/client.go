@@ -121,3 +121,15 @@ func Stat(pth string, Opt Option) (stat.Stats, error) {
 		IsDir:    false,
 	}, err
 }
+
+// Remove deletes the object at pth
+func Remove(pth string, Opt Option) error {
+	client, err := newClient(Opt)
+	if err != nil {
+		return fmt.Errorf("client init %w", err)
+	}
+	_, bucket, objPth := parsePth(pth)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return client.RemoveObject(ctx, bucket, objPth, minio.RemoveObjectOptions{})
+}
@@ -31,3 +31,17 @@ func Stat(pth string) (stat.Stats, error) {
 		Created:  time.Now().UTC().Truncate(24 * time.Hour).Format(time.RFC3339),
 	}, nil
 }
+
+// Remove can be used as a mock remove for testing.
+// error, err, rm_error or rm_err - return an error when called
+func Remove(pth string) error {
+	u, err := url.Parse(pth)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Host) {
+	case "error", "err", "rm_error", "rm_err":
+		return errors.New("nop remove error")
+	}
+	return nil
+}
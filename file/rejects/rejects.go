@@ -0,0 +1,130 @@
+// Package rejects provides a quarantine writer for records a worker
+// could not process. Each rejected record is written as a json line
+// with the line number, the reason and the original data so it can
+// be reviewed and reprocessed.
+//
+//	{"line":12,"error":"invalid int \"seven\"","data":"01234,seven,true"}
+//
+// Workers opt in with a rejects=<path> info option.
+package rejects
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// Meta keys set by SetMeta
+const (
+	MetaPath  = "rejects"
+	MetaCount = "rejects_count"
+)
+
+// Record is a single rejected line
+type Record struct {
+	Line   int64  `json:"line,omitempty"`
+	Source string `json:"source,omitempty"` // file the line was read from
+	Error  string `json:"error"`
+	Data   string `json:"data"`
+}
+
+// Writer writes rejected records to a file and is safe for
+// concurrent use. A nil Writer can be closed, aborted and
+// counted so workers only need to check for nil before writing.
+type Writer struct {
+	mu    sync.Mutex
+	w     file.Writer
+	count int64
+}
+
+// New creates a rejects Writer for pth. The file is only
+// created if at least one record is rejected.
+func New(pth string, opts *file.Options) (*Writer, error) {
+	w, err := file.NewWriter(pth, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Write quarantines the line with the reason it was rejected.
+// source is optional and may be empty.
+func (w *Writer) Write(line int64, source string, data []byte, reason error) error {
+	r := Record{Line: line, Source: source, Data: string(data)}
+	if reason != nil {
+		r.Error = reason.Error()
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.w.WriteLine(b); err != nil {
+		return err
+	}
+	atomic.AddInt64(&w.count, 1)
+	return nil
+}
+
+// Count is the number of rejected records
+func (w *Writer) Count() int64 {
+	if w == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&w.count)
+}
+
+// Path of the rejects file
+func (w *Writer) Path() string {
+	if w == nil {
+		return ""
+	}
+	return w.w.Stats().Path
+}
+
+// Stats of the rejects file
+func (w *Writer) Stats() stat.Stats {
+	if w == nil {
+		return stat.Stats{}
+	}
+	return w.w.Stats()
+}
+
+// Close writes the rejects file. Nothing is written
+// when there are no rejected records.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Count() == 0 {
+		return w.w.Abort()
+	}
+	return w.w.Close()
+}
+
+// Abort discards the rejects file
+func (w *Writer) Abort() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Abort()
+}
+
+// SetMeta adds the rejects path and count to the task meta
+// when records were rejected.
+func (w *Writer) SetMeta(m interface{ SetMeta(string, ...string) }) {
+	if w.Count() == 0 {
+		return
+	}
+	m.SetMeta(MetaPath, w.Path())
+	m.SetMeta(MetaCount, strconv.FormatInt(w.Count(), 10))
+}
@@ -0,0 +1,99 @@
+package rejects
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hydronica/trial"
+	"github.com/pcelvng/task"
+)
+
+func TestWriter(t *testing.T) {
+	type input struct {
+		records []Record
+	}
+	type output struct {
+		Lines []string
+		Meta  task.Meta
+	}
+	fn := func(in input) (output, error) {
+		pth := filepath.Join(t.TempDir(), "rejects.json")
+		w, err := New(pth, nil)
+		if err != nil {
+			return output{}, err
+		}
+		for _, r := range in.records {
+			if err := w.Write(r.Line, r.Source, []byte(r.Data), errors.New(r.Error)); err != nil {
+				return output{}, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return output{}, err
+		}
+		out := output{Meta: task.NewMeta()}
+		w.SetMeta(out.Meta)
+		if m := out.Meta.GetMeta(); m.Get(MetaPath) != "" {
+			out.Meta.SetMeta(MetaPath, filepath.Base(m.Get(MetaPath)))
+		}
+		b, err := os.ReadFile(pth)
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		out.Lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+		return out, err
+	}
+	cases := trial.Cases[input, output]{
+		"no rejects": {
+			Input:    input{},
+			Expected: output{Meta: task.Meta{}},
+		},
+		"rejects": {
+			Input: input{records: []Record{
+				{Line: 2, Error: "invalid json", Data: `{"a":`},
+				{Line: 7, Source: "data.csv", Error: "bad", Data: "1,2"},
+			}},
+			Expected: output{
+				Lines: []string{
+					`{"line":2,"error":"invalid json","data":"{\"a\":"}`,
+					`{"line":7,"source":"data.csv","error":"bad","data":"1,2"}`,
+				},
+				Meta: task.Meta{MetaPath: {"rejects.json"}, MetaCount: {"2"}},
+			},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWriter_Concurrent(t *testing.T) {
+	w, err := New("nop://", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write(int64(j), "", []byte("x"), errors.New("bad"))
+			}
+		}()
+	}
+	wg.Wait()
+	if w.Count() != 1000 {
+		t.Errorf("count %d != 1000", w.Count())
+	}
+}
+
+func TestNilWriter(t *testing.T) {
+	var w *Writer
+	m := task.NewMeta()
+	w.SetMeta(m)
+	if w.Count() != 0 || w.Close() != nil || w.Abort() != nil || len(m) != 0 {
+		t.Error("nil writer should be a no-op")
+	}
+}
@@ -0,0 +1,364 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buger/jsonparser"
+
+	"github.com/pcelvng/task-tools/file/stat"
+	"github.com/pcelvng/task-tools/file/util"
+	"github.com/pcelvng/task-tools/tmpl"
+)
+
+// KeyToken is the destination template token replaced
+// with the partition key of a record.
+const KeyToken = "{PARTITION}"
+
+// emptyKey is the partition used for records with an empty key
+const emptyKey = "none"
+
+// keyReplacer removes path separators and parent directory
+// references from partition keys so a key can't write outside its directory
+var keyReplacer = strings.NewReplacer("/", "_", `\`, "_", "\n", "_", "\x00", "_", "..", "__")
+
+// spillSep separates the destination path and the line in a spill file
+const spillSep = '\x00'
+
+func NewWriteByKey(destTmpl string, opt *Options, maxOpen int) *WriteByKey {
+	if opt == nil {
+		opt = NewOptions()
+	}
+
+	return &WriteByKey{
+		opt:      opt,
+		destTmpl: destTmpl,
+		maxOpen:  maxOpen,
+		writers:  make(map[string]Writer),
+	}
+}
+
+// WriteByKey writes to files based on a record time and
+// partition key. It works like WriteByHour with the key
+// available to the destination template as {PARTITION}.
+//
+// When maxOpen is set no more than maxOpen files are written
+// at the same time. Lines for other files are spilled to a
+// local tmp file and written in batches of maxOpen files
+// when the writer is closed.
+type WriteByKey struct {
+	// NewWriter creates the writer of each destination file,
+	// NewWriter of this package is used when nil.
+	NewWriter func(pth string, opt *Options) (Writer, error)
+
+	opt *Options // file buffer options
+
+	// write file destination template
+	// Example:
+	// s3://bucket/base/dir/{PARTITION}/{YYYY}/{MM}/{DD}/{TS}.txt
+	destTmpl string
+	maxOpen  int // max number of open writers (0 is no limit)
+
+	// writers map key is the destination file path (parsed destTmpl)
+	writers map[string]Writer
+	stats   []Writer // all writers in the order they were created
+	lineCnt int64    // total line count across all files
+
+	spill    *os.File // lines for files that are not open
+	spillW   *bufio.Writer
+	spillCnt int64 // lines written to the spill file
+
+	mu sync.Mutex
+}
+
+// Path returns the destination path for the time and key
+func (w *WriteByKey) Path(t time.Time, key string) string {
+	key = keyReplacer.Replace(key)
+	switch key {
+	case "":
+		key = emptyKey
+	case ".":
+		key = "_"
+	}
+	// the key is added after the template is parsed
+	// so template tokens in the key are not replaced
+	return strings.Replace(tmpl.Parse(w.destTmpl, t), KeyToken, key, -1)
+}
+
+// WriteLine writes the line to the destination file
+// for the time and partition key.
+//
+// Write order is not guaranteed.
+func (w *WriteByKey) WriteLine(ln []byte, t time.Time, key string) error {
+	pth := w.Path(t, key)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writer, found := w.writers[pth]
+	if !found {
+		if w.maxOpen > 0 && len(w.writers) >= w.maxOpen {
+			if err := w.spillLine(pth, ln); err != nil {
+				return err
+			}
+			atomic.AddInt64(&w.lineCnt, 1)
+			w.spillCnt++
+			return nil
+		}
+		var err error
+		if writer, err = w.newWriter(pth); err != nil {
+			return err
+		}
+	}
+
+	err := writer.WriteLine(ln)
+	if err == nil {
+		atomic.AddInt64(&w.lineCnt, 1)
+	}
+	return err
+}
+
+func (w *WriteByKey) newWriter(pth string) (Writer, error) {
+	newWriter := w.NewWriter
+	if newWriter == nil {
+		newWriter = NewWriter
+	}
+	writer, err := newWriter(pth, w.opt)
+	if err != nil {
+		return nil, err
+	}
+	w.writers[pth] = writer
+	w.stats = append(w.stats, writer)
+	return writer, nil
+}
+
+// spillLine writes the path and line to the spill file
+func (w *WriteByKey) spillLine(pth string, ln []byte) error {
+	if w.spill == nil {
+		f, err := w.openSpill()
+		if err != nil {
+			return err
+		}
+		w.spill, w.spillW = f, bufio.NewWriter(f)
+	}
+	w.spillW.WriteString(pth)
+	w.spillW.WriteByte(spillSep)
+	w.spillW.Write(ln)
+	if err := w.spillW.WriteByte('\n'); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	return nil
+}
+
+func (w *WriteByKey) openSpill() (*os.File, error) {
+	dir := w.opt.FileBufDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	_, f, err := util.OpenTmp(dir, w.opt.FileBufPrefix+"spill-")
+	if err != nil {
+		return nil, fmt.Errorf("spill: %w", err)
+	}
+	return f, nil
+}
+
+// LineCnt will provide the totals number of
+// lines written across all files.
+func (w *WriteByKey) LineCnt() int64 {
+	return atomic.LoadInt64(&w.lineCnt)
+}
+
+// SpillCnt is the number of lines that were spilled
+// to disk because the max number of files were open.
+func (w *WriteByKey) SpillCnt() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.spillCnt
+}
+
+// Stats provides stats for all files.
+func (w *WriteByKey) Stats() []stat.Stats {
+	var stats []stat.Stats
+	for _, writer := range w.stats {
+		stats = append(stats, writer.Stats())
+	}
+	return stats
+}
+
+// Abort will abort on all open files and remove
+// the spill file. If there are multiple non-nil
+// errors it will return one of them.
+func (w *WriteByKey) Abort() error {
+	var err error
+	for _, writer := range w.writers {
+		if aErr := writer.Abort(); aErr != nil {
+			err = aErr
+		}
+	}
+	w.writers = make(map[string]Writer)
+	w.removeSpill()
+	return err
+}
+
+// Close will close all open files and then write
+// any spilled lines. See WriteByHour.Close.
+func (w *WriteByKey) Close() error {
+	return w.CloseWithContext(context.Background())
+}
+
+// CloseWithContext is just like close but accepts a context.
+// ctx.Done is checked before starting each file close.
+//
+// Returns an error with body "interrupted" if prematurely
+// shutdown by ctx.
+func (w *WriteByKey) CloseWithContext(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		if err := w.closeWriters(ctx); err != nil {
+			w.removeSpill()
+			return err
+		}
+		if w.spill == nil {
+			return nil
+		}
+		if err := w.writeSpill(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+}
+
+// closeWriters closes and removes all open writers
+func (w *WriteByKey) closeWriters(ctx context.Context) error {
+	var err error
+	for _, writer := range w.writers {
+		// if an error is found then abort
+		// the remaining writers.
+		if err != nil {
+			writer.Abort()
+			continue
+		}
+
+		// context cancel
+		if err = ctx.Err(); err != nil {
+			err = errors.New("interrupted")
+			writer.Abort()
+			continue
+		}
+
+		if cErr := writer.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	w.writers = make(map[string]Writer)
+	return err
+}
+
+// writeSpill reads the spill file and writes lines for up to
+// maxOpen files. Lines for other files are spilled again.
+func (w *WriteByKey) writeSpill() error {
+	f, bw := w.spill, w.spillW
+	w.spill, w.spillW = nil, nil
+	defer util.RmTmp(f.Name())
+	defer f.Close()
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	r := bufio.NewReader(f)
+	for {
+		ln, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+		i := bytes.IndexByte(ln, spillSep)
+		pth, ln := string(ln[:i]), ln[i+1:len(ln)-1]
+
+		writer, found := w.writers[pth]
+		if !found {
+			if len(w.writers) >= w.maxOpen {
+				if err := w.spillLine(pth, ln); err != nil {
+					return err
+				}
+				continue
+			}
+			if writer, err = w.newWriter(pth); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteLine(ln); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *WriteByKey) removeSpill() {
+	if w.spill == nil {
+		return
+	}
+	w.spill.Close()
+	util.RmTmp(w.spill.Name())
+	w.spill, w.spillW = nil, nil
+}
+
+// KeyExtractor defines a type that will parse raw
+// bytes and extract the partition key of a record.
+//
+// The underlying bytes should not be modified.
+type KeyExtractor func([]byte) (string, error)
+
+// CSVKeyExtractor returns a KeyExtractor for the csv
+// field at fieldIndex.
+func CSVKeyExtractor(sep string, fieldIndex int) KeyExtractor {
+	if sep == "" {
+		sep = defaultSep
+	}
+	if fieldIndex < 0 {
+		fieldIndex = 0
+	}
+	return func(b []byte) (string, error) {
+		s := strings.Split(string(b), sep)
+		if len(s) <= fieldIndex {
+			return "", fmt.Errorf("index %v not in '%v'", fieldIndex, string(b))
+		}
+		return s[fieldIndex], nil
+	}
+}
+
+// JSONKeyExtractor returns a KeyExtractor for the json field.
+// Nested fields are separated by a '.' (user.id).
+// Non-string values are used as written in the record
+// and null values are an empty key.
+func JSONKeyExtractor(field string) KeyExtractor {
+	keys := strings.Split(field, ".")
+	return func(b []byte) (string, error) {
+		v, typ, _, err := jsonparser.Get(b, keys...)
+		if err != nil {
+			return "", fmt.Errorf(`field "%v" not in '%v'`, field, string(b))
+		}
+		switch typ {
+		case jsonparser.String:
+			return jsonparser.ParseString(v)
+		case jsonparser.Null:
+			return "", nil
+		}
+		return string(v), nil
+	}
+}
@@ -0,0 +1,136 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hydronica/trial"
+)
+
+func TestWriteByKey(t *testing.T) {
+	type record struct {
+		Key  string
+		Hour int
+	}
+	fn := func(maxOpen int) (map[string]string, error) {
+		dir := t.TempDir()
+		w := NewWriteByKey(dir+"/{PARTITION}/{HH}.txt", &Options{FileBufDir: dir}, maxOpen)
+		recs := []record{{"a", 1}, {"b", 1}, {"c", 2}, {"a", 1}, {"a/b", 2}, {"", 1}, {"c", 2}, {"b", 3}}
+		for i, r := range recs {
+			tm := time.Date(2020, 1, 1, r.Hour, 0, 0, 0, time.UTC)
+			if err := w.WriteLine([]byte{byte('0' + i)}, tm, r.Key); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if w.LineCnt() != int64(len(recs)) {
+			t.Errorf("line count %d", w.LineCnt())
+		}
+		files := map[string]string{}
+		for _, sts := range w.Stats() {
+			b, err := os.ReadFile(sts.Path)
+			if err != nil {
+				return nil, err
+			}
+			rel, _ := filepath.Rel(dir, sts.Path)
+			files[rel] = string(b)
+		}
+		// the spill file is removed
+		if m, _ := filepath.Glob(dir + "/spill-*"); len(m) > 0 {
+			t.Errorf("spill file not removed %v", m)
+		}
+		return files, nil
+	}
+	expected := map[string]string{
+		"a/01.txt":    "0\n3\n",
+		"b/01.txt":    "1\n",
+		"c/02.txt":    "2\n6\n",
+		"a_b/02.txt":  "4\n",
+		"none/01.txt": "5\n",
+		"b/03.txt":    "7\n",
+	}
+	cases := trial.Cases[int, map[string]string]{
+		"no limit":  {Input: 0, Expected: expected},
+		"spill":     {Input: 2, Expected: expected},
+		"one file":  {Input: 1, Expected: expected},
+		"all files": {Input: 6, Expected: expected},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestWriteByKey_Abort(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriteByKey(dir+"/{PARTITION}.txt", &Options{FileBufDir: dir}, 1)
+	w.WriteLine([]byte("1"), time.Time{}, "a")
+	w.WriteLine([]byte("2"), time.Time{}, "b")
+	if w.SpillCnt() != 1 {
+		t.Errorf("expected 1 spilled line got %d", w.SpillCnt())
+	}
+	w.Abort()
+	if m, _ := filepath.Glob(dir + "/*"); len(m) > 0 {
+		t.Errorf("files not removed %v", m)
+	}
+}
+
+func TestWriteByKey_Path(t *testing.T) {
+	w := NewWriteByKey("/data/{PARTITION}/{YYYY}/file.txt", nil, 0)
+	fn := func(key string) (string, error) {
+		return w.Path(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), key), nil
+	}
+	cases := trial.Cases[string, string]{
+		"key":       {Input: "a", Expected: "/data/a/2020/file.txt"},
+		"empty":     {Input: "", Expected: "/data/none/2020/file.txt"},
+		"separator": {Input: "a/b", Expected: "/data/a_b/2020/file.txt"},
+		"parent":    {Input: "..", Expected: "/data/__/2020/file.txt"},
+		"traversal": {Input: "../../etc", Expected: "/data/______etc/2020/file.txt"},
+		"current":   {Input: ".", Expected: "/data/_/2020/file.txt"},
+		"dots":      {Input: "a..b", Expected: "/data/a__b/2020/file.txt"},
+		"template":  {Input: "{yyyy}-{MM}", Expected: "/data/{yyyy}-{MM}/2020/file.txt"},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestKeyExtractor(t *testing.T) {
+	type input struct {
+		fn KeyExtractor
+		ln string
+	}
+	fn := func(in input) (string, error) {
+		return in.fn([]byte(in.ln))
+	}
+	cases := trial.Cases[input, string]{
+		"json string": {
+			Input:    input{JSONKeyExtractor("id"), `{"id":"abc"}`},
+			Expected: "abc",
+		},
+		"json number": {
+			Input:    input{JSONKeyExtractor("id"), `{"id":12}`},
+			Expected: "12",
+		},
+		"json nested": {
+			Input:    input{JSONKeyExtractor("user.id"), `{"user":{"id":"x1"}}`},
+			Expected: "x1",
+		},
+		"json null": {
+			Input:    input{JSONKeyExtractor("id"), `{"id":null}`},
+			Expected: "",
+		},
+		"json missing": {
+			Input:     input{JSONKeyExtractor("id"), `{"name":"a"}`},
+			ShouldErr: true,
+		},
+		"csv": {
+			Input:    input{CSVKeyExtractor("|", 1), `a|b|c`},
+			Expected: "b",
+		},
+		"csv missing": {
+			Input:     input{CSVKeyExtractor(",", 3), `a,b`},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pcelvng/task-tools/file"
+	"github.com/pcelvng/task-tools/file/stat"
+)
+
+// NewReader is file.NewReader in a child span of ctx.
+// The span ends when the reader is closed.
+func NewReader(ctx context.Context, pth string, opts *file.Options) (file.Reader, error) {
+	_, span := Start(ctx, "file.read", attribute.String("file.path", pth))
+	r, err := file.NewReader(pth, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	return &reader{Reader: r, fileSpan: fileSpan{span: span}}, nil
+}
+
+// NewGlobReader is file.NewGlobReader in a child span of ctx.
+// The span ends when the reader is closed and the returned reader
+// still reports the file and line number of each line with Line.
+func NewGlobReader(ctx context.Context, pth string, opts *file.Options) (file.Reader, error) {
+	_, span := Start(ctx, "file.read", attribute.String("file.path", pth))
+	r, err := file.NewGlobReader(pth, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	return &globReader{reader: reader{Reader: r, fileSpan: fileSpan{span: span}}}, nil
+}
+
+// NewWriter is file.NewWriter in a child span of ctx.
+// The span ends when the writer is closed or aborted.
+func NewWriter(ctx context.Context, pth string, opts *file.Options) (file.Writer, error) {
+	_, span := Start(ctx, "file.write", attribute.String("file.path", pth))
+	w, err := file.NewWriter(pth, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	return &writer{Writer: w, fileSpan: fileSpan{span: span}}, nil
+}
+
+// fileSpan ends the span once with the final file stats
+type fileSpan struct {
+	span trace.Span
+	once sync.Once
+}
+
+func (f *fileSpan) end(sts stat.Stats, err error) {
+	f.once.Do(func() {
+		f.span.SetAttributes(
+			attribute.Int64("file.bytes", sts.ByteCnt),
+			attribute.Int64("file.lines", sts.LineCnt),
+			attribute.Int64("file.size", sts.Size),
+		)
+		if err != nil {
+			f.span.RecordError(err)
+			f.span.SetStatus(codes.Error, err.Error())
+		}
+		f.span.End()
+	})
+}
+
+type reader struct {
+	file.Reader
+	fileSpan
+}
+
+func (r *reader) Close() error {
+	err := r.Reader.Close()
+	r.end(r.Stats(), err)
+	return err
+}
+
+type globReader struct {
+	reader
+}
+
+func (r *globReader) Line() (string, int64) {
+	return r.Reader.(*file.GlobReader).Line()
+}
+
+type writer struct {
+	file.Writer
+	fileSpan
+}
+
+func (w *writer) Close() error {
+	err := w.Writer.Close()
+	w.end(w.Stats(), err)
+	return err
+}
+
+func (w *writer) Abort() error {
+	err := w.Writer.Abort()
+	w.span.SetAttributes(attribute.Bool("file.aborted", true))
+	w.end(w.Stats(), err)
+	return err
+}
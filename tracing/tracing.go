@@ -0,0 +1,119 @@
+// Package tracing propagates OpenTelemetry trace context between
+// flowlord and the workers through the task meta, so all tasks
+// of a workflow run are part of the same trace.
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scope = "github.com/pcelvng/task-tools"
+
+// Meta keys of the W3C trace context
+// see https://www.w3.org/TR/trace-context/
+const (
+	TraceParent = "traceparent"
+	TraceState  = "tracestate"
+)
+
+// propagator is used directly (not the otel global) so the trace
+// context is passed on even when this app does not export spans.
+var propagator = propagation.TraceContext{}
+
+type Options struct {
+	Endpoint string  `toml:"endpoint" comment:"OTLP http collector host:port (localhost:4318), tracing is disabled if blank"`
+	URLPath  string  `toml:"url_path" commented:"true" comment:"collector traces path, default is /v1/traces"`
+	Insecure bool    `toml:"insecure" comment:"send spans over http instead of https"`
+	Sample   float64 `toml:"sample" commented:"true" comment:"ratio of new traces to sample (0-1], default is 1"`
+}
+
+// Init sets the global tracer provider to export spans to the OTLP collector.
+// Spans are not recorded if the endpoint is blank. The returned shutdown
+// flushes any remaining spans and should be called before the app exits.
+func Init(ctx context.Context, service string, opts Options) (shutdown func(context.Context) error, err error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.URLPath != "" {
+		exOpts = append(exOpts, otlptracehttp.WithURLPath(opts.URLPath))
+	}
+	if opts.Insecure {
+		exOpts = append(exOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exOpts...)
+	if err != nil {
+		return nil, err
+	}
+	sample := opts.Sample
+	if sample <= 0 || sample > 1 {
+		sample = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sample))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start a span from the global tracer provider
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(scope).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Inject sets the trace context of ctx in the task meta.
+// Nothing is set if ctx does not have a valid span.
+func Inject(ctx context.Context, meta url.Values) {
+	propagator.Inject(ctx, metaCarrier(meta))
+}
+
+// InjectMeta appends the trace context of ctx to an encoded task meta
+func InjectMeta(ctx context.Context, meta string) string {
+	v := make(url.Values)
+	Inject(ctx, v)
+	if len(v) == 0 {
+		return meta
+	}
+	if meta == "" {
+		return v.Encode()
+	}
+	return meta + "&" + v.Encode()
+}
+
+// Extract returns a context with the remote span of the task meta
+// that is used as the parent of the next started span.
+func Extract(ctx context.Context, meta url.Values) context.Context {
+	return propagator.Extract(ctx, metaCarrier(meta))
+}
+
+// ExtractMeta is Extract from an encoded task meta
+func ExtractMeta(ctx context.Context, meta string) context.Context {
+	v, _ := url.ParseQuery(meta)
+	return Extract(ctx, v)
+}
+
+// metaCarrier adapts the task meta to a propagation.TextMapCarrier
+type metaCarrier url.Values
+
+func (c metaCarrier) Get(key string) string { return url.Values(c).Get(key) }
+
+func (c metaCarrier) Set(key, value string) { url.Values(c).Set(key, value) }
+
+func (c metaCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
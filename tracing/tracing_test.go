@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hydronica/trial"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recorder sets the global tracer provider to record spans in memory
+func recorder(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return sr
+}
+
+func TestInjectMeta(t *testing.T) {
+	sr := recorder(t)
+	ctx, span := Start(context.Background(), "parent")
+	span.End()
+	sc := span.SpanContext()
+	traceparent := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+
+	fn := func(in string) (string, error) {
+		return InjectMeta(ctx, in), nil
+	}
+	cases := trial.Cases[string, string]{
+		"empty": {
+			Input:    "",
+			Expected: "traceparent=" + traceparent,
+		},
+		"append": {
+			Input:    "workflow=f.toml&job=j1",
+			Expected: "workflow=f.toml&job=j1&traceparent=" + traceparent,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// the extracted context is the parent of the next span
+	_, child := Start(ExtractMeta(context.Background(), "workflow=f.toml&traceparent="+traceparent), "child")
+	child.End()
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+	if spans[1].Parent().SpanID() != sc.SpanID() || spans[1].SpanContext().TraceID() != sc.TraceID() {
+		t.Errorf("child parent %v != %v", spans[1].Parent().SpanID(), sc.SpanID())
+	}
+}
+
+func TestInject_noSpan(t *testing.T) {
+	meta := url.Values{"job": {"j1"}}
+	Inject(context.Background(), meta)
+	if len(meta) != 1 {
+		t.Errorf("unexpected meta %v", meta)
+	}
+	if s := InjectMeta(context.Background(), "job=j1"); s != "job=j1" {
+		t.Errorf("unexpected meta %q", s)
+	}
+	if sc := trace.SpanContextFromContext(ExtractMeta(context.Background(), "job=j1")); sc.IsValid() {
+		t.Errorf("unexpected span %v", sc)
+	}
+}
+
+func TestFileSpans(t *testing.T) {
+	sr := recorder(t)
+	ctx, span := Start(context.Background(), "task")
+	pth := t.TempDir() + "/data.txt"
+
+	w, err := NewWriter(ctx, pth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteLine([]byte("line1"))
+	w.WriteLine([]byte("line2"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(ctx, pth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err = r.ReadLine(); err == nil; _, err = r.ReadLine() {
+	}
+	r.Close()
+	r.Close() // the span only ends once
+	if _, err := NewReader(ctx, pth+".missing", nil); err == nil {
+		t.Error("expected missing file error")
+	}
+	g, err := NewGlobReader(ctx, pth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReadLine()
+	if f, n := g.(interface{ Line() (string, int64) }).Line(); f != pth || n != 1 {
+		t.Errorf("unexpected line %s:%d", f, n)
+	}
+	g.Close()
+	span.End()
+
+	type output struct {
+		Name   string
+		Lines  int64
+		Error  bool
+		Parent bool
+	}
+	var got []output
+	for _, s := range sr.Ended()[:4] {
+		o := output{Name: s.Name(), Error: s.Status().Code != 0, Parent: s.Parent().SpanID() == span.SpanContext().SpanID()}
+		for _, a := range s.Attributes() {
+			if a.Key == "file.lines" {
+				o.Lines = a.Value.AsInt64()
+			}
+		}
+		got = append(got, o)
+	}
+	exp := []output{
+		{Name: "file.write", Lines: 2, Parent: true},
+		{Name: "file.read", Lines: 2, Parent: true},
+		{Name: "file.read", Error: true, Parent: true},
+		{Name: "file.read", Lines: 1, Parent: true},
+	}
+	if eq, diff := trial.Equal(got, exp); !eq {
+		t.Error(diff)
+	}
+}
+
+func TestInit(t *testing.T) {
+	// local collector stand-in
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			requests.Add(1)
+		}
+	}))
+	defer srv.Close()
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	shutdown, err := Init(context.Background(), "test", Options{Endpoint: strings.TrimPrefix(srv.URL, "http://"), Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "export")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() == 0 {
+		t.Error("expected spans sent to the collector")
+	}
+
+	// disabled without an endpoint
+	shutdown, err = Init(context.Background(), "test", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}